@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/models"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/utils"
+	"github.com/spf13/cobra"
+)
+
+var rotateKeyAlgorithm string
+
+var rotateKeysCommand = &cobra.Command{
+	Use:   "keys:rotate",
+	Short: "Generate a new JWT signing key and retire the current one",
+	Run: func(cmd *cobra.Command, args []string) {
+
+		models.MigrateTable()
+
+		key, err := models.RotateSigningKey(context.Background(), rotateKeyAlgorithm)
+		if err != nil {
+			fmt.Println("Failed to rotate signing key:", err)
+			return
+		}
+
+		fmt.Printf("Signing key %s (%s) is now active\n", key.Kid, key.Algorithm)
+	},
+}
+
+func init() {
+	rotateKeysCommand.Flags().StringVar(&rotateKeyAlgorithm, "alg", utils.AlgorithmRS256, "signing algorithm, RS256 or EdDSA")
+	rootCmd.AddCommand(rotateKeysCommand)
+}
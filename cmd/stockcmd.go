@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/models"
+	"github.com/spf13/cobra"
+)
+
+var rebuildStockFix bool
+
+var rebuildStockCommand = &cobra.Command{
+	Use:   "stock:rebuild",
+	Short: "Compare on-hand stock with the movement ledger and report drift",
+	Run: func(cmd *cobra.Command, args []string) {
+
+		drifts, err := models.RebuildStockBalances(context.Background(), rebuildStockFix)
+		if err != nil {
+			fmt.Println("Failed to rebuild stock balances:", err)
+			return
+		}
+
+		for _, drift := range drifts {
+			fmt.Printf("branch %d product %d variation %d: on hand %.3f, ledger %.3f\n",
+				drift.BranchId, drift.ProductId, drift.ProductVariationId, drift.OnHand, drift.LedgerOnHand)
+		}
+
+		switch {
+		case len(drifts) == 0:
+			fmt.Println("Stock balances match the ledger")
+		case rebuildStockFix:
+			fmt.Printf("%d stock balances reset to the ledger\n", len(drifts))
+		default:
+			fmt.Printf("%d stock balances drifted, run with --fix to reset them\n", len(drifts))
+		}
+	},
+}
+
+func init() {
+	rebuildStockCommand.Flags().BoolVar(&rebuildStockFix, "fix", false, "reset drifted balances to the ledger")
+	rootCmd.AddCommand(rebuildStockCommand)
+}
@@ -0,0 +1,38 @@
+package directives
+
+import (
+	"context"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/middlewares"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/models"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+func HasPermission(ctx context.Context, obj interface{}, next graphql.Resolver, module string, action string) (interface{}, error) {
+	tokenData := middlewares.CtxValue(ctx)
+	if tokenData == nil {
+		return nil, &gqlerror.Error{
+			Message: "Access Denied",
+		}
+	}
+
+	var allowed bool
+	var err error
+	if tokenData.ApiKeyId > 0 {
+		// API keys carry their role directly instead of belonging to a user
+		allowed, err = models.RoleHasPermission(ctx, tokenData.RoleId, module, action)
+	} else {
+		allowed, err = models.HasPermission(ctx, tokenData.ID, module, action)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, &gqlerror.Error{
+			Message: "Permission Denied",
+		}
+	}
+
+	return next(ctx)
+}
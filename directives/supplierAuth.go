@@ -0,0 +1,20 @@
+package directives
+
+import (
+	"context"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/middlewares"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+func SupplierAuth(ctx context.Context, obj interface{}, next graphql.Resolver) (interface{}, error) {
+	tokenData := middlewares.SupplierCtxValue(ctx)
+	if tokenData == nil {
+		return nil, &gqlerror.Error{
+			Message: "Access Denied",
+		}
+	}
+
+	return next(ctx)
+}
@@ -0,0 +1,22 @@
+package directives
+
+import (
+	"context"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/middlewares"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/utils"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+// UserAuth is Auth for fields that act as a person: an API key is not a user, so it is refused.
+func UserAuth(ctx context.Context, obj interface{}, next graphql.Resolver) (interface{}, error) {
+	tokenData := middlewares.CtxValue(ctx)
+	if tokenData == nil || tokenData.SubjectType == utils.SubjectApiKey {
+		return nil, &gqlerror.Error{
+			Message: "Access Denied",
+		}
+	}
+
+	return next(ctx)
+}
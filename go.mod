@@ -6,6 +6,7 @@ require (
 	github.com/99designs/gqlgen v0.17.40
 	github.com/gin-contrib/cors v1.4.0
 	github.com/go-playground/validator/v10 v10.14.0
+	github.com/golang-jwt/jwt/v4 v4.5.2
 	github.com/graph-gophers/dataloader/v7 v7.1.0
 	github.com/minio/minio-go/v7 v7.0.66
 	github.com/spf13/cobra v1.8.0
@@ -65,7 +66,6 @@ require (
 require (
 	github.com/agnivade/levenshtein v1.1.1 // indirect
 	github.com/cpuguy83/go-md2man/v2 v2.0.3 // indirect
-	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/gin-gonic/gin v1.9.1
 	github.com/google/uuid v1.5.0 // indirect
 	github.com/gorilla/websocket v1.5.0 // indirect
@@ -7,12 +7,26 @@ package graph
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/99designs/gqlgen/graphql"
 	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/middlewares"
 	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/models"
 )
 
+// Role is the resolver for the role field.
+func (r *apiKeyResolver) Role(ctx context.Context, obj *models.ApiKey) (*models.Role, error) {
+	return middlewares.GetRole(ctx, obj.RoleId)
+}
+
+// Branch is the resolver for the branch field.
+func (r *apiKeyResolver) Branch(ctx context.Context, obj *models.ApiKey) (*models.Branch, error) {
+	if obj.BranchId == 0 {
+		return nil, nil
+	}
+	return middlewares.GetBranch(ctx, obj.BranchId)
+}
+
 // ParentCategory is the resolver for the parentCategory field.
 func (r *categoryResolver) ParentCategory(ctx context.Context, obj *models.Category) (*models.Category, error) {
 	return middlewares.GetCategory(ctx, obj.ParentCategoryId)
@@ -35,19 +49,197 @@ func (r *categoryResolver) Products(ctx context.Context, obj *models.Category) (
 	return productResults, nil
 }
 
+// PurchaseOrder is the resolver for the purchaseOrder field.
+func (r *goodsReceiptResolver) PurchaseOrder(ctx context.Context, obj *models.GoodsReceipt) (*models.PurchaseOrder, error) {
+	if obj.PurchaseOrderId == 0 {
+		return nil, nil
+	}
+	return models.GetPurchaseOrder(ctx, middlewares.BranchClaimValue(ctx), obj.PurchaseOrderId)
+}
+
+// Supplier is the resolver for the supplier field.
+func (r *goodsReceiptResolver) Supplier(ctx context.Context, obj *models.GoodsReceipt) (*models.Supplier, error) {
+	return middlewares.GetSupplier(ctx, obj.SupplierId)
+}
+
+// Branch is the resolver for the branch field.
+func (r *goodsReceiptResolver) Branch(ctx context.Context, obj *models.GoodsReceipt) (*models.Branch, error) {
+	return middlewares.GetBranch(ctx, obj.BranchId)
+}
+
+// ProductVariation is the resolver for the productVariation field.
+func (r *goodsReceiptLineResolver) ProductVariation(ctx context.Context, obj *models.GoodsReceiptLine) (*models.ProductVariation, error) {
+	if obj.ProductVariationId == 0 {
+		return nil, nil
+	}
+	return middlewares.GetProductVariation(ctx, obj.ProductVariationId)
+}
+
+// Uom is the resolver for the uom field.
+func (r *goodsReceiptLineResolver) Uom(ctx context.Context, obj *models.GoodsReceiptLine) (*models.UnitOfMeasure, error) {
+	if obj.UomId == 0 {
+		return nil, nil
+	}
+	return middlewares.GetUnitOfMeasure(ctx, obj.UomId)
+}
+
 // OwnerID is the resolver for the owner_id field.
 func (r *imageResolver) OwnerID(ctx context.Context, obj *models.Image) (*int, error) {
 	panic(fmt.Errorf("not implemented: OwnerID - owner_id"))
 }
 
+// Category is the resolver for the category field.
+func (r *inventoryValuationGroupResolver) Category(ctx context.Context, obj *models.InventoryValuationGroup) (*models.Category, error) {
+	if obj.CategoryId == 0 {
+		return nil, nil
+	}
+	return middlewares.GetCategory(ctx, obj.CategoryId)
+}
+
+// Role is the resolver for the role field.
+func (r *inviteResolver) Role(ctx context.Context, obj *models.Invite) (*models.Role, error) {
+	return middlewares.GetRole(ctx, obj.RoleId)
+}
+
+// Branches is the resolver for the branches field.
+func (r *inviteResolver) Branches(ctx context.Context, obj *models.Invite) ([]*models.Branch, error) {
+	branches, errs := middlewares.GetBranches(ctx, obj.BranchIds)
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return branches, nil
+}
+
+// Branch is the resolver for the branch field.
+func (r *lowStockItemResolver) Branch(ctx context.Context, obj *models.LowStockItem) (*models.Branch, error) {
+	return middlewares.GetBranch(ctx, obj.BranchId)
+}
+
 // Login is the resolver for the login field.
 func (r *mutationResolver) Login(ctx context.Context, username string, password string) (*models.LoginInfo, error) {
-	return models.Login(ctx, username, password)
+	return models.Login(ctx, username, password, middlewares.ClientInfoValue(ctx))
+}
+
+// VerifyLoginTotp is the resolver for the verifyLoginTotp field.
+func (r *mutationResolver) VerifyLoginTotp(ctx context.Context, challengeToken string, code string) (*models.LoginInfo, error) {
+	return models.VerifyLoginTotp(ctx, challengeToken, code, middlewares.ClientInfoValue(ctx))
+}
+
+// SupplierLogin is the resolver for the supplierLogin field.
+func (r *mutationResolver) SupplierLogin(ctx context.Context, username string, password string) (*models.SupplierLoginInfo, error) {
+	return models.SupplierLogin(ctx, username, password, middlewares.ClientInfoValue(ctx))
+}
+
+// RefreshToken is the resolver for the refreshToken field.
+func (r *mutationResolver) RefreshToken(ctx context.Context, refreshToken string) (*models.LoginInfo, error) {
+	return models.RefreshToken(ctx, refreshToken)
+}
+
+// SwitchBranch is the resolver for the switchBranch field.
+func (r *mutationResolver) SwitchBranch(ctx context.Context, branchID int) (*models.LoginInfo, error) {
+	tokenData := middlewares.CtxValue(ctx)
+	return models.SwitchBranch(ctx, tokenData.ID, tokenData.SessionId, branchID)
+}
+
+// Logout is the resolver for the logout field.
+func (r *mutationResolver) Logout(ctx context.Context) (bool, error) {
+	return models.Logout(ctx, middlewares.CtxValue(ctx).SessionId)
+}
+
+// LogoutAllSessions is the resolver for the logoutAllSessions field.
+func (r *mutationResolver) LogoutAllSessions(ctx context.Context) (bool, error) {
+	return models.LogoutAllSessions(ctx, middlewares.CtxValue(ctx).ID)
+}
+
+// ChangePassword is the resolver for the changePassword field.
+func (r *mutationResolver) ChangePassword(ctx context.Context, oldPassword string, newPassword string) (bool, error) {
+	tokenData := middlewares.CtxValue(ctx)
+	return models.ChangePassword(ctx, tokenData.ID, tokenData.SessionId, oldPassword, newPassword)
+}
+
+// ResetUserPassword is the resolver for the resetUserPassword field.
+func (r *mutationResolver) ResetUserPassword(ctx context.Context, userID int) (*models.PasswordResetToken, error) {
+	claim := middlewares.CtxValue(ctx)
+	return models.ResetUserPassword(ctx, userID, claim.ID, claim.RoleId)
+}
+
+// CompletePasswordReset is the resolver for the completePasswordReset field.
+func (r *mutationResolver) CompletePasswordReset(ctx context.Context, token string, newPassword string) (bool, error) {
+	return models.CompletePasswordReset(ctx, token, newPassword)
+}
+
+// EnrollTotp is the resolver for the enrollTotp field.
+func (r *mutationResolver) EnrollTotp(ctx context.Context) (*models.TotpEnrollment, error) {
+	return models.EnrollTotp(ctx, middlewares.CtxValue(ctx).ID)
+}
+
+// ConfirmTotp is the resolver for the confirmTotp field.
+func (r *mutationResolver) ConfirmTotp(ctx context.Context, code string) ([]string, error) {
+	return models.ConfirmTotp(ctx, middlewares.CtxValue(ctx).ID, code)
+}
+
+// DisableTotp is the resolver for the disableTotp field.
+func (r *mutationResolver) DisableTotp(ctx context.Context, code string) (bool, error) {
+	return models.DisableTotp(ctx, middlewares.CtxValue(ctx).ID, code)
+}
+
+// SetUserBranches is the resolver for the setUserBranches field.
+func (r *mutationResolver) SetUserBranches(ctx context.Context, userID int, branchIds []int) (*models.User, error) {
+	return models.SetUserBranches(ctx, userID, branchIds)
+}
+
+// UnlockUser is the resolver for the unlockUser field.
+func (r *mutationResolver) UnlockUser(ctx context.Context, userID int) (bool, error) {
+	return models.UnlockUser(ctx, userID, middlewares.CtxValue(ctx).ID)
 }
 
 // Register is the resolver for the register field.
 func (r *mutationResolver) Register(ctx context.Context, input models.NewUser) (*models.User, error) {
-	return models.CreateUser(ctx, &input)
+	claim := middlewares.CtxValue(ctx)
+	return models.CreateUser(ctx, &input, claim.ID, claim.RoleId)
+}
+
+// CreateInvite is the resolver for the createInvite field.
+func (r *mutationResolver) CreateInvite(ctx context.Context, input models.NewInvite) (*models.InviteCreated, error) {
+	claim := middlewares.CtxValue(ctx)
+	return models.CreateInvite(ctx, &input, claim.ID, claim.RoleId)
+}
+
+// RevokeInvite is the resolver for the revokeInvite field.
+func (r *mutationResolver) RevokeInvite(ctx context.Context, id int) (*models.Invite, error) {
+	return models.RevokeInvite(ctx, id)
+}
+
+// AcceptInvite is the resolver for the acceptInvite field.
+func (r *mutationResolver) AcceptInvite(ctx context.Context, token string, username string, password string, name *string) (*models.User, error) {
+	inviteeName := ""
+	if name != nil {
+		inviteeName = *name
+	}
+	return models.AcceptInvite(ctx, token, username, password, inviteeName)
+}
+
+// UpdateMyProfile is the resolver for the updateMyProfile field.
+func (r *mutationResolver) UpdateMyProfile(ctx context.Context, input models.UpdateProfileInput) (*models.User, error) {
+	return models.UpdateMyProfile(ctx, middlewares.CtxValue(ctx).ID, &input)
+}
+
+// UpdateUser is the resolver for the updateUser field.
+func (r *mutationResolver) UpdateUser(ctx context.Context, id int, input models.UpdateUserInput) (*models.User, error) {
+	claim := middlewares.CtxValue(ctx)
+	return models.UpdateUser(ctx, id, &input, claim.ID, claim.RoleId)
+}
+
+// SetUserActive is the resolver for the setUserActive field.
+func (r *mutationResolver) SetUserActive(ctx context.Context, id int, isActive bool) (*models.User, error) {
+	return models.SetUserActive(ctx, id, isActive)
+}
+
+// DeleteUser is the resolver for the deleteUser field.
+func (r *mutationResolver) DeleteUser(ctx context.Context, id int) (*models.User, error) {
+	return models.DeleteUser(ctx, id)
 }
 
 // CreateBranch is the resolver for the createBranch field.
@@ -67,17 +259,20 @@ func (r *mutationResolver) DeleteBranch(ctx context.Context, id int) (*models.Br
 
 // CreateRole is the resolver for the createRole field.
 func (r *mutationResolver) CreateRole(ctx context.Context, input models.NewRole) (*models.Role, error) {
-	return models.CreateRole(ctx, &input)
+	claim := middlewares.CtxValue(ctx)
+	return models.CreateRole(ctx, &input, claim.ID, claim.RoleId)
 }
 
 // UpdateRole is the resolver for the updateRole field.
 func (r *mutationResolver) UpdateRole(ctx context.Context, id int, input models.NewRole) (*models.Role, error) {
-	return models.UpdateRole(ctx, id, &input)
+	claim := middlewares.CtxValue(ctx)
+	return models.UpdateRole(ctx, id, &input, claim.ID, claim.RoleId)
 }
 
 // DeleteRole is the resolver for the deleteRole field.
 func (r *mutationResolver) DeleteRole(ctx context.Context, id int) (*models.Role, error) {
-	return models.DeleteRole(ctx, id)
+	claim := middlewares.CtxValue(ctx)
+	return models.DeleteRole(ctx, id, claim.ID, claim.RoleId)
 }
 
 // CreateCategory is the resolver for the createCategory field.
@@ -135,6 +330,227 @@ func (r *mutationResolver) DeleteProduct(ctx context.Context, id int) (*models.P
 	return models.DeleteProduct(ctx, id)
 }
 
+// SetRolePermissions is the resolver for the setRolePermissions field.
+func (r *mutationResolver) SetRolePermissions(ctx context.Context, roleID int, permissions []*models.NewRoleModule) (*models.Role, error) {
+	claim := middlewares.CtxValue(ctx)
+	return models.SetRolePermissions(ctx, roleID, permissions, claim.ID, claim.RoleId)
+}
+
+// GrantRolePermissions is the resolver for the grantRolePermissions field.
+func (r *mutationResolver) GrantRolePermissions(ctx context.Context, roleID int, permissions []*models.NewRoleModule) (*models.Role, error) {
+	claim := middlewares.CtxValue(ctx)
+	return models.GrantRolePermissions(ctx, roleID, permissions, claim.ID, claim.RoleId)
+}
+
+// RevokeRolePermissions is the resolver for the revokeRolePermissions field.
+func (r *mutationResolver) RevokeRolePermissions(ctx context.Context, roleID int, permissions []*models.NewRoleModule) (*models.Role, error) {
+	claim := middlewares.CtxValue(ctx)
+	return models.RevokeRolePermissions(ctx, roleID, permissions, claim.ID, claim.RoleId)
+}
+
+// CreateAPIKey is the resolver for the createApiKey field.
+func (r *mutationResolver) CreateAPIKey(ctx context.Context, input models.NewApiKey) (*models.ApiKeyCreated, error) {
+	claim := middlewares.CtxValue(ctx)
+	return models.CreateApiKey(ctx, &input, claim.ID, claim.RoleId)
+}
+
+// RevokeAPIKey is the resolver for the revokeApiKey field.
+func (r *mutationResolver) RevokeAPIKey(ctx context.Context, id int) (*models.ApiKey, error) {
+	return models.RevokeApiKey(ctx, id)
+}
+
+// CreateTransfer is the resolver for the createTransfer field.
+func (r *mutationResolver) CreateTransfer(ctx context.Context, input models.NewTransfer) (*models.Transfer, error) {
+	return models.CreateTransfer(ctx, middlewares.BranchClaimValue(ctx), &input, middlewares.CtxValue(ctx).ID)
+}
+
+// UpdateTransfer is the resolver for the updateTransfer field.
+func (r *mutationResolver) UpdateTransfer(ctx context.Context, id int, input models.NewTransfer) (*models.Transfer, error) {
+	return models.UpdateTransfer(ctx, middlewares.BranchClaimValue(ctx), id, &input)
+}
+
+// CancelTransfer is the resolver for the cancelTransfer field.
+func (r *mutationResolver) CancelTransfer(ctx context.Context, id int) (*models.Transfer, error) {
+	return models.CancelTransfer(ctx, middlewares.BranchClaimValue(ctx), id)
+}
+
+// DispatchTransfer is the resolver for the dispatchTransfer field.
+func (r *mutationResolver) DispatchTransfer(ctx context.Context, id int, lines []*models.TransferLineQuantity) (*models.Transfer, error) {
+	return models.DispatchTransfer(ctx, middlewares.BranchClaimValue(ctx), id, lines, middlewares.CtxValue(ctx).ID)
+}
+
+// ReceiveTransfer is the resolver for the receiveTransfer field.
+func (r *mutationResolver) ReceiveTransfer(ctx context.Context, id int, lines []*models.TransferLineQuantity, close *bool) (*models.Transfer, error) {
+	return models.ReceiveTransfer(ctx, middlewares.BranchClaimValue(ctx), id, lines, close != nil && *close, middlewares.CtxValue(ctx).ID)
+}
+
+// CreateAdjustmentReason is the resolver for the createAdjustmentReason field.
+func (r *mutationResolver) CreateAdjustmentReason(ctx context.Context, input models.NewAdjustmentReason) (*models.AdjustmentReason, error) {
+	return models.CreateAdjustmentReason(ctx, &input)
+}
+
+// UpdateAdjustmentReason is the resolver for the updateAdjustmentReason field.
+func (r *mutationResolver) UpdateAdjustmentReason(ctx context.Context, id int, input models.NewAdjustmentReason) (*models.AdjustmentReason, error) {
+	return models.UpdateAdjustmentReason(ctx, id, &input)
+}
+
+// CreateStockAdjustment is the resolver for the createStockAdjustment field.
+func (r *mutationResolver) CreateStockAdjustment(ctx context.Context, input models.NewStockAdjustment) (*models.StockAdjustment, error) {
+	claim := middlewares.CtxValue(ctx)
+	return models.CreateStockAdjustment(ctx, middlewares.BranchClaimValue(ctx), &input, claim.ID, claim.RoleId)
+}
+
+// ApproveStockAdjustment is the resolver for the approveStockAdjustment field.
+func (r *mutationResolver) ApproveStockAdjustment(ctx context.Context, id int, note *string) (*models.StockAdjustment, error) {
+	return models.ApproveStockAdjustment(ctx, middlewares.BranchClaimValue(ctx), id, note, middlewares.CtxValue(ctx).ID)
+}
+
+// RejectStockAdjustment is the resolver for the rejectStockAdjustment field.
+func (r *mutationResolver) RejectStockAdjustment(ctx context.Context, id int, note *string) (*models.StockAdjustment, error) {
+	return models.RejectStockAdjustment(ctx, middlewares.BranchClaimValue(ctx), id, note, middlewares.CtxValue(ctx).ID)
+}
+
+// StartStockCount is the resolver for the startStockCount field.
+func (r *mutationResolver) StartStockCount(ctx context.Context, input models.NewStockCount) (*models.StockCount, error) {
+	return models.StartStockCount(ctx, middlewares.BranchClaimValue(ctx), &input, middlewares.CtxValue(ctx).ID)
+}
+
+// RecordStockCount is the resolver for the recordStockCount field.
+func (r *mutationResolver) RecordStockCount(ctx context.Context, id int, entries []*models.StockCountEntry, replace *bool) ([]*models.StockCountLine, error) {
+	return models.RecordStockCount(ctx, middlewares.BranchClaimValue(ctx), id, entries, replace != nil && *replace, middlewares.CtxValue(ctx).ID)
+}
+
+// PostStockCount is the resolver for the postStockCount field.
+func (r *mutationResolver) PostStockCount(ctx context.Context, id int, reasonID int, zeroUncounted *bool) (*models.StockCount, error) {
+	claim := middlewares.CtxValue(ctx)
+	return models.PostStockCount(ctx, middlewares.BranchClaimValue(ctx), id, reasonID, zeroUncounted != nil && *zeroUncounted, claim.ID, claim.RoleId)
+}
+
+// CancelStockCount is the resolver for the cancelStockCount field.
+func (r *mutationResolver) CancelStockCount(ctx context.Context, id int) (*models.StockCount, error) {
+	return models.CancelStockCount(ctx, middlewares.BranchClaimValue(ctx), id)
+}
+
+// SetReorderRule is the resolver for the setReorderRule field.
+func (r *mutationResolver) SetReorderRule(ctx context.Context, input models.NewReorderRule) (*models.ReorderRule, error) {
+	return models.SetReorderRule(ctx, middlewares.BranchClaimValue(ctx), &input)
+}
+
+// BulkSetReorderRules is the resolver for the bulkSetReorderRules field.
+func (r *mutationResolver) BulkSetReorderRules(ctx context.Context, input models.BulkReorderRule) (int, error) {
+	return models.BulkSetReorderRules(ctx, middlewares.BranchClaimValue(ctx), &input)
+}
+
+// DeleteReorderRule is the resolver for the deleteReorderRule field.
+func (r *mutationResolver) DeleteReorderRule(ctx context.Context, id int) (*models.ReorderRule, error) {
+	return models.DeleteReorderRule(ctx, middlewares.BranchClaimValue(ctx), id)
+}
+
+// MarkNotificationRead is the resolver for the markNotificationRead field.
+func (r *mutationResolver) MarkNotificationRead(ctx context.Context, id int) (*models.Notification, error) {
+	return models.MarkNotificationRead(ctx, middlewares.BranchClaimValue(ctx), id, middlewares.CtxValue(ctx).ID)
+}
+
+// QuarantineLot is the resolver for the quarantineLot field.
+func (r *mutationResolver) QuarantineLot(ctx context.Context, id int, reason string) (*models.StockLot, error) {
+	return models.QuarantineLot(ctx, middlewares.BranchClaimValue(ctx), id, reason, middlewares.CtxValue(ctx).ID)
+}
+
+// ReleaseLot is the resolver for the releaseLot field.
+func (r *mutationResolver) ReleaseLot(ctx context.Context, id int) (*models.StockLot, error) {
+	return models.ReleaseLot(ctx, middlewares.BranchClaimValue(ctx), id, middlewares.CtxValue(ctx).ID)
+}
+
+// CreateUnitOfMeasure is the resolver for the createUnitOfMeasure field.
+func (r *mutationResolver) CreateUnitOfMeasure(ctx context.Context, input models.NewUnitOfMeasure) (*models.UnitOfMeasure, error) {
+	return models.CreateUnitOfMeasure(ctx, &input)
+}
+
+// UpdateUnitOfMeasure is the resolver for the updateUnitOfMeasure field.
+func (r *mutationResolver) UpdateUnitOfMeasure(ctx context.Context, id int, input models.NewUnitOfMeasure) (*models.UnitOfMeasure, error) {
+	return models.UpdateUnitOfMeasure(ctx, id, &input)
+}
+
+// DeleteUnitOfMeasure is the resolver for the deleteUnitOfMeasure field.
+func (r *mutationResolver) DeleteUnitOfMeasure(ctx context.Context, id int) (*models.UnitOfMeasure, error) {
+	return models.DeleteUnitOfMeasure(ctx, id)
+}
+
+// SetProductUnits is the resolver for the setProductUnits field.
+func (r *mutationResolver) SetProductUnits(ctx context.Context, productID int, input models.ProductUnits) ([]*models.ProductUnit, error) {
+	return models.SetProductUnits(ctx, productID, &input)
+}
+
+// CreateReservation is the resolver for the createReservation field.
+func (r *mutationResolver) CreateReservation(ctx context.Context, input models.NewReservation) (*models.Reservation, error) {
+	return models.CreateReservation(ctx, middlewares.BranchClaimValue(ctx), &input, middlewares.CtxValue(ctx).ID)
+}
+
+// ReleaseReservation is the resolver for the releaseReservation field.
+func (r *mutationResolver) ReleaseReservation(ctx context.Context, id int) (*models.Reservation, error) {
+	return models.ReleaseReservation(ctx, middlewares.BranchClaimValue(ctx), id, middlewares.CtxValue(ctx).ID)
+}
+
+// FulfilReservation is the resolver for the fulfilReservation field.
+func (r *mutationResolver) FulfilReservation(ctx context.Context, id int) (*models.Reservation, error) {
+	return models.FulfilReservation(ctx, middlewares.BranchClaimValue(ctx), id, middlewares.CtxValue(ctx).ID)
+}
+
+// CreatePurchaseOrder is the resolver for the createPurchaseOrder field.
+func (r *mutationResolver) CreatePurchaseOrder(ctx context.Context, input models.NewPurchaseOrder) (*models.PurchaseOrder, error) {
+	return models.CreatePurchaseOrder(ctx, middlewares.BranchClaimValue(ctx), &input, middlewares.CtxValue(ctx).ID)
+}
+
+// UpdatePurchaseOrder is the resolver for the updatePurchaseOrder field.
+func (r *mutationResolver) UpdatePurchaseOrder(ctx context.Context, id int, input models.NewPurchaseOrder) (*models.PurchaseOrder, error) {
+	return models.UpdatePurchaseOrder(ctx, middlewares.BranchClaimValue(ctx), id, &input)
+}
+
+// DeletePurchaseOrder is the resolver for the deletePurchaseOrder field.
+func (r *mutationResolver) DeletePurchaseOrder(ctx context.Context, id int) (*models.PurchaseOrder, error) {
+	return models.DeletePurchaseOrder(ctx, middlewares.BranchClaimValue(ctx), id)
+}
+
+// SubmitPurchaseOrder is the resolver for the submitPurchaseOrder field.
+func (r *mutationResolver) SubmitPurchaseOrder(ctx context.Context, id int) (*models.PurchaseOrder, error) {
+	return models.SubmitPurchaseOrder(ctx, middlewares.BranchClaimValue(ctx), id, middlewares.CtxValue(ctx).ID)
+}
+
+// ApprovePurchaseOrder is the resolver for the approvePurchaseOrder field.
+func (r *mutationResolver) ApprovePurchaseOrder(ctx context.Context, id int) (*models.PurchaseOrder, error) {
+	return models.ApprovePurchaseOrder(ctx, middlewares.BranchClaimValue(ctx), id, middlewares.CtxValue(ctx).ID)
+}
+
+// CancelPurchaseOrder is the resolver for the cancelPurchaseOrder field.
+func (r *mutationResolver) CancelPurchaseOrder(ctx context.Context, id int, reason string) (*models.PurchaseOrder, error) {
+	return models.CancelPurchaseOrder(ctx, middlewares.BranchClaimValue(ctx), id, reason, middlewares.CtxValue(ctx).ID)
+}
+
+// ClosePurchaseOrder is the resolver for the closePurchaseOrder field.
+func (r *mutationResolver) ClosePurchaseOrder(ctx context.Context, id int) (*models.PurchaseOrder, error) {
+	return models.ClosePurchaseOrder(ctx, middlewares.BranchClaimValue(ctx), id, middlewares.CtxValue(ctx).ID)
+}
+
+// CreateGoodsReceipt is the resolver for the createGoodsReceipt field.
+func (r *mutationResolver) CreateGoodsReceipt(ctx context.Context, input models.NewGoodsReceipt) (*models.GoodsReceipt, error) {
+	return models.CreateGoodsReceipt(ctx, middlewares.BranchClaimValue(ctx), &input, middlewares.CtxValue(ctx).ID)
+}
+
+// SetSupplierProduct is the resolver for the setSupplierProduct field.
+func (r *mutationResolver) SetSupplierProduct(ctx context.Context, input models.NewSupplierProduct) (*models.SupplierProduct, error) {
+	return models.SetSupplierProduct(ctx, &input)
+}
+
+// DeleteSupplierProduct is the resolver for the deleteSupplierProduct field.
+func (r *mutationResolver) DeleteSupplierProduct(ctx context.Context, id int) (*models.SupplierProduct, error) {
+	return models.DeleteSupplierProduct(ctx, id)
+}
+
+// ImportSupplierPriceList is the resolver for the importSupplierPriceList field.
+func (r *mutationResolver) ImportSupplierPriceList(ctx context.Context, supplierID int, lines []*models.SupplierPriceListLine, replace *bool) ([]*models.SupplierProduct, error) {
+	return models.ImportSupplierPriceList(ctx, supplierID, lines, replace != nil && *replace)
+}
+
 // Category is the resolver for the category field.
 func (r *productResolver) Category(ctx context.Context, obj *models.Product) (*models.Category, error) {
 	return middlewares.GetCategory(ctx, obj.CategoryId)
@@ -179,19 +595,73 @@ func (r *productResolver) ProductVariations(ctx context.Context, obj *models.Pro
 	return productResults, nil
 }
 
+// Stock is the resolver for the stock field.
+func (r *productResolver) Stock(ctx context.Context, obj *models.Product) ([]*models.StockLevel, error) {
+	return middlewares.GetProductStockLevels(ctx, obj.ID)
+}
+
+// BaseUom is the resolver for the base_uom field.
+func (r *productResolver) BaseUom(ctx context.Context, obj *models.Product) (*models.UnitOfMeasure, error) {
+	if obj.BaseUomId == 0 {
+		return nil, nil
+	}
+	return middlewares.GetUnitOfMeasure(ctx, obj.BaseUomId)
+}
+
+// Units is the resolver for the units field.
+func (r *productResolver) Units(ctx context.Context, obj *models.Product) ([]*models.ProductUnit, error) {
+	return middlewares.GetProductUnits(ctx, obj.ID)
+}
+
+// Suppliers is the resolver for the suppliers field.
+func (r *productResolver) Suppliers(ctx context.Context, obj *models.Product) ([]*models.SupplierProduct, error) {
+	return middlewares.GetProductSuppliers(ctx, obj.ID)
+}
+
+// Uom is the resolver for the uom field.
+func (r *productUnitResolver) Uom(ctx context.Context, obj *models.ProductUnit) (*models.UnitOfMeasure, error) {
+	return middlewares.GetUnitOfMeasure(ctx, obj.UomId)
+}
+
+// Supplier is the resolver for the supplier field.
+func (r *purchaseOrderResolver) Supplier(ctx context.Context, obj *models.PurchaseOrder) (*models.Supplier, error) {
+	return middlewares.GetSupplier(ctx, obj.SupplierId)
+}
+
+// Branch is the resolver for the branch field.
+func (r *purchaseOrderResolver) Branch(ctx context.Context, obj *models.PurchaseOrder) (*models.Branch, error) {
+	return middlewares.GetBranch(ctx, obj.BranchId)
+}
+
+// ProductVariation is the resolver for the productVariation field.
+func (r *purchaseOrderLineResolver) ProductVariation(ctx context.Context, obj *models.PurchaseOrderLine) (*models.ProductVariation, error) {
+	if obj.ProductVariationId == 0 {
+		return nil, nil
+	}
+	return middlewares.GetProductVariation(ctx, obj.ProductVariationId)
+}
+
+// Uom is the resolver for the uom field.
+func (r *purchaseOrderLineResolver) Uom(ctx context.Context, obj *models.PurchaseOrderLine) (*models.UnitOfMeasure, error) {
+	if obj.UomId == 0 {
+		return nil, nil
+	}
+	return middlewares.GetUnitOfMeasure(ctx, obj.UomId)
+}
+
 // Branch is the resolver for the branch field.
 func (r *queryResolver) Branch(ctx context.Context, id int) (*models.Branch, error) {
-	return models.GetBranch(ctx, id)
+	return models.GetBranch(ctx, middlewares.BranchClaimValue(ctx), id)
 }
 
 // Branches is the resolver for the branches field.
 func (r *queryResolver) Branches(ctx context.Context, name *string, city *string) ([]*models.Branch, error) {
-	return models.GetBranches(ctx, name, city)
+	return models.GetBranches(ctx, middlewares.BranchClaimValue(ctx), name, city)
 }
 
 // BranchPagination is the resolver for the branchPagination field.
 func (r *queryResolver) BranchPagination(ctx context.Context, first *int, after *string) (*models.BranchPagination, error) {
-	return models.GetPaginatedBranches(ctx, first, after)
+	return models.GetPaginatedBranches(ctx, middlewares.BranchClaimValue(ctx), first, after)
 }
 
 // Role is the resolver for the role field.
@@ -204,6 +674,11 @@ func (r *queryResolver) Roles(ctx context.Context, name *string) ([]*models.Role
 	return models.GetRoles(ctx, name)
 }
 
+// PermissionModules is the resolver for the permissionModules field.
+func (r *queryResolver) PermissionModules(ctx context.Context) ([]*models.PermissionModule, error) {
+	return models.GetPermissionModules(), nil
+}
+
 // Category is the resolver for the category field.
 func (r *queryResolver) Category(ctx context.Context, id int) (*models.Category, error) {
 	return models.GetCategory(ctx, id)
@@ -224,6 +699,11 @@ func (r *queryResolver) Suppliers(ctx context.Context, name *string) ([]*models.
 	return models.GetAllSuppliers(ctx, name)
 }
 
+// Me is the resolver for the me field.
+func (r *queryResolver) Me(ctx context.Context) (*models.User, error) {
+	return models.GetUser(ctx, middlewares.CtxValue(ctx).ID)
+}
+
 // User is the resolver for the user field.
 func (r *queryResolver) User(ctx context.Context, id int) (*models.User, error) {
 	return models.GetUser(ctx, id)
@@ -234,6 +714,41 @@ func (r *queryResolver) Users(ctx context.Context, name *string) ([]*models.User
 	return models.GetAllUsers(ctx)
 }
 
+// Invites is the resolver for the invites field.
+func (r *queryResolver) Invites(ctx context.Context, status *string, email *string) ([]*models.Invite, error) {
+	return models.GetInvites(ctx, status, email)
+}
+
+// AccountLockouts is the resolver for the accountLockouts field.
+func (r *queryResolver) AccountLockouts(ctx context.Context, username *string, activeOnly *bool) ([]*models.AccountLockout, error) {
+	return models.GetAccountLockouts(ctx, username, activeOnly)
+}
+
+// AuditLogs is the resolver for the auditLogs field.
+func (r *queryResolver) AuditLogs(ctx context.Context, entityType *string, entityID *int, actorID *int, from *time.Time, to *time.Time, first *int, after *string) (*models.AuditLogPagination, error) {
+	return models.GetAuditLogs(ctx, entityType, entityID, actorID, from, to, first, after)
+}
+
+// APIKeys is the resolver for the apiKeys field.
+func (r *queryResolver) APIKeys(ctx context.Context, includeRevoked *bool) ([]*models.ApiKey, error) {
+	return models.GetApiKeys(ctx, includeRevoked)
+}
+
+// MySupplierProfile is the resolver for the mySupplierProfile field.
+func (r *queryResolver) MySupplierProfile(ctx context.Context) (*models.Supplier, error) {
+	return models.GetSupplier(ctx, middlewares.SupplierCtxValue(ctx).ID)
+}
+
+// MySupplierProducts is the resolver for the mySupplierProducts field.
+func (r *queryResolver) MySupplierProducts(ctx context.Context) ([]*models.Product, error) {
+	return models.GetSupplierProducts(ctx, middlewares.SupplierCtxValue(ctx).ID)
+}
+
+// MySupplierCatalog is the resolver for the mySupplierCatalog field.
+func (r *queryResolver) MySupplierCatalog(ctx context.Context) ([]*models.SupplierProduct, error) {
+	return models.GetSupplierCatalog(ctx, middlewares.SupplierCtxValue(ctx).ID)
+}
+
 // Product is the resolver for the product field.
 func (r *queryResolver) Product(ctx context.Context, id int) (*models.Product, error) {
 	return models.GetProduct(ctx, id)
@@ -244,37 +759,399 @@ func (r *queryResolver) Products(ctx context.Context, name *string) ([]*models.P
 	return models.GetProducts(ctx, name)
 }
 
+// StockLevels is the resolver for the stockLevels field.
+func (r *queryResolver) StockLevels(ctx context.Context, branchID *int, productID *int, productVariationID *int) ([]*models.StockLevel, error) {
+	return models.GetStockLevels(ctx, middlewares.BranchClaimValue(ctx), branchID, productID, productVariationID)
+}
+
+// StockMovements is the resolver for the stockMovements field.
+func (r *queryResolver) StockMovements(ctx context.Context, filter *models.StockMovementFilter, first *int, after *string) (*models.StockMovementPagination, error) {
+	return models.GetStockMovements(ctx, middlewares.BranchClaimValue(ctx), filter, first, after)
+}
+
+// Transfer is the resolver for the transfer field.
+func (r *queryResolver) Transfer(ctx context.Context, id int) (*models.Transfer, error) {
+	return models.GetTransfer(ctx, middlewares.BranchClaimValue(ctx), id)
+}
+
+// Transfers is the resolver for the transfers field.
+func (r *queryResolver) Transfers(ctx context.Context, status *string, branchID *int) ([]*models.Transfer, error) {
+	return models.GetTransfers(ctx, middlewares.BranchClaimValue(ctx), status, branchID)
+}
+
+// AdjustmentReasons is the resolver for the adjustmentReasons field.
+func (r *queryResolver) AdjustmentReasons(ctx context.Context, includeInactive *bool) ([]*models.AdjustmentReason, error) {
+	return models.GetAdjustmentReasons(ctx, includeInactive != nil && *includeInactive)
+}
+
+// StockAdjustment is the resolver for the stockAdjustment field.
+func (r *queryResolver) StockAdjustment(ctx context.Context, id int) (*models.StockAdjustment, error) {
+	return models.GetStockAdjustment(ctx, middlewares.BranchClaimValue(ctx), id)
+}
+
+// StockAdjustments is the resolver for the stockAdjustments field.
+func (r *queryResolver) StockAdjustments(ctx context.Context, status *string, branchID *int) ([]*models.StockAdjustment, error) {
+	return models.GetStockAdjustments(ctx, middlewares.BranchClaimValue(ctx), status, branchID)
+}
+
+// StockCount is the resolver for the stockCount field.
+func (r *queryResolver) StockCount(ctx context.Context, id int) (*models.StockCount, error) {
+	return models.GetStockCount(ctx, middlewares.BranchClaimValue(ctx), id)
+}
+
+// StockCounts is the resolver for the stockCounts field.
+func (r *queryResolver) StockCounts(ctx context.Context, status *string, branchID *int) ([]*models.StockCount, error) {
+	return models.GetStockCounts(ctx, middlewares.BranchClaimValue(ctx), status, branchID)
+}
+
+// StockCountVariance is the resolver for the stockCountVariance field.
+func (r *queryResolver) StockCountVariance(ctx context.Context, id int) (*models.StockCountVariance, error) {
+	return models.GetStockCountVariance(ctx, middlewares.BranchClaimValue(ctx), id)
+}
+
+// ReorderRules is the resolver for the reorderRules field.
+func (r *queryResolver) ReorderRules(ctx context.Context, branchID *int, productID *int) ([]*models.ReorderRule, error) {
+	return models.GetReorderRules(ctx, middlewares.BranchClaimValue(ctx), branchID, productID)
+}
+
+// StockLots is the resolver for the stockLots field.
+func (r *queryResolver) StockLots(ctx context.Context, branchID *int, productID *int, includeEmpty *bool) ([]*models.StockLot, error) {
+	return models.GetStockLots(ctx, middlewares.BranchClaimValue(ctx), branchID, productID, includeEmpty != nil && *includeEmpty)
+}
+
+// ExpiringStock is the resolver for the expiringStock field.
+func (r *queryResolver) ExpiringStock(ctx context.Context, days int, branchID *int) ([]*models.StockLot, error) {
+	return models.GetExpiringStock(ctx, middlewares.BranchClaimValue(ctx), days, branchID)
+}
+
+// UnitsOfMeasure is the resolver for the unitsOfMeasure field.
+func (r *queryResolver) UnitsOfMeasure(ctx context.Context) ([]*models.UnitOfMeasure, error) {
+	return models.GetUnitsOfMeasure(ctx)
+}
+
+// Reservation is the resolver for the reservation field.
+func (r *queryResolver) Reservation(ctx context.Context, id int) (*models.Reservation, error) {
+	return models.GetReservation(ctx, middlewares.BranchClaimValue(ctx), id)
+}
+
+// Reservations is the resolver for the reservations field.
+func (r *queryResolver) Reservations(ctx context.Context, branchID *int, status *string, orderReference *string) ([]*models.Reservation, error) {
+	return models.GetReservations(ctx, middlewares.BranchClaimValue(ctx), branchID, status, orderReference)
+}
+
+// PurchaseOrder is the resolver for the purchaseOrder field.
+func (r *queryResolver) PurchaseOrder(ctx context.Context, id int) (*models.PurchaseOrder, error) {
+	return models.GetPurchaseOrder(ctx, middlewares.BranchClaimValue(ctx), id)
+}
+
+// PurchaseOrders is the resolver for the purchaseOrders field.
+func (r *queryResolver) PurchaseOrders(ctx context.Context, status *string, supplierID *int, branchID *int) ([]*models.PurchaseOrder, error) {
+	return models.GetPurchaseOrders(ctx, middlewares.BranchClaimValue(ctx), status, supplierID, branchID)
+}
+
+// GoodsReceipt is the resolver for the goodsReceipt field.
+func (r *queryResolver) GoodsReceipt(ctx context.Context, id int) (*models.GoodsReceipt, error) {
+	return models.GetGoodsReceipt(ctx, middlewares.BranchClaimValue(ctx), id)
+}
+
+// GoodsReceipts is the resolver for the goodsReceipts field.
+func (r *queryResolver) GoodsReceipts(ctx context.Context, purchaseOrderID *int, supplierID *int, branchID *int) ([]*models.GoodsReceipt, error) {
+	return models.GetGoodsReceipts(ctx, middlewares.BranchClaimValue(ctx), purchaseOrderID, supplierID, branchID)
+}
+
+// InventoryValuation is the resolver for the inventoryValuation field.
+func (r *queryResolver) InventoryValuation(ctx context.Context, branchID *int, asOf *time.Time) (*models.InventoryValuation, error) {
+	return models.GetInventoryValuation(ctx, middlewares.BranchClaimValue(ctx), branchID, asOf)
+}
+
+// LowStockItems is the resolver for the lowStockItems field.
+func (r *queryResolver) LowStockItems(ctx context.Context, branchID *int) ([]*models.LowStockItem, error) {
+	return models.GetLowStockItems(ctx, middlewares.BranchClaimValue(ctx), branchID)
+}
+
+// Notifications is the resolver for the notifications field.
+func (r *queryResolver) Notifications(ctx context.Context, branchID *int, unreadOnly *bool) ([]*models.Notification, error) {
+	return models.GetNotifications(ctx, middlewares.BranchClaimValue(ctx), branchID, unreadOnly != nil && *unreadOnly)
+}
+
 // ProductPagination is the resolver for the productPagination field.
 func (r *queryResolver) ProductPagination(ctx context.Context, first *int, after *string) (*models.ProductPagination, error) {
 	return models.GetPaginatedProducts(ctx, first, after)
 }
 
+// Branch is the resolver for the branch field.
+func (r *reorderRuleResolver) Branch(ctx context.Context, obj *models.ReorderRule) (*models.Branch, error) {
+	return middlewares.GetBranch(ctx, obj.BranchId)
+}
+
+// ProductVariation is the resolver for the productVariation field.
+func (r *reorderRuleResolver) ProductVariation(ctx context.Context, obj *models.ReorderRule) (*models.ProductVariation, error) {
+	if obj.ProductVariationId == 0 {
+		return nil, nil
+	}
+	return middlewares.GetProductVariation(ctx, obj.ProductVariationId)
+}
+
+// Branch is the resolver for the branch field.
+func (r *reservationResolver) Branch(ctx context.Context, obj *models.Reservation) (*models.Branch, error) {
+	return middlewares.GetBranch(ctx, obj.BranchId)
+}
+
+// ProductVariation is the resolver for the productVariation field.
+func (r *reservationResolver) ProductVariation(ctx context.Context, obj *models.Reservation) (*models.ProductVariation, error) {
+	if obj.ProductVariationId == 0 {
+		return nil, nil
+	}
+	return middlewares.GetProductVariation(ctx, obj.ProductVariationId)
+}
+
+// Permissions is the resolver for the permissions field.
+func (r *roleResolver) Permissions(ctx context.Context, obj *models.Role) ([]*models.RoleModule, error) {
+	return middlewares.GetRoleModules(ctx, obj.ID)
+}
+
+// Branch is the resolver for the branch field.
+func (r *stockAdjustmentResolver) Branch(ctx context.Context, obj *models.StockAdjustment) (*models.Branch, error) {
+	return middlewares.GetBranch(ctx, obj.BranchId)
+}
+
+// Reason is the resolver for the reason field.
+func (r *stockAdjustmentResolver) Reason(ctx context.Context, obj *models.StockAdjustment) (*models.AdjustmentReason, error) {
+	return models.GetAdjustmentReason(ctx, obj.ReasonId)
+}
+
+// Branch is the resolver for the branch field.
+func (r *stockCountResolver) Branch(ctx context.Context, obj *models.StockCount) (*models.Branch, error) {
+	return middlewares.GetBranch(ctx, obj.BranchId)
+}
+
+// Category is the resolver for the category field.
+func (r *stockCountResolver) Category(ctx context.Context, obj *models.StockCount) (*models.Category, error) {
+	if obj.CategoryId == 0 {
+		return nil, nil
+	}
+	return middlewares.GetCategory(ctx, obj.CategoryId)
+}
+
+// Lines is the resolver for the lines field.
+func (r *stockCountResolver) Lines(ctx context.Context, obj *models.StockCount, uncountedOnly *bool) ([]*models.StockCountLine, error) {
+	return models.GetStockCountLines(ctx, obj.ID, uncountedOnly != nil && *uncountedOnly)
+}
+
+// Product is the resolver for the product field.
+func (r *stockCountLineResolver) Product(ctx context.Context, obj *models.StockCountLine) (*models.Product, error) {
+	return middlewares.GetProduct(ctx, obj.ProductId)
+}
+
+// ProductVariation is the resolver for the productVariation field.
+func (r *stockCountLineResolver) ProductVariation(ctx context.Context, obj *models.StockCountLine) (*models.ProductVariation, error) {
+	if obj.ProductVariationId == 0 {
+		return nil, nil
+	}
+	return middlewares.GetProductVariation(ctx, obj.ProductVariationId)
+}
+
+// Category is the resolver for the category field.
+func (r *stockCountVarianceGroupResolver) Category(ctx context.Context, obj *models.StockCountVarianceGroup) (*models.Category, error) {
+	if obj.CategoryId == 0 {
+		return nil, nil
+	}
+	return middlewares.GetCategory(ctx, obj.CategoryId)
+}
+
+// Branch is the resolver for the branch field.
+func (r *stockLevelResolver) Branch(ctx context.Context, obj *models.StockLevel) (*models.Branch, error) {
+	return middlewares.GetBranch(ctx, obj.BranchId)
+}
+
+// ProductVariation is the resolver for the productVariation field.
+func (r *stockLevelResolver) ProductVariation(ctx context.Context, obj *models.StockLevel) (*models.ProductVariation, error) {
+	if obj.ProductVariationId == 0 {
+		return nil, nil
+	}
+	return middlewares.GetProductVariation(ctx, obj.ProductVariationId)
+}
+
+// Branch is the resolver for the branch field.
+func (r *stockLotResolver) Branch(ctx context.Context, obj *models.StockLot) (*models.Branch, error) {
+	return middlewares.GetBranch(ctx, obj.BranchId)
+}
+
+// ProductVariation is the resolver for the productVariation field.
+func (r *stockLotResolver) ProductVariation(ctx context.Context, obj *models.StockLot) (*models.ProductVariation, error) {
+	if obj.ProductVariationId == 0 {
+		return nil, nil
+	}
+	return middlewares.GetProductVariation(ctx, obj.ProductVariationId)
+}
+
+// Products is the resolver for the products field.
+func (r *supplierResolver) Products(ctx context.Context, obj *models.Supplier) ([]*models.SupplierProduct, error) {
+	return middlewares.GetSupplierProducts(ctx, obj.ID)
+}
+
+// Supplier is the resolver for the supplier field.
+func (r *supplierProductResolver) Supplier(ctx context.Context, obj *models.SupplierProduct) (*models.Supplier, error) {
+	return middlewares.GetSupplier(ctx, obj.SupplierId)
+}
+
+// Product is the resolver for the product field.
+func (r *supplierProductResolver) Product(ctx context.Context, obj *models.SupplierProduct) (*models.Product, error) {
+	return middlewares.GetProduct(ctx, obj.ProductId)
+}
+
+// ProductVariation is the resolver for the productVariation field.
+func (r *supplierProductResolver) ProductVariation(ctx context.Context, obj *models.SupplierProduct) (*models.ProductVariation, error) {
+	if obj.ProductVariationId == 0 {
+		return nil, nil
+	}
+	return middlewares.GetProductVariation(ctx, obj.ProductVariationId)
+}
+
+// Uom is the resolver for the uom field.
+func (r *supplierProductResolver) Uom(ctx context.Context, obj *models.SupplierProduct) (*models.UnitOfMeasure, error) {
+	if obj.UomId == 0 {
+		return nil, nil
+	}
+	return middlewares.GetUnitOfMeasure(ctx, obj.UomId)
+}
+
+// SourceBranch is the resolver for the sourceBranch field.
+func (r *transferResolver) SourceBranch(ctx context.Context, obj *models.Transfer) (*models.Branch, error) {
+	return middlewares.GetBranch(ctx, obj.SourceBranchId)
+}
+
+// DestinationBranch is the resolver for the destinationBranch field.
+func (r *transferResolver) DestinationBranch(ctx context.Context, obj *models.Transfer) (*models.Branch, error) {
+	return middlewares.GetBranch(ctx, obj.DestinationBranchId)
+}
+
 // Role is the resolver for the role field.
 func (r *userResolver) Role(ctx context.Context, obj *models.User) (*models.Role, error) {
 	return middlewares.GetRole(ctx, obj.RoleId)
 }
 
+// Permissions is the resolver for the permissions field.
+func (r *userResolver) Permissions(ctx context.Context, obj *models.User) ([]*models.RoleModule, error) {
+	return middlewares.GetRoleModules(ctx, obj.RoleId)
+}
+
+// Branches is the resolver for the branches field.
+func (r *userResolver) Branches(ctx context.Context, obj *models.User) ([]*models.Branch, error) {
+	return middlewares.GetUserBranches(ctx, obj.ID)
+}
+
+// ApiKey returns ApiKeyResolver implementation.
+func (r *Resolver) ApiKey() ApiKeyResolver { return &apiKeyResolver{r} }
+
 // Category returns CategoryResolver implementation.
 func (r *Resolver) Category() CategoryResolver { return &categoryResolver{r} }
 
+// GoodsReceipt returns GoodsReceiptResolver implementation.
+func (r *Resolver) GoodsReceipt() GoodsReceiptResolver { return &goodsReceiptResolver{r} }
+
+// GoodsReceiptLine returns GoodsReceiptLineResolver implementation.
+func (r *Resolver) GoodsReceiptLine() GoodsReceiptLineResolver { return &goodsReceiptLineResolver{r} }
+
 // Image returns ImageResolver implementation.
 func (r *Resolver) Image() ImageResolver { return &imageResolver{r} }
 
+// InventoryValuationGroup returns InventoryValuationGroupResolver implementation.
+func (r *Resolver) InventoryValuationGroup() InventoryValuationGroupResolver {
+	return &inventoryValuationGroupResolver{r}
+}
+
+// Invite returns InviteResolver implementation.
+func (r *Resolver) Invite() InviteResolver { return &inviteResolver{r} }
+
+// LowStockItem returns LowStockItemResolver implementation.
+func (r *Resolver) LowStockItem() LowStockItemResolver { return &lowStockItemResolver{r} }
+
 // Mutation returns MutationResolver implementation.
 func (r *Resolver) Mutation() MutationResolver { return &mutationResolver{r} }
 
 // Product returns ProductResolver implementation.
 func (r *Resolver) Product() ProductResolver { return &productResolver{r} }
 
+// ProductUnit returns ProductUnitResolver implementation.
+func (r *Resolver) ProductUnit() ProductUnitResolver { return &productUnitResolver{r} }
+
+// PurchaseOrder returns PurchaseOrderResolver implementation.
+func (r *Resolver) PurchaseOrder() PurchaseOrderResolver { return &purchaseOrderResolver{r} }
+
+// PurchaseOrderLine returns PurchaseOrderLineResolver implementation.
+func (r *Resolver) PurchaseOrderLine() PurchaseOrderLineResolver {
+	return &purchaseOrderLineResolver{r}
+}
+
 // Query returns QueryResolver implementation.
 func (r *Resolver) Query() QueryResolver { return &queryResolver{r} }
 
+// ReorderRule returns ReorderRuleResolver implementation.
+func (r *Resolver) ReorderRule() ReorderRuleResolver { return &reorderRuleResolver{r} }
+
+// Reservation returns ReservationResolver implementation.
+func (r *Resolver) Reservation() ReservationResolver { return &reservationResolver{r} }
+
+// Role returns RoleResolver implementation.
+func (r *Resolver) Role() RoleResolver { return &roleResolver{r} }
+
+// StockAdjustment returns StockAdjustmentResolver implementation.
+func (r *Resolver) StockAdjustment() StockAdjustmentResolver { return &stockAdjustmentResolver{r} }
+
+// StockCount returns StockCountResolver implementation.
+func (r *Resolver) StockCount() StockCountResolver { return &stockCountResolver{r} }
+
+// StockCountLine returns StockCountLineResolver implementation.
+func (r *Resolver) StockCountLine() StockCountLineResolver { return &stockCountLineResolver{r} }
+
+// StockCountVarianceGroup returns StockCountVarianceGroupResolver implementation.
+func (r *Resolver) StockCountVarianceGroup() StockCountVarianceGroupResolver {
+	return &stockCountVarianceGroupResolver{r}
+}
+
+// StockLevel returns StockLevelResolver implementation.
+func (r *Resolver) StockLevel() StockLevelResolver { return &stockLevelResolver{r} }
+
+// StockLot returns StockLotResolver implementation.
+func (r *Resolver) StockLot() StockLotResolver { return &stockLotResolver{r} }
+
+// Supplier returns SupplierResolver implementation.
+func (r *Resolver) Supplier() SupplierResolver { return &supplierResolver{r} }
+
+// SupplierProduct returns SupplierProductResolver implementation.
+func (r *Resolver) SupplierProduct() SupplierProductResolver { return &supplierProductResolver{r} }
+
+// Transfer returns TransferResolver implementation.
+func (r *Resolver) Transfer() TransferResolver { return &transferResolver{r} }
+
 // User returns UserResolver implementation.
 func (r *Resolver) User() UserResolver { return &userResolver{r} }
 
+type apiKeyResolver struct{ *Resolver }
 type categoryResolver struct{ *Resolver }
+type goodsReceiptResolver struct{ *Resolver }
+type goodsReceiptLineResolver struct{ *Resolver }
 type imageResolver struct{ *Resolver }
+type inventoryValuationGroupResolver struct{ *Resolver }
+type inviteResolver struct{ *Resolver }
+type lowStockItemResolver struct{ *Resolver }
 type mutationResolver struct{ *Resolver }
 type productResolver struct{ *Resolver }
+type productUnitResolver struct{ *Resolver }
+type purchaseOrderResolver struct{ *Resolver }
+type purchaseOrderLineResolver struct{ *Resolver }
 type queryResolver struct{ *Resolver }
+type reorderRuleResolver struct{ *Resolver }
+type reservationResolver struct{ *Resolver }
+type roleResolver struct{ *Resolver }
+type stockAdjustmentResolver struct{ *Resolver }
+type stockCountResolver struct{ *Resolver }
+type stockCountLineResolver struct{ *Resolver }
+type stockCountVarianceGroupResolver struct{ *Resolver }
+type stockLevelResolver struct{ *Resolver }
+type stockLotResolver struct{ *Resolver }
+type supplierResolver struct{ *Resolver }
+type supplierProductResolver struct{ *Resolver }
+type transferResolver struct{ *Resolver }
 type userResolver struct{ *Resolver }
@@ -0,0 +1,55 @@
+package middlewares
+
+import (
+	"context"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/models"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/utils"
+)
+
+// AuditExtension tags every root mutation field with the caller, so the database writes it makes are audited.
+type AuditExtension struct{}
+
+var _ interface {
+	graphql.HandlerExtension
+	graphql.FieldInterceptor
+} = AuditExtension{}
+
+func (AuditExtension) ExtensionName() string {
+	return "AuditLog"
+}
+
+func (AuditExtension) Validate(schema graphql.ExecutableSchema) error {
+	return nil
+}
+
+func (AuditExtension) InterceptField(ctx context.Context, next graphql.Resolver) (interface{}, error) {
+	fc := graphql.GetFieldContext(ctx)
+	if fc == nil || fc.Object != "Mutation" {
+		return next(ctx)
+	}
+
+	info := &models.AuditInfo{
+		Operation: fc.Field.Name,
+	}
+	if claim := CtxValue(ctx); claim != nil {
+		if claim.SubjectType == utils.SubjectApiKey {
+			info.ActorId = claim.ApiKeyId
+			info.ActorType = utils.SubjectApiKey
+		} else {
+			info.ActorId = claim.ID
+			info.ActorType = utils.SubjectUser
+		}
+	} else if claim := SupplierCtxValue(ctx); claim != nil {
+		info.ActorId = claim.ID
+		info.ActorType = utils.SubjectSupplier
+	} else {
+		info.ActorType = "anonymous"
+	}
+	if client := ClientInfoValue(ctx); client != nil {
+		info.ClientIp = client.ClientIp
+	}
+
+	return next(models.WithAuditInfo(ctx, info))
+}
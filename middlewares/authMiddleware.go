@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/models"
 	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/utils"
 )
 
@@ -12,6 +13,11 @@ type authString string
 
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if apiKey := c.Request.Header.Get("X-Api-Key"); apiKey != "" {
+			authenticateApiKey(c, apiKey)
+			return
+		}
+
 		auth := c.Request.Header.Get("Authorization")
 
 		if auth == "" {
@@ -31,13 +37,73 @@ func AuthMiddleware() gin.HandlerFunc {
 
 		customClaim, _ := validate.Claims.(*utils.JwtCustomClaim)
 
-		ctx := context.WithValue(c.Request.Context(), authString("auth"), customClaim)
+		// Staff and supplier tokens live under different context keys so neither can pass the other's checks
+		var key authString
+		var active bool
+		switch customClaim.SubjectType {
+		case utils.SubjectUser:
+			// Reject tokens whose session was revoked or whose user has been disabled since
+			key = authString("auth")
+			active, err = models.IsSessionActive(c.Request.Context(), customClaim.SessionId, customClaim.ID)
+		case utils.SubjectSupplier:
+			key = authString("supplier")
+			active, err = models.IsSupplierActive(c.Request.Context(), customClaim.ID)
+		}
+		if err != nil || !active {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			c.Abort()
+			return
+		}
+
+		ctx := context.WithValue(c.Request.Context(), key, customClaim)
 		c.Request = c.Request.WithContext(ctx)
 		c.Next()
 	}
 }
 
+// authenticateApiKey puts a claim equivalent to a staff token in the context for a valid API key.
+func authenticateApiKey(c *gin.Context, apiKey string) {
+	key, err := models.AuthenticateApiKey(c.Request.Context(), apiKey)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		c.Abort()
+		return
+	}
+
+	branch, err := key.BranchClaim(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		c.Abort()
+		return
+	}
+
+	customClaim := &utils.JwtCustomClaim{
+		SubjectType: utils.SubjectApiKey,
+		ApiKeyId:    key.ID,
+		RoleId:      key.RoleId,
+		BranchClaim: branch,
+	}
+
+	ctx := context.WithValue(c.Request.Context(), authString("auth"), customClaim)
+	c.Request = c.Request.WithContext(ctx)
+	c.Next()
+}
+
 func CtxValue(ctx context.Context) *utils.JwtCustomClaim {
 	raw, _ := ctx.Value(authString("auth")).(*utils.JwtCustomClaim)
 	return raw
 }
+
+func SupplierCtxValue(ctx context.Context) *utils.JwtCustomClaim {
+	raw, _ := ctx.Value(authString("supplier")).(*utils.JwtCustomClaim)
+	return raw
+}
+
+// BranchClaimValue returns the branches visible to the staff caller, or nil when there is none.
+func BranchClaimValue(ctx context.Context) *utils.BranchClaim {
+	claim := CtxValue(ctx)
+	if claim == nil {
+		return nil
+	}
+	return &claim.BranchClaim
+}
@@ -0,0 +1,48 @@
+package middlewares
+
+import (
+	"context"
+
+	"github.com/graph-gophers/dataloader/v7"
+	"gorm.io/gorm"
+
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/models"
+)
+
+type branchReader struct {
+	db *gorm.DB
+}
+
+func (r *branchReader) getBranches(ctx context.Context, ids []int) []*dataloader.Result[*models.Branch] {
+	var results []*models.Branch
+
+	err := r.db.WithContext(ctx).Where("id IN ?", ids).Find(&results).Error
+	if err != nil {
+		return handleError[*models.Branch](len(ids), err)
+	}
+
+	loaderResults := make([]*dataloader.Result[*models.Branch], 0, len(ids))
+	for _, id := range ids {
+		if id == 0 {
+			loaderResults = append(loaderResults, &dataloader.Result[*models.Branch]{Data: &models.Branch{}})
+		} else {
+			for _, result := range results {
+				if result.ID == id {
+					loaderResults = append(loaderResults, &dataloader.Result[*models.Branch]{Data: result})
+					break
+				}
+			}
+		}
+	}
+	return loaderResults
+}
+
+func GetBranch(ctx context.Context, id int) (*models.Branch, error) {
+	loaders := For(ctx)
+	return loaders.BranchLoader.Load(ctx, id)()
+}
+
+func GetBranches(ctx context.Context, ids []int) ([]*models.Branch, []error) {
+	loaders := For(ctx)
+	return loaders.BranchLoader.LoadMany(ctx, ids)()
+}
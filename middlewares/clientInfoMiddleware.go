@@ -0,0 +1,30 @@
+package middlewares
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/models"
+)
+
+const (
+	clientInfoKey = ctxKey("clientInfo")
+)
+
+// ClientInfoMiddleware keeps the caller's IP address and user agent in the request context.
+func ClientInfoMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		info := &models.ClientInfo{
+			ClientIp:  c.ClientIP(),
+			UserAgent: c.Request.UserAgent(),
+		}
+		ctx := context.WithValue(c.Request.Context(), clientInfoKey, info)
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+func ClientInfoValue(ctx context.Context) *models.ClientInfo {
+	raw, _ := ctx.Value(clientInfoKey).(*models.ClientInfo)
+	return raw
+}
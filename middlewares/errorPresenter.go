@@ -0,0 +1,31 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+// extendedError is an error that carries details for API clients, such as an error code.
+type extendedError interface {
+	Extensions() map[string]interface{}
+}
+
+// ErrorPresenter adds the extensions of errors that carry them to the GraphQL error, so clients
+// can act on a code instead of parsing the message.
+func ErrorPresenter(ctx context.Context, err error) *gqlerror.Error {
+	gqlErr := graphql.DefaultErrorPresenter(ctx, err)
+
+	var extended extendedError
+	if errors.As(err, &extended) {
+		if gqlErr.Extensions == nil {
+			gqlErr.Extensions = map[string]interface{}{}
+		}
+		for key, value := range extended.Extensions() {
+			gqlErr.Extensions[key] = value
+		}
+	}
+	return gqlErr
+}
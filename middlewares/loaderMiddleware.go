@@ -20,11 +20,19 @@ const (
 // Loaders wrap your data loaders to inject via middleware
 type Loaders struct {
 	RoleLoader *dataloader.Loader[int, *models.Role]
+	RoleModuleLoader *dataloader.Loader[int, []*models.RoleModule]
+	UserBranchLoader *dataloader.Loader[int, []*models.Branch]
+	BranchLoader *dataloader.Loader[int, *models.Branch]
 	CatgoryLoader *dataloader.Loader[int, *models.Category]
 	SupplierLoader *dataloader.Loader[int, *models.Supplier]
 	ProductLoader *dataloader.Loader[int, *models.Product]
 	ProductVariationLoader *dataloader.Loader[int, *models.ProductVariation]
 	ProductOptionLoader *dataloader.Loader[int, *models.ProductOption]
+	StockLevelLoader *dataloader.Loader[int, []*models.StockLevel]
+	UnitOfMeasureLoader *dataloader.Loader[int, *models.UnitOfMeasure]
+	ProductUnitLoader *dataloader.Loader[int, []*models.ProductUnit]
+	ProductSupplierLoader *dataloader.Loader[int, []*models.SupplierProduct]
+	SupplierProductLoader *dataloader.Loader[int, []*models.SupplierProduct]
 }
 
 // NewLoaders instantiates data loaders for the middleware
@@ -33,18 +41,32 @@ func NewLoaders(conn *gorm.DB) *Loaders {
 	
 	ar := &categoryReader{db: conn}
 	role := &roleReader{db: conn}
+	roleModule := &roleModuleReader{db: conn}
+	userBranch := &userBranchReader{db: conn}
+	branch := &branchReader{db: conn}
 	supplier := &supplierReader{db: conn}
 	product := &productReader{db: conn}
 	productV := &productVariationReader{db: conn}
 	productOpt := &productOptionReader{db: conn}
+	stockLevel := &stockLevelReader{db: conn}
+	uom := &unitOfMeasureReader{db: conn}
+	supplierProduct := &supplierProductReader{db: conn}
 
 	return &Loaders{
 		RoleLoader: dataloader.NewBatchedLoader(role.getRoles, dataloader.WithWait[int, *models.Role](time.Millisecond)),
+		RoleModuleLoader: dataloader.NewBatchedLoader(roleModule.getRoleModules, dataloader.WithWait[int, []*models.RoleModule](time.Millisecond)),
+		UserBranchLoader: dataloader.NewBatchedLoader(userBranch.getUserBranches, dataloader.WithWait[int, []*models.Branch](time.Millisecond)),
+		BranchLoader: dataloader.NewBatchedLoader(branch.getBranches, dataloader.WithWait[int, *models.Branch](time.Millisecond)),
 		CatgoryLoader: dataloader.NewBatchedLoader(ar.getCategories, dataloader.WithWait[int, *models.Category](time.Millisecond)),
 		SupplierLoader: dataloader.NewBatchedLoader(supplier.getSuppliers, dataloader.WithWait[int, *models.Supplier](time.Millisecond)),
 		ProductLoader: dataloader.NewBatchedLoader(product.getProducts, dataloader.WithWait[int, *models.Product](time.Millisecond)),
 		ProductVariationLoader: dataloader.NewBatchedLoader(productV.GetProductVariations, dataloader.WithWait[int, *models.ProductVariation](time.Millisecond)),
 		ProductOptionLoader: dataloader.NewBatchedLoader(productOpt.GetProductOptions, dataloader.WithWait[int, *models.ProductOption](time.Millisecond)),
+		StockLevelLoader: dataloader.NewBatchedLoader(stockLevel.getStockLevels, dataloader.WithWait[int, []*models.StockLevel](time.Millisecond)),
+		UnitOfMeasureLoader: dataloader.NewBatchedLoader(uom.getUnitsOfMeasure, dataloader.WithWait[int, *models.UnitOfMeasure](time.Millisecond)),
+		ProductUnitLoader: dataloader.NewBatchedLoader(uom.getProductUnits, dataloader.WithWait[int, []*models.ProductUnit](time.Millisecond)),
+		ProductSupplierLoader: dataloader.NewBatchedLoader(supplierProduct.getProductSuppliers, dataloader.WithWait[int, []*models.SupplierProduct](time.Millisecond)),
+		SupplierProductLoader: dataloader.NewBatchedLoader(supplierProduct.getSupplierProducts, dataloader.WithWait[int, []*models.SupplierProduct](time.Millisecond)),
 	}
 }
 
@@ -0,0 +1,44 @@
+package middlewares
+
+import (
+	"context"
+
+	"github.com/graph-gophers/dataloader/v7"
+	"gorm.io/gorm"
+
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/models"
+)
+
+type roleModuleReader struct {
+	db *gorm.DB
+}
+
+// getRoleModules loads the permissions of several roles at once, keyed by role id.
+func (r *roleModuleReader) getRoleModules(ctx context.Context, roleIds []int) []*dataloader.Result[[]*models.RoleModule] {
+	var results []*models.RoleModule
+
+	err := r.db.WithContext(ctx).Where("role_id IN ?", roleIds).Order("module, action").Find(&results).Error
+	if err != nil {
+		return handleError[[]*models.RoleModule](len(roleIds), err)
+	}
+
+	grouped := make(map[int][]*models.RoleModule, len(roleIds))
+	for _, result := range results {
+		grouped[result.RoleId] = append(grouped[result.RoleId], result)
+	}
+
+	loaderResults := make([]*dataloader.Result[[]*models.RoleModule], 0, len(roleIds))
+	for _, id := range roleIds {
+		modules := grouped[id]
+		if modules == nil {
+			modules = []*models.RoleModule{}
+		}
+		loaderResults = append(loaderResults, &dataloader.Result[[]*models.RoleModule]{Data: modules})
+	}
+	return loaderResults
+}
+
+func GetRoleModules(ctx context.Context, roleId int) ([]*models.RoleModule, error) {
+	loaders := For(ctx)
+	return loaders.RoleModuleLoader.Load(ctx, roleId)()
+}
@@ -0,0 +1,48 @@
+package middlewares
+
+import (
+	"context"
+
+	"github.com/graph-gophers/dataloader/v7"
+	"gorm.io/gorm"
+
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/models"
+)
+
+type stockLevelReader struct {
+	db *gorm.DB
+}
+
+// getStockLevels loads the stock levels of several products at once, limited to the caller's branches.
+func (r *stockLevelReader) getStockLevels(ctx context.Context, productIds []int) []*dataloader.Result[[]*models.StockLevel] {
+	var levels []*models.StockLevel
+
+	err := r.db.WithContext(ctx).
+		Scopes(models.ScopeBranches(BranchClaimValue(ctx), "branch_id")).
+		Where("product_id IN ?", productIds).
+		Order("branch_id, product_variation_id").
+		Find(&levels).Error
+	if err != nil {
+		return handleError[[]*models.StockLevel](len(productIds), err)
+	}
+
+	grouped := make(map[int][]*models.StockLevel, len(productIds))
+	for _, level := range levels {
+		grouped[level.ProductId] = append(grouped[level.ProductId], level)
+	}
+
+	loaderResults := make([]*dataloader.Result[[]*models.StockLevel], 0, len(productIds))
+	for _, id := range productIds {
+		results := grouped[id]
+		if results == nil {
+			results = []*models.StockLevel{}
+		}
+		loaderResults = append(loaderResults, &dataloader.Result[[]*models.StockLevel]{Data: results})
+	}
+	return loaderResults
+}
+
+func GetProductStockLevels(ctx context.Context, productId int) ([]*models.StockLevel, error) {
+	loaders := For(ctx)
+	return loaders.StockLevelLoader.Load(ctx, productId)()
+}
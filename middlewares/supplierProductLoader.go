@@ -0,0 +1,61 @@
+package middlewares
+
+import (
+	"context"
+
+	"github.com/graph-gophers/dataloader/v7"
+	"gorm.io/gorm"
+
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/models"
+)
+
+type supplierProductReader struct {
+	db *gorm.DB
+}
+
+// getProductSuppliers loads the supplier catalog entries of several products at once.
+func (r *supplierProductReader) getProductSuppliers(ctx context.Context, productIds []int) []*dataloader.Result[[]*models.SupplierProduct] {
+	return r.load(ctx, "product_id", productIds, func(link *models.SupplierProduct) int { return link.ProductId })
+}
+
+// getSupplierProducts loads the catalogs of several suppliers at once.
+func (r *supplierProductReader) getSupplierProducts(ctx context.Context, supplierIds []int) []*dataloader.Result[[]*models.SupplierProduct] {
+	return r.load(ctx, "supplier_id", supplierIds, func(link *models.SupplierProduct) int { return link.SupplierId })
+}
+
+func (r *supplierProductReader) load(ctx context.Context, column string, ids []int, key func(*models.SupplierProduct) int) []*dataloader.Result[[]*models.SupplierProduct] {
+	var links []*models.SupplierProduct
+
+	err := r.db.WithContext(ctx).
+		Where(column+" IN ?", ids).
+		Order("is_preferred desc, unit_cost, id").
+		Find(&links).Error
+	if err != nil {
+		return handleError[[]*models.SupplierProduct](len(ids), err)
+	}
+
+	grouped := make(map[int][]*models.SupplierProduct, len(ids))
+	for _, link := range links {
+		grouped[key(link)] = append(grouped[key(link)], link)
+	}
+
+	loaderResults := make([]*dataloader.Result[[]*models.SupplierProduct], 0, len(ids))
+	for _, id := range ids {
+		results := grouped[id]
+		if results == nil {
+			results = []*models.SupplierProduct{}
+		}
+		loaderResults = append(loaderResults, &dataloader.Result[[]*models.SupplierProduct]{Data: results})
+	}
+	return loaderResults
+}
+
+func GetProductSuppliers(ctx context.Context, productId int) ([]*models.SupplierProduct, error) {
+	loaders := For(ctx)
+	return loaders.ProductSupplierLoader.Load(ctx, productId)()
+}
+
+func GetSupplierProducts(ctx context.Context, supplierId int) ([]*models.SupplierProduct, error) {
+	loaders := For(ctx)
+	return loaders.SupplierProductLoader.Load(ctx, supplierId)()
+}
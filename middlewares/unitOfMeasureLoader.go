@@ -0,0 +1,72 @@
+package middlewares
+
+import (
+	"context"
+
+	"github.com/graph-gophers/dataloader/v7"
+	"gorm.io/gorm"
+
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/models"
+)
+
+type unitOfMeasureReader struct {
+	db *gorm.DB
+}
+
+func (r *unitOfMeasureReader) getUnitsOfMeasure(ctx context.Context, ids []int) []*dataloader.Result[*models.UnitOfMeasure] {
+	var results []*models.UnitOfMeasure
+
+	err := r.db.WithContext(ctx).Where("id IN ?", ids).Find(&results).Error
+	if err != nil {
+		return handleError[*models.UnitOfMeasure](len(ids), err)
+	}
+
+	byId := make(map[int]*models.UnitOfMeasure, len(results))
+	for _, result := range results {
+		byId[result.ID] = result
+	}
+
+	loaderResults := make([]*dataloader.Result[*models.UnitOfMeasure], 0, len(ids))
+	for _, id := range ids {
+		loaderResults = append(loaderResults, &dataloader.Result[*models.UnitOfMeasure]{Data: byId[id]})
+	}
+	return loaderResults
+}
+
+// getProductUnits loads the packaging units of several products at once.
+func (r *unitOfMeasureReader) getProductUnits(ctx context.Context, productIds []int) []*dataloader.Result[[]*models.ProductUnit] {
+	var units []*models.ProductUnit
+
+	err := r.db.WithContext(ctx).
+		Where("product_id IN ?", productIds).
+		Order("product_variation_id, factor").
+		Find(&units).Error
+	if err != nil {
+		return handleError[[]*models.ProductUnit](len(productIds), err)
+	}
+
+	grouped := make(map[int][]*models.ProductUnit, len(productIds))
+	for _, unit := range units {
+		grouped[unit.ProductId] = append(grouped[unit.ProductId], unit)
+	}
+
+	loaderResults := make([]*dataloader.Result[[]*models.ProductUnit], 0, len(productIds))
+	for _, id := range productIds {
+		results := grouped[id]
+		if results == nil {
+			results = []*models.ProductUnit{}
+		}
+		loaderResults = append(loaderResults, &dataloader.Result[[]*models.ProductUnit]{Data: results})
+	}
+	return loaderResults
+}
+
+func GetUnitOfMeasure(ctx context.Context, id int) (*models.UnitOfMeasure, error) {
+	loaders := For(ctx)
+	return loaders.UnitOfMeasureLoader.Load(ctx, id)()
+}
+
+func GetProductUnits(ctx context.Context, productId int) ([]*models.ProductUnit, error) {
+	loaders := For(ctx)
+	return loaders.ProductUnitLoader.Load(ctx, productId)()
+}
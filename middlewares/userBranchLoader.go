@@ -0,0 +1,59 @@
+package middlewares
+
+import (
+	"context"
+
+	"github.com/graph-gophers/dataloader/v7"
+	"gorm.io/gorm"
+
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/models"
+)
+
+type userBranchReader struct {
+	db *gorm.DB
+}
+
+// getUserBranches loads the branches assigned to several users at once, keyed by user id.
+func (r *userBranchReader) getUserBranches(ctx context.Context, userIds []int) []*dataloader.Result[[]*models.Branch] {
+	var links []*models.UserBranches
+	var branches []*models.Branch
+
+	err := r.db.WithContext(ctx).Where("user_id IN ?", userIds).Find(&links).Error
+	if err != nil {
+		return handleError[[]*models.Branch](len(userIds), err)
+	}
+
+	branchIds := make([]int, 0, len(links))
+	for _, link := range links {
+		branchIds = append(branchIds, link.BranchID)
+	}
+
+	err = r.db.WithContext(ctx).Where("id IN ?", branchIds).Order("name").Find(&branches).Error
+	if err != nil {
+		return handleError[[]*models.Branch](len(userIds), err)
+	}
+
+	grouped := make(map[int][]*models.Branch, len(userIds))
+	for _, branch := range branches {
+		for _, link := range links {
+			if link.BranchID == branch.ID {
+				grouped[link.UserID] = append(grouped[link.UserID], branch)
+			}
+		}
+	}
+
+	loaderResults := make([]*dataloader.Result[[]*models.Branch], 0, len(userIds))
+	for _, id := range userIds {
+		results := grouped[id]
+		if results == nil {
+			results = []*models.Branch{}
+		}
+		loaderResults = append(loaderResults, &dataloader.Result[[]*models.Branch]{Data: results})
+	}
+	return loaderResults
+}
+
+func GetUserBranches(ctx context.Context, userId int) ([]*models.Branch, error) {
+	loaders := For(ctx)
+	return loaders.UserBranchLoader.Load(ctx, userId)()
+}
@@ -0,0 +1,185 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/config"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/utils"
+)
+
+type ApiKey struct {
+	ID         int        `gorm:"primary_key" json:"id"`
+	Name       string     `gorm:"size:100;not null" json:"name" binding:"required"`
+	Prefix     string     `gorm:"size:20;not null" json:"prefix"`
+	KeyHash    string     `gorm:"size:64;not null;uniqueIndex" json:"-"`
+	RoleId     int        `gorm:"index;not null" json:"role_id" binding:"required"`
+	BranchId   int        `gorm:"not null;default:0" json:"branch_id"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedBy  int        `gorm:"not null;default:0" json:"created_by"`
+	CreatedAt  time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt  time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+type NewApiKey struct {
+	Name      string     `json:"name" binding:"required"`
+	RoleId    int        `json:"role_id" binding:"required"`
+	BranchId  *int       `json:"branch_id"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type ApiKeyCreated struct {
+	ApiKey *ApiKey `json:"apiKey"`
+	Key    string  `json:"key"`
+}
+
+const apiKeyPrefix = "mk_"
+
+// apiKeyTouchInterval limits how often LastUsedAt is written for a busy key.
+const apiKeyTouchInterval = time.Minute
+
+// CreateApiKey stores the hash of a new key; the plain key is only ever returned here. The key's
+// role may not grant anything the creator's own role does not.
+func CreateApiKey(ctx context.Context, input *NewApiKey, createdBy int, roleId int) (*ApiKeyCreated, error) {
+
+	db := config.GetDB()
+
+	if input.Name == "" {
+		return nil, errors.New("name is required")
+	}
+
+	if !utils.IsRecordValidByID(input.RoleId, &Role{}, db) {
+		return nil, errors.New("invalid role id")
+	}
+	ceiling, err := actorCeiling(db, ctx, createdBy, roleId)
+	if err != nil {
+		return nil, err
+	}
+	if err := ceiling.checkRole(db, ctx, input.RoleId); err != nil {
+		return nil, err
+	}
+
+	branchId := 0
+	if input.BranchId != nil && *input.BranchId > 0 {
+		if !utils.IsRecordValidByID(*input.BranchId, &Branch{}, db) {
+			return nil, errors.New("invalid branch id")
+		}
+		branchId = *input.BranchId
+	}
+
+	if input.ExpiresAt != nil && input.ExpiresAt.Before(time.Now()) {
+		return nil, errors.New("expiry must be in the future")
+	}
+
+	token, err := utils.GenerateRandomToken()
+	if err != nil {
+		return nil, err
+	}
+	key := apiKeyPrefix + token
+
+	apiKey := ApiKey{
+		Name:      input.Name,
+		Prefix:    key[:len(apiKeyPrefix)+8],
+		KeyHash:   utils.HashToken(key),
+		RoleId:    input.RoleId,
+		BranchId:  branchId,
+		ExpiresAt: input.ExpiresAt,
+		CreatedBy: createdBy,
+	}
+
+	err = db.WithContext(ctx).Create(&apiKey).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &ApiKeyCreated{
+		ApiKey: &apiKey,
+		Key:    key,
+	}, nil
+}
+
+func RevokeApiKey(ctx context.Context, id int) (*ApiKey, error) {
+
+	db := config.GetDB()
+	var result ApiKey
+
+	err := db.WithContext(ctx).First(&result, id).Error
+	if err != nil {
+		return nil, utils.ErrorRecordNotFound
+	}
+
+	if result.RevokedAt != nil {
+		return &result, nil
+	}
+
+	now := time.Now()
+	err = db.WithContext(ctx).Model(&result).Update("RevokedAt", now).Error
+	if err != nil {
+		return nil, err
+	}
+	result.RevokedAt = &now
+	return &result, nil
+}
+
+func GetApiKeys(ctx context.Context, includeRevoked *bool) ([]*ApiKey, error) {
+
+	db := config.GetDB()
+	var results []*ApiKey
+
+	dbCtx := db.WithContext(ctx)
+	if includeRevoked == nil || !*includeRevoked {
+		dbCtx = dbCtx.Where("revoked_at IS NULL")
+	}
+
+	err := dbCtx.Order("name").Find(&results).Error
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// AuthenticateApiKey returns the active key matching the presented value.
+func AuthenticateApiKey(ctx context.Context, key string) (*ApiKey, error) {
+
+	db := config.GetDB()
+	var result ApiKey
+
+	err := db.WithContext(ctx).
+		Where("key_hash = ? AND revoked_at IS NULL", utils.HashToken(key)).
+		Where("expires_at IS NULL OR expires_at > ?", time.Now()).
+		First(&result).Error
+	if err != nil {
+		return nil, errors.New("invalid api key")
+	}
+
+	now := time.Now()
+	if result.LastUsedAt == nil || now.Sub(*result.LastUsedAt) > apiKeyTouchInterval {
+		err = db.WithContext(ctx).Model(&result).UpdateColumn("LastUsedAt", now).Error
+		if err != nil {
+			return nil, err
+		}
+		result.LastUsedAt = &now
+	}
+
+	return &result, nil
+}
+
+// BranchClaim pins a key to its branch; unpinned keys see every branch only if their role allows it.
+func (key *ApiKey) BranchClaim(ctx context.Context) (utils.BranchClaim, error) {
+
+	if key.BranchId > 0 {
+		return utils.BranchClaim{
+			BranchId:  key.BranchId,
+			BranchIds: []int{key.BranchId},
+		}, nil
+	}
+
+	all, err := RoleHasPermission(ctx, key.RoleId, "branch", "access_all")
+	if err != nil {
+		return utils.BranchClaim{}, err
+	}
+	return utils.BranchClaim{AllBranches: all}, nil
+}
@@ -0,0 +1,387 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/config"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type AuditLog struct {
+	ID         int       `gorm:"primary_key" json:"id"`
+	ActorId    int       `gorm:"index;not null;default:0" json:"actor_id"`
+	ActorType  string    `gorm:"size:20;not null" json:"actor_type"`
+	Operation  string    `gorm:"size:100;not null" json:"operation"`
+	Action     string    `gorm:"size:10;not null" json:"action"`
+	EntityType string    `gorm:"index:idx_audit_entity;size:100;not null" json:"entity_type"`
+	EntityId   int       `gorm:"index:idx_audit_entity;not null;default:0" json:"entity_id"`
+	Changes    string    `gorm:"type:text" json:"changes"`
+	ClientIp   string    `gorm:"size:45" json:"client_ip"`
+	CreatedAt  time.Time `gorm:"index;autoCreateTime" json:"created_at"`
+}
+
+type AuditLogEdge struct {
+	Cursor string   `json:"cursor"`
+	Node   AuditLog `json:"node,omitempty"`
+}
+
+type AuditLogPagination struct {
+	Edges    []*AuditLogEdge `json:"edges"`
+	PageInfo *PageInfo       `json:"pageInfo"`
+}
+
+// AuditInfo describes who is running the current mutation; writes are only audited when it is in the context.
+type AuditInfo struct {
+	ActorId   int
+	ActorType string
+	Operation string
+	ClientIp  string
+}
+
+type auditChange struct {
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
+
+type auditCtxKey string
+
+const (
+	auditInfoKey   = auditCtxKey("auditInfo")
+	auditBeforeKey = "audit:before"
+
+	// auditRowLimit caps how many rows a single bulk update or delete records
+	auditRowLimit = 1000
+)
+
+// Tables that are written on every login or are the log itself
+var auditSkippedTables = map[string]bool{
+	"audit_logs":     true,
+	"sessions":       true,
+	"login_attempts": true,
+}
+
+// Secrets are never copied into the log, only the fact that they changed
+var auditRedactedColumns = map[string]bool{
+	"password":           true,
+	"totp_secret":        true,
+	"refresh_token_hash": true,
+	"token_hash":         true,
+	"key_hash":           true,
+	"code_hash":          true,
+	"private_key":        true,
+}
+
+func WithAuditInfo(ctx context.Context, info *AuditInfo) context.Context {
+	return context.WithValue(ctx, auditInfoKey, info)
+}
+
+func auditInfoValue(ctx context.Context) *AuditInfo {
+	if ctx == nil {
+		return nil
+	}
+	raw, _ := ctx.Value(auditInfoKey).(*AuditInfo)
+	return raw
+}
+
+// RegisterAuditCallbacks hooks the audit log into every create, update and delete made through db.
+func RegisterAuditCallbacks(db *gorm.DB) error {
+	if err := db.Callback().Create().After("gorm:create").Register("audit:after_create", auditAfterCreate); err != nil {
+		return err
+	}
+	if err := db.Callback().Update().Before("gorm:update").Register("audit:before_update", auditBeforeWrite); err != nil {
+		return err
+	}
+	if err := db.Callback().Update().After("gorm:update").Register("audit:after_update", auditAfterUpdate); err != nil {
+		return err
+	}
+	if err := db.Callback().Delete().Before("gorm:delete").Register("audit:before_delete", auditBeforeWrite); err != nil {
+		return err
+	}
+	return db.Callback().Delete().After("gorm:delete").Register("audit:after_delete", auditAfterDelete)
+}
+
+func isAudited(db *gorm.DB) bool {
+	return db.Error == nil && db.Statement.Schema != nil &&
+		!auditSkippedTables[db.Statement.Table] && auditInfoValue(db.Statement.Context) != nil
+}
+
+// auditSession runs on the statement's connection so audit rows share its transaction.
+func auditSession(db *gorm.DB) *gorm.DB {
+	return db.Session(&gorm.Session{NewDB: true, Context: db.Statement.Context})
+}
+
+func auditAfterCreate(db *gorm.DB) {
+	if !isAudited(db) {
+		return
+	}
+
+	stmt := db.Statement
+	var rows []map[string]interface{}
+
+	switch stmt.ReflectValue.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < stmt.ReflectValue.Len(); i++ {
+			rows = append(rows, auditRowValues(db, reflect.Indirect(stmt.ReflectValue.Index(i))))
+		}
+	case reflect.Struct:
+		rows = append(rows, auditRowValues(db, stmt.ReflectValue))
+	}
+
+	for _, row := range rows {
+		changes := make(map[string]auditChange, len(row))
+		for column, value := range row {
+			changes[column] = auditChange{New: auditValue(column, value)}
+		}
+		writeAuditLog(db, "create", row, changes)
+	}
+}
+
+// auditBeforeWrite loads the rows an update or delete is about to touch.
+func auditBeforeWrite(db *gorm.DB) {
+	if !isAudited(db) {
+		return
+	}
+
+	stmt := db.Statement
+	query := auditSession(db).Table(stmt.Table)
+	conditions := 0
+
+	if c, ok := stmt.Clauses["WHERE"]; ok {
+		if where, ok := c.Expression.(clause.Where); ok && len(where.Exprs) > 0 {
+			query = query.Clauses(where)
+			conditions++
+		}
+	}
+
+	// gorm adds the primary key of the model itself while building the statement
+	if stmt.ReflectValue.Kind() == reflect.Struct {
+		for _, field := range stmt.Schema.PrimaryFields {
+			if value, zero := field.ValueOf(stmt.Context, stmt.ReflectValue); !zero {
+				query = query.Where(clause.Eq{Column: clause.Column{Name: field.DBName}, Value: value})
+				conditions++
+			}
+		}
+	}
+
+	// gorm refuses writes without conditions, so there is nothing to capture either
+	if conditions == 0 {
+		return
+	}
+
+	var rows []map[string]interface{}
+	if err := query.Limit(auditRowLimit).Find(&rows).Error; err != nil {
+		db.AddError(err)
+		return
+	}
+	db.InstanceSet(auditBeforeKey, rows)
+}
+
+func auditAfterUpdate(db *gorm.DB) {
+	if !isAudited(db) {
+		return
+	}
+
+	rows, ok := auditBeforeRows(db)
+	if !ok {
+		return
+	}
+
+	stmt := db.Statement
+	for _, before := range rows {
+		var after map[string]interface{}
+		query := auditSession(db).Table(stmt.Table)
+		for _, field := range stmt.Schema.PrimaryFields {
+			query = query.Where(clause.Eq{Column: clause.Column{Name: field.DBName}, Value: before[field.DBName]})
+		}
+		if err := query.Take(&after).Error; err != nil {
+			db.AddError(err)
+			return
+		}
+
+		changes := make(map[string]auditChange)
+		for column, value := range after {
+			if !auditEqual(before[column], value) {
+				changes[column] = auditChange{
+					Old: auditValue(column, before[column]),
+					New: auditValue(column, value),
+				}
+			}
+		}
+		if len(changes) > 0 {
+			writeAuditLog(db, "update", after, changes)
+		}
+	}
+}
+
+func auditAfterDelete(db *gorm.DB) {
+	if !isAudited(db) {
+		return
+	}
+
+	rows, ok := auditBeforeRows(db)
+	if !ok {
+		return
+	}
+
+	for _, before := range rows {
+		changes := make(map[string]auditChange, len(before))
+		for column, value := range before {
+			changes[column] = auditChange{Old: auditValue(column, value)}
+		}
+		writeAuditLog(db, "delete", before, changes)
+	}
+}
+
+func auditBeforeRows(db *gorm.DB) ([]map[string]interface{}, bool) {
+	value, ok := db.InstanceGet(auditBeforeKey)
+	if !ok {
+		return nil, false
+	}
+	rows, ok := value.([]map[string]interface{})
+	return rows, ok
+}
+
+func auditRowValues(db *gorm.DB, rv reflect.Value) map[string]interface{} {
+	row := make(map[string]interface{})
+	for _, field := range db.Statement.Schema.Fields {
+		if field.DBName == "" {
+			continue
+		}
+		value, _ := field.ValueOf(db.Statement.Context, rv)
+		row[field.DBName] = value
+	}
+	return row
+}
+
+func writeAuditLog(db *gorm.DB, action string, row map[string]interface{}, changes map[string]auditChange) {
+
+	info := auditInfoValue(db.Statement.Context)
+
+	diff, err := json.Marshal(changes)
+	if err != nil {
+		db.AddError(err)
+		return
+	}
+
+	entityId := 0
+	if field := db.Statement.Schema.PrioritizedPrimaryField; field != nil {
+		entityId, _ = strconv.Atoi(fmt.Sprint(auditNormalize(row[field.DBName])))
+	} else if len(db.Statement.Schema.PrimaryFields) > 0 {
+		entityId, _ = strconv.Atoi(fmt.Sprint(auditNormalize(row[db.Statement.Schema.PrimaryFields[0].DBName])))
+	}
+
+	auditLog := AuditLog{
+		ActorId:    info.ActorId,
+		ActorType:  info.ActorType,
+		Operation:  info.Operation,
+		Action:     action,
+		EntityType: db.Statement.Schema.Name,
+		EntityId:   entityId,
+		Changes:    string(diff),
+		ClientIp:   info.ClientIp,
+	}
+	if err := auditSession(db).Create(&auditLog).Error; err != nil {
+		db.AddError(err)
+	}
+}
+
+func auditValue(column string, value interface{}) interface{} {
+	if auditRedactedColumns[column] {
+		return "[redacted]"
+	}
+	return auditNormalize(value)
+}
+
+// auditNormalize turns driver values into something that compares and marshals cleanly.
+func auditNormalize(value interface{}) interface{} {
+	if b, ok := value.([]byte); ok {
+		return string(b)
+	}
+	if rv := reflect.ValueOf(value); rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil
+		}
+		return rv.Elem().Interface()
+	}
+	return value
+}
+
+func auditEqual(a interface{}, b interface{}) bool {
+	return fmt.Sprint(auditNormalize(a)) == fmt.Sprint(auditNormalize(b))
+}
+
+func GetAuditLogs(ctx context.Context, entityType *string, entityId *int, actorId *int, from *time.Time, to *time.Time, first *int, after *string) (*AuditLogPagination, error) {
+
+	db := config.GetDB()
+	var results []AuditLog
+
+	limit := 20
+	if first != nil && *first > 0 && *first <= 100 {
+		limit = *first
+	}
+
+	decodedCursor, err := DecodeCursor(after)
+	if err != nil {
+		return nil, err
+	}
+
+	dbCtx := db.WithContext(ctx)
+	if entityType != nil && len(*entityType) > 0 {
+		dbCtx = dbCtx.Where("entity_type = ?", *entityType)
+	}
+	if entityId != nil {
+		dbCtx = dbCtx.Where("entity_id = ?", *entityId)
+	}
+	if actorId != nil {
+		dbCtx = dbCtx.Where("actor_id = ?", *actorId)
+	}
+	if from != nil {
+		dbCtx = dbCtx.Where("created_at >= ?", *from)
+	}
+	if to != nil {
+		dbCtx = dbCtx.Where("created_at < ?", *to)
+	}
+	if decodedCursor != "" {
+		cursorId, err := strconv.Atoi(decodedCursor)
+		if err != nil {
+			return nil, err
+		}
+		dbCtx = dbCtx.Where("id < ?", cursorId)
+	}
+
+	// Newest entries first
+	err = dbCtx.Order("id desc").Limit(limit + 1).Find(&results).Error
+	if err != nil {
+		return nil, err
+	}
+
+	hasNextPage := len(results) > limit
+	if hasNextPage {
+		results = results[:limit]
+	}
+
+	edges := make([]*AuditLogEdge, len(results))
+	for i, result := range results {
+		edges[i] = &AuditLogEdge{
+			Cursor: EncodeCursor(strconv.Itoa(result.ID)),
+			Node:   result,
+		}
+	}
+
+	pageInfo := PageInfo{HasNextPage: &hasNextPage}
+	if len(edges) > 0 {
+		pageInfo.StartCursor = edges[0].Cursor
+		pageInfo.EndCursor = edges[len(edges)-1].Cursor
+	}
+
+	return &AuditLogPagination{
+		Edges:    edges,
+		PageInfo: &pageInfo,
+	}, nil
+}
@@ -133,7 +133,7 @@ func DeleteBranch(ctx context.Context, id int) (*Branch, error) {
 	return &result, nil
 }
 
-func GetBranch(ctx context.Context, id int) (*Branch, error) {
+func GetBranch(ctx context.Context, scope *utils.BranchClaim, id int) (*Branch, error) {
 
 	db := config.GetDB()
 	var result Branch
@@ -143,14 +143,14 @@ func GetBranch(ctx context.Context, id int) (*Branch, error) {
 		return nil, err
 	}
 
-	err = db.WithContext(ctx).Select(fieldNames).First(&result, id).Error
+	err = db.WithContext(ctx).Scopes(ScopeBranches(scope, "id")).Select(fieldNames).First(&result, id).Error
 	if err != nil {
 		return nil, utils.ErrorRecordNotFound
 	}
 	return &result, nil
 }
 
-func GetBranches(ctx context.Context, name *string, city *string) ([]*Branch, error) {
+func GetBranches(ctx context.Context, scope *utils.BranchClaim, name *string, city *string) ([]*Branch, error) {
 
 	db := config.GetDB()
 	var results []*Branch
@@ -160,7 +160,7 @@ func GetBranches(ctx context.Context, name *string, city *string) ([]*Branch, er
 		return nil, err
 	}
 
-	dbCtx := db.WithContext(ctx)
+	dbCtx := db.WithContext(ctx).Scopes(ScopeBranches(scope, "id"))
 	if name != nil && len(*name) > 0 {
 		dbCtx = dbCtx.Where("name LIKE ?", "%"+*name+"%")
 	}
@@ -174,7 +174,7 @@ func GetBranches(ctx context.Context, name *string, city *string) ([]*Branch, er
 	return results, nil
 }
 
-func GetPaginatedBranches(ctx context.Context, first *int, after *string) (*BranchPagination, error) {
+func GetPaginatedBranches(ctx context.Context, scope *utils.BranchClaim, first *int, after *string) (*BranchPagination, error) {
 
 	decodedCursor, _ := DecodeCursor(after)
 	edges := make([]*BranchEdge, *first)
@@ -191,9 +191,9 @@ func GetPaginatedBranches(ctx context.Context, first *int, after *string) (*Bran
 	}
 
 	if decodedCursor == "" {
-		err = db.WithContext(ctx).Select(fieldNames).Order("name").Limit(*first + 1).Find(&results).Error
+		err = db.WithContext(ctx).Scopes(ScopeBranches(scope, "id")).Select(fieldNames).Order("name").Limit(*first + 1).Find(&results).Error
 	} else {
-		err = db.WithContext(ctx).Select(fieldNames).Order("name").Limit(*first+1).Where("name > ?", decodedCursor).Find(&results).Error
+		err = db.WithContext(ctx).Scopes(ScopeBranches(scope, "id")).Select(fieldNames).Order("name").Limit(*first+1).Where("name > ?", decodedCursor).Find(&results).Error
 	}
 
 	if err != nil {
@@ -3,10 +3,12 @@ package models
 import (
 	"context"
 	"errors"
+	"sort"
 	"time"
 
 	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/config"
 	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/utils"
+	"gorm.io/gorm"
 )
 
 type Category struct {
@@ -164,3 +166,114 @@ func GetCategories(ctx context.Context, name *string) ([]*Category, error) {
 	}
 	return results, nil
 }
+
+// categoryTree maps every category id to its parent id.
+func categoryTree(db *gorm.DB, ctx context.Context) (map[int]int, error) {
+
+	var categories []Category
+
+	err := db.WithContext(ctx).Select("id", "parent_category_id").Find(&categories).Error
+	if err != nil {
+		return nil, err
+	}
+
+	parents := make(map[int]int, len(categories))
+	for _, category := range categories {
+		parents[category.ID] = category.ParentCategoryId
+	}
+	return parents, nil
+}
+
+// categorySubtree returns id and the ids of all categories below it.
+func categorySubtree(parents map[int]int, id int) []int {
+
+	children := make(map[int][]int, len(parents))
+	for child, parent := range parents {
+		children[parent] = append(children[parent], child)
+	}
+
+	// Parents are not checked for cycles on update, so guard against visiting a category twice
+	seen := map[int]bool{id: true}
+	ids := []int{id}
+	for i := 0; i < len(ids); i++ {
+		for _, child := range children[ids[i]] {
+			if !seen[child] {
+				seen[child] = true
+				ids = append(ids, child)
+			}
+		}
+	}
+	return ids
+}
+
+// categoryRollup is one category of a report grouped by category. Value is what the category's
+// own lines add up to; TotalValue includes the categories below it.
+type categoryRollup struct {
+	CategoryId       int
+	ParentCategoryId int
+	Value            float64
+	TotalValue       float64
+}
+
+// categoryRollups groups report values by category. Parent categories get a group even without
+// lines of their own so the tree is complete, up to the root or to rootId when it is set.
+type categoryRollups struct {
+	parents map[int]int
+	rootId  int
+	groups  map[int]*categoryRollup
+}
+
+func newCategoryRollups(parents map[int]int, rootId int) *categoryRollups {
+	return &categoryRollups{parents: parents, rootId: rootId, groups: make(map[int]*categoryRollup)}
+}
+
+// add puts value on categoryId, adding the groups above it as needed.
+func (r *categoryRollups) add(categoryId int, value float64) {
+	r.group(categoryId).Value += value
+}
+
+func (r *categoryRollups) group(categoryId int) *categoryRollup {
+	if g, ok := r.groups[categoryId]; ok {
+		return g
+	}
+	g := &categoryRollup{CategoryId: categoryId}
+	r.groups[categoryId] = g
+	if categoryId != r.rootId {
+		if parentId, ok := r.parents[categoryId]; ok && parentId > 0 {
+			g.ParentCategoryId = parentId
+			r.group(parentId)
+		}
+	}
+	return g
+}
+
+// sorted rolls every group's own value up through the groups above it and returns the groups
+// by category id, with values rounded.
+func (r *categoryRollups) sorted() []*categoryRollup {
+
+	results := make([]*categoryRollup, 0, len(r.groups))
+	for _, g := range r.groups {
+		g.TotalValue = 0
+	}
+	for _, g := range r.groups {
+		// Parents are not checked for cycles on update, so stop at a category already visited
+		seen := map[int]bool{}
+		for current := g; current != nil && !seen[current.CategoryId]; current = r.groups[current.ParentCategoryId] {
+			seen[current.CategoryId] = true
+			current.TotalValue += g.Value
+			if current.ParentCategoryId == 0 {
+				break
+			}
+		}
+		results = append(results, g)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].CategoryId < results[j].CategoryId
+	})
+
+	for _, g := range results {
+		g.Value = roundValue(g.Value)
+		g.TotalValue = roundValue(g.TotalValue)
+	}
+	return results
+}
@@ -0,0 +1,155 @@
+package models
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// CostLayer is stock received at one cost that has not been issued yet. Only items of FIFO
+// products keep layers; issues consume the oldest layer first.
+type CostLayer struct {
+	ID                 int       `gorm:"primary_key" json:"id"`
+	BranchId           int       `gorm:"index:idx_cost_layer_item;not null" json:"branch_id"`
+	ProductId          int       `gorm:"index:idx_cost_layer_item;not null" json:"product_id"`
+	ProductVariationId int       `gorm:"index:idx_cost_layer_item;not null;default:0" json:"product_variation_id"`
+	UnitCost           float64   `gorm:"type:decimal(14,4);not null" json:"unit_cost"`
+	Quantity           float64   `gorm:"type:decimal(14,3);not null" json:"quantity"`
+	Remaining          float64   `gorm:"type:decimal(14,3);not null" json:"remaining"`
+	CreatedAt          time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+const (
+	CostingMethodAverage = "average"
+	CostingMethodFifo    = "fifo"
+)
+
+var costingMethods = map[string]bool{
+	CostingMethodAverage: true,
+	CostingMethodFifo:    true,
+}
+
+// costStockChange values an on-hand change of the locked stock level and keeps the FIFO layers
+// of the item in step. Receipts come in at unitCost, or at the current cost when it is nil;
+// issues go out at the moving average or through the oldest layers. It returns the signed value
+// of the change and the cost per unit it was booked at.
+func costStockChange(tx *gorm.DB, ctx context.Context, product *Product, level *StockLevel, item StockItem, quantity float64, unitCost *float64) (float64, float64, error) {
+
+	if err := seedStockValue(tx, ctx, product, level, item); err != nil {
+		return 0, 0, err
+	}
+
+	if quantity > 0 {
+		cost := currentUnitCost(product, level)
+		if unitCost != nil {
+			cost = *unitCost
+		}
+		if product.CostingMethod == CostingMethodFifo {
+			if err := addCostLayer(tx, ctx, item, quantity, cost); err != nil {
+				return 0, 0, err
+			}
+		}
+		return roundValue(quantity * cost), cost, nil
+	}
+
+	issued := -quantity
+	var value float64
+	if product.CostingMethod == CostingMethodFifo {
+		var err error
+		value, err = consumeCostLayers(tx, ctx, product, item, issued)
+		if err != nil {
+			return 0, 0, err
+		}
+	} else {
+		value = roundValue(issued * currentUnitCost(product, level))
+	}
+
+	// The last unit out takes whatever value is left, so no rounding residue stays behind
+	if roundQuantity(level.OnHand-issued) == 0 {
+		value = level.Value
+	}
+	return -value, roundUnitCost(value / issued), nil
+}
+
+// currentUnitCost is the average cost of the stock on hand, or the product's standard cost when
+// there is nothing on hand to average.
+func currentUnitCost(product *Product, level *StockLevel) float64 {
+	if level.OnHand > 0 && level.Value > 0 {
+		return roundUnitCost(level.Value / level.OnHand)
+	}
+	return product.Cost
+}
+
+// seedStockValue values stock that was on hand before costing started at the product's standard
+// cost, so its first issue does not go out for nothing.
+func seedStockValue(tx *gorm.DB, ctx context.Context, product *Product, level *StockLevel, item StockItem) error {
+
+	if level.OnHand <= 0 || level.Value != 0 || product.Cost <= 0 {
+		return nil
+	}
+
+	level.Value = roundValue(level.OnHand * product.Cost)
+	if product.CostingMethod == CostingMethodFifo {
+		return addCostLayer(tx, ctx, item, level.OnHand, product.Cost)
+	}
+	return nil
+}
+
+func addCostLayer(tx *gorm.DB, ctx context.Context, item StockItem, quantity float64, unitCost float64) error {
+	layer := CostLayer{
+		BranchId:           item.BranchId,
+		ProductId:          item.ProductId,
+		ProductVariationId: item.ProductVariationId,
+		UnitCost:           unitCost,
+		Quantity:           quantity,
+		Remaining:          quantity,
+	}
+	return tx.WithContext(ctx).Create(&layer).Error
+}
+
+// consumeCostLayers takes quantity out of the oldest layers of item and returns its value. Stock
+// issued beyond the layers, as when selling out of stock, goes out at the standard cost.
+func consumeCostLayers(tx *gorm.DB, ctx context.Context, product *Product, item StockItem, quantity float64) (float64, error) {
+
+	var layers []*CostLayer
+
+	err := tx.WithContext(ctx).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("branch_id = ? AND product_id = ? AND product_variation_id = ? AND remaining > 0", item.BranchId, item.ProductId, item.ProductVariationId).
+		Order("id").
+		Find(&layers).Error
+	if err != nil {
+		return 0, err
+	}
+
+	value := 0.0
+	left := quantity
+	for _, layer := range layers {
+		if left <= 0 {
+			break
+		}
+		take := math.Min(layer.Remaining, left)
+		remaining := roundQuantity(layer.Remaining - take)
+		if err := tx.WithContext(ctx).Model(layer).Update("Remaining", remaining).Error; err != nil {
+			return 0, err
+		}
+		value += take * layer.UnitCost
+		left = roundQuantity(left - take)
+	}
+	if left > 0 {
+		value += left * product.Cost
+	}
+	return roundValue(value), nil
+}
+
+// roundValue keeps money to the two decimals the value columns store.
+func roundValue(value float64) float64 {
+	return math.Round(value*100) / 100
+}
+
+func roundUnitCost(cost float64) float64 {
+	return math.Round(cost*10000) / 10000
+}
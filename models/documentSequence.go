@@ -0,0 +1,48 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DocumentSequence hands out the running numbers of one kind of document, such as purchase orders.
+type DocumentSequence struct {
+	ID         int       `gorm:"primary_key" json:"id"`
+	Prefix     string    `gorm:"size:20;not null;uniqueIndex" json:"prefix"`
+	NextNumber int       `gorm:"not null;default:1" json:"next_number"`
+	UpdatedAt  time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// nextDocumentNumber takes the next number of the prefix's sequence, such as PO-000042. The
+// sequence row stays locked until tx ends, so numbers are never handed out twice, and a rolled
+// back document gives its number back.
+func nextDocumentNumber(tx *gorm.DB, ctx context.Context, prefix string) (string, error) {
+
+	var sequence DocumentSequence
+
+	// The first document of a kind creates its sequence; a concurrent creator just loses the insert
+	err := tx.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&DocumentSequence{Prefix: prefix, NextNumber: 1}).Error
+	if err != nil {
+		return "", err
+	}
+
+	err = tx.WithContext(ctx).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("prefix = ?", prefix).
+		Take(&sequence).Error
+	if err != nil {
+		return "", err
+	}
+
+	number := sequence.NextNumber
+	if err := tx.WithContext(ctx).Model(&sequence).Update("NextNumber", number+1).Error; err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s-%06d", prefix, number), nil
+}
@@ -0,0 +1,452 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/config"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/utils"
+	"gorm.io/gorm"
+)
+
+// GoodsReceipt records a delivery from a supplier into a branch, against a purchase order or, with
+// PurchaseOrderId 0, without one.
+type GoodsReceipt struct {
+	ID                int                `gorm:"primary_key" json:"id"`
+	Number            string             `gorm:"size:30;not null;uniqueIndex" json:"number"`
+	PurchaseOrderId   int                `gorm:"index;not null;default:0" json:"purchase_order_id"`
+	SupplierId        int                `gorm:"index;not null" json:"supplier_id"`
+	BranchId          int                `gorm:"index;not null" json:"branch_id"`
+	SupplierReference string             `gorm:"size:100" json:"supplier_reference"`
+	Note              string             `gorm:"size:255" json:"note"`
+	Total             float64            `gorm:"type:decimal(14,2);not null;default:0" json:"total"`
+	ReceivedBy        int                `gorm:"not null;default:0" json:"received_by"`
+	Lines             []GoodsReceiptLine `gorm:"foreignKey:GoodsReceiptId" json:"lines"`
+	CreatedAt         time.Time          `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// GoodsReceiptLine is Quantity of an item received in UomId at UnitCost each; BaseQuantity is the
+// same in the base unit, which is what went into stock.
+type GoodsReceiptLine struct {
+	ID                  int                   `gorm:"primary_key" json:"id"`
+	GoodsReceiptId      int                   `gorm:"index;not null" json:"goods_receipt_id"`
+	PurchaseOrderLineId int                   `gorm:"index;not null;default:0" json:"purchase_order_line_id"`
+	ProductId           int                   `gorm:"index;not null" json:"product_id"`
+	ProductVariationId  int                   `gorm:"not null;default:0" json:"product_variation_id"`
+	UomId               int                   `gorm:"not null;default:0" json:"uom_id"`
+	Quantity            float64               `gorm:"type:decimal(14,3);not null" json:"quantity"`
+	BaseQuantity        float64               `gorm:"type:decimal(14,3);not null" json:"base_quantity"`
+	UnitCost            float64               `gorm:"type:decimal(14,4);not null;default:0" json:"unit_cost"`
+	LineTotal           float64               `gorm:"type:decimal(14,2);not null;default:0" json:"line_total"`
+	Lots                []GoodsReceiptLineLot `gorm:"foreignKey:GoodsReceiptLineId" json:"lots"`
+}
+
+// GoodsReceiptLineLot is a lot a lot-tracked line was received into, in the base unit.
+type GoodsReceiptLineLot struct {
+	ID                 int        `gorm:"primary_key" json:"id"`
+	GoodsReceiptLineId int        `gorm:"index;not null" json:"goods_receipt_line_id"`
+	LotId              int        `gorm:"index;not null" json:"lot_id"`
+	LotNumber          string     `gorm:"size:100;not null" json:"lot_number"`
+	ProductionDate     *time.Time `gorm:"type:date" json:"production_date"`
+	ExpiryDate         *time.Time `gorm:"type:date" json:"expiry_date"`
+	Quantity           float64    `gorm:"type:decimal(14,3);not null" json:"quantity"`
+}
+
+// NewGoodsReceipt receives against PurchaseOrderId, which sets the supplier and branch, or without
+// an order from SupplierId into BranchId.
+type NewGoodsReceipt struct {
+	PurchaseOrderId   int                    `json:"purchase_order_id"`
+	SupplierId        int                    `json:"supplier_id"`
+	BranchId          int                    `json:"branch_id"`
+	SupplierReference string                 `json:"supplier_reference"`
+	Note              string                 `json:"note"`
+	Lines             []*NewGoodsReceiptLine `json:"lines" binding:"required,dive,required"`
+}
+
+// NewGoodsReceiptLine refers to a purchase order line, or gives the item when there is no order.
+// Quantity and lot quantities are in UomId, which defaults to the order line's unit or the
+// item's purchase unit; UnitCost defaults to the order line's cost or the default purchase cost.
+type NewGoodsReceiptLine struct {
+	PurchaseOrderLineId int              `json:"purchase_order_line_id"`
+	ProductId           int              `json:"product_id"`
+	ProductVariationId  int              `json:"product_variation_id"`
+	UomId               int              `json:"uom_id"`
+	Quantity            float64          `json:"quantity" binding:"required"`
+	UnitCost            *float64         `json:"unit_cost"`
+	Lots                []*NewReceiptLot `json:"lots"`
+}
+
+type NewReceiptLot struct {
+	LotNumber      string     `json:"lot_number" binding:"required"`
+	ProductionDate *time.Time `json:"production_date"`
+	ExpiryDate     *time.Time `json:"expiry_date"`
+	Quantity       float64    `json:"quantity" binding:"required"`
+}
+
+const (
+	goodsReceiptPrefix    = "GRN"
+	goodsReceiptReference = "goods_receipt"
+)
+
+// receiptLine is a receipt line resolved to its item, unit and costs.
+type receiptLine struct {
+	orderLine *PurchaseOrderLine
+	item      StockItem
+	uomId     int
+	factor    float64
+	quantity  float64
+	unitCost  float64
+	lots      []*NewReceiptLot
+}
+
+// CreateGoodsReceipt books a delivery into stock at its purchase cost. Against an order, each line
+// may bring the order line up to its quantity plus the over-receipt tolerance; the order then
+// closes once every line is within the under-receipt tolerance of its quantity.
+func CreateGoodsReceipt(ctx context.Context, scope *utils.BranchClaim, input *NewGoodsReceipt, actorId int) (*GoodsReceipt, error) {
+
+	db := config.GetDB()
+
+	if len(input.Lines) == 0 {
+		return nil, errors.New("a goods receipt needs at least one line")
+	}
+
+	tx := db.Begin()
+
+	receipt := GoodsReceipt{
+		SupplierId:        input.SupplierId,
+		BranchId:          input.BranchId,
+		SupplierReference: truncate(input.SupplierReference, 100),
+		Note:              truncate(input.Note, 255),
+		ReceivedBy:        actorId,
+	}
+
+	var order *PurchaseOrder
+	if input.PurchaseOrderId > 0 {
+		var err error
+		order, err = lockPurchaseOrder(tx, ctx, scope, input.PurchaseOrderId)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		if order.Status != PurchaseOrderStatusApproved && order.Status != PurchaseOrderStatusPartiallyReceived {
+			tx.Rollback()
+			return nil, errors.New("only approved purchase orders can be received")
+		}
+		receipt.PurchaseOrderId = order.ID
+		receipt.SupplierId = order.SupplierId
+		receipt.BranchId = order.BranchId
+	} else if err := validateAdHocReceipt(tx, ctx, scope, &receipt); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	lines, err := resolveReceiptLines(tx, ctx, order, receipt.SupplierId, receipt.BranchId, input.Lines)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	number, err := nextDocumentNumber(tx, ctx, goodsReceiptPrefix)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	receipt.Number = number
+	if err := tx.WithContext(ctx).Create(&receipt).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	total := 0.0
+	for _, line := range lines {
+		lineTotal, err := postReceiptLine(tx, ctx, &receipt, line, actorId)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		total += lineTotal
+	}
+
+	if err := tx.WithContext(ctx).Model(&receipt).Update("Total", roundValue(total)).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if order != nil {
+		if err := receivePurchaseOrder(tx, ctx, order, actorId); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return GetGoodsReceipt(ctx, scope, receipt.ID)
+}
+
+func validateAdHocReceipt(tx *gorm.DB, ctx context.Context, scope *utils.BranchClaim, receipt *GoodsReceipt) error {
+
+	var supplier Supplier
+
+	if scope == nil || !scope.CanAccessBranch(receipt.BranchId) {
+		return errors.New("branch is not accessible")
+	}
+	if !utils.IsRecordValidByID(receipt.BranchId, &Branch{}, tx) {
+		return errors.New("invalid branch id")
+	}
+	if err := tx.WithContext(ctx).First(&supplier, receipt.SupplierId).Error; err != nil {
+		return errors.New("invalid supplier id")
+	}
+	return nil
+}
+
+// resolveReceiptLines checks the input lines against the order, if any, and returns them sorted so
+// stock rows are always locked in the same order.
+func resolveReceiptLines(tx *gorm.DB, ctx context.Context, order *PurchaseOrder, supplierId int, branchId int, inputs []*NewGoodsReceiptLine) ([]*receiptLine, error) {
+
+	_, overTolerance := utils.ReceiptTolerances()
+
+	orderLines := make(map[int]*PurchaseOrderLine)
+	if order != nil {
+		for i := range order.Lines {
+			orderLines[order.Lines[i].ID] = &order.Lines[i]
+		}
+	}
+
+	lines := make([]*receiptLine, 0, len(inputs))
+	seen := make(map[int]bool, len(inputs))
+	for _, input := range inputs {
+		line := receiptLine{lots: input.Lots}
+
+		if order != nil {
+			orderLine, ok := orderLines[input.PurchaseOrderLineId]
+			if !ok {
+				return nil, errors.New("line does not belong to the purchase order")
+			}
+			if seen[orderLine.ID] {
+				return nil, errors.New("duplicate goods receipt line")
+			}
+			seen[orderLine.ID] = true
+			line.orderLine = orderLine
+			line.item = orderLine.stockItem(branchId)
+			line.uomId = orderLine.UomId
+		} else {
+			if input.PurchaseOrderLineId > 0 {
+				return nil, errors.New("a receipt without a purchase order cannot refer to order lines")
+			}
+			line.item = StockItem{BranchId: branchId, ProductId: input.ProductId, ProductVariationId: input.ProductVariationId}
+		}
+
+		product, err := validateStockItem(tx, ctx, line.item)
+		if err != nil {
+			return nil, err
+		}
+
+		if input.UomId > 0 {
+			line.uomId = input.UomId
+		} else if order == nil {
+			unit, err := purchaseUnit(tx, ctx, line.item.ProductId, line.item.ProductVariationId)
+			if err != nil {
+				return nil, err
+			}
+			if unit != nil {
+				line.uomId = unit.UomId
+			}
+		}
+		line.factor, err = unitFactor(tx, ctx, line.item.ProductId, line.item.ProductVariationId, line.uomId)
+		if err != nil {
+			return nil, err
+		}
+
+		line.quantity = roundQuantity(input.Quantity)
+		if line.quantity <= 0 {
+			return nil, errors.New("received quantity must be positive")
+		}
+		if len(line.lots) > 0 && !product.IsLotTracked {
+			return nil, errors.New("lots can only be given for lot-tracked products")
+		}
+
+		switch {
+		case input.UnitCost != nil:
+			if *input.UnitCost < 0 {
+				return nil, errors.New("unit cost cannot be negative")
+			}
+			line.unitCost = roundUnitCost(*input.UnitCost)
+		case line.orderLine != nil:
+			// The order line's cost is per its own unit
+			orderFactor, err := unitFactor(tx, ctx, line.item.ProductId, line.item.ProductVariationId, line.orderLine.UomId)
+			if err != nil {
+				return nil, err
+			}
+			line.unitCost = roundUnitCost(line.orderLine.UnitCost / orderFactor * line.factor)
+		default:
+			line.unitCost, err = defaultPurchaseCost(tx, ctx, supplierId, line.item.ProductId, line.item.ProductVariationId, line.factor)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if line.orderLine != nil {
+			limit := roundQuantity(line.orderLine.BaseQuantity * (1 + overTolerance/100))
+			if roundQuantity(line.orderLine.ReceivedQuantity+line.baseQuantity()) > limit {
+				return nil, errors.New("received quantity exceeds the ordered quantity and its tolerance")
+			}
+		}
+		lines = append(lines, &line)
+	}
+
+	sort.SliceStable(lines, func(i, j int) bool {
+		a, b := lines[i].item, lines[j].item
+		if a.ProductId != b.ProductId {
+			return a.ProductId < b.ProductId
+		}
+		return a.ProductVariationId < b.ProductVariationId
+	})
+	return lines, nil
+}
+
+// receivePurchaseOrder moves an order to partially received, or closes it once every line is
+// received to within the under-receipt tolerance.
+func receivePurchaseOrder(tx *gorm.DB, ctx context.Context, order *PurchaseOrder, actorId int) error {
+
+	underTolerance, _ := utils.ReceiptTolerances()
+
+	complete := true
+	for _, line := range order.Lines {
+		if line.ReceivedQuantity < roundQuantity(line.BaseQuantity*(1-underTolerance/100)) {
+			complete = false
+			break
+		}
+	}
+
+	updates := map[string]interface{}{"Status": PurchaseOrderStatusPartiallyReceived}
+	if complete {
+		updates = map[string]interface{}{
+			"Status":   PurchaseOrderStatusClosed,
+			"ClosedBy": actorId,
+			"ClosedAt": time.Now(),
+		}
+	}
+	return tx.WithContext(ctx).Model(order).Updates(updates).Error
+}
+
+func (line *PurchaseOrderLine) stockItem(branchId int) StockItem {
+	return StockItem{
+		BranchId:           branchId,
+		ProductId:          line.ProductId,
+		ProductVariationId: line.ProductVariationId,
+	}
+}
+
+func (line *receiptLine) baseQuantity() float64 {
+	return roundQuantity(line.quantity * line.factor)
+}
+
+// postReceiptLine puts one line into stock and onto its order line, and returns its value.
+func postReceiptLine(tx *gorm.DB, ctx context.Context, receipt *GoodsReceipt, line *receiptLine, actorId int) (float64, error) {
+
+	baseQuantity := line.baseQuantity()
+
+	lots := make([]LotAllocation, 0, len(line.lots))
+	for _, lot := range line.lots {
+		lots = append(lots, LotAllocation{
+			LotNumber:      lot.LotNumber,
+			ProductionDate: lot.ProductionDate,
+			ExpiryDate:     lot.ExpiryDate,
+			Quantity:       roundQuantity(lot.Quantity * line.factor),
+		})
+	}
+
+	unitCost := roundUnitCost(line.unitCost / line.factor)
+	posting, err := postStockChange(tx, ctx, line.item, StockChange{
+		OnHand:        baseQuantity,
+		Lots:          lots,
+		UnitCost:      &unitCost,
+		Reason:        StockReasonReceipt,
+		ReferenceType: goodsReceiptReference,
+		ReferenceId:   receipt.ID,
+		ActorId:       actorId,
+		Note:          receipt.Number,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	receiptLine := GoodsReceiptLine{
+		GoodsReceiptId:     receipt.ID,
+		ProductId:          line.item.ProductId,
+		ProductVariationId: line.item.ProductVariationId,
+		UomId:              line.uomId,
+		Quantity:           line.quantity,
+		BaseQuantity:       baseQuantity,
+		UnitCost:           line.unitCost,
+		LineTotal:          roundValue(line.quantity * line.unitCost),
+	}
+	if line.orderLine != nil {
+		receiptLine.PurchaseOrderLineId = line.orderLine.ID
+	}
+	if err := tx.WithContext(ctx).Create(&receiptLine).Error; err != nil {
+		return 0, err
+	}
+
+	for _, allocation := range posting.Lots {
+		lineLot := GoodsReceiptLineLot{
+			GoodsReceiptLineId: receiptLine.ID,
+			LotId:              allocation.LotId,
+			LotNumber:          allocation.LotNumber,
+			ProductionDate:     allocation.ProductionDate,
+			ExpiryDate:         allocation.ExpiryDate,
+			Quantity:           allocation.Quantity,
+		}
+		if err := tx.WithContext(ctx).Create(&lineLot).Error; err != nil {
+			return 0, err
+		}
+	}
+
+	if line.orderLine != nil {
+		line.orderLine.ReceivedQuantity = roundQuantity(line.orderLine.ReceivedQuantity + baseQuantity)
+		err := tx.WithContext(ctx).Model(line.orderLine).Update("ReceivedQuantity", line.orderLine.ReceivedQuantity).Error
+		if err != nil {
+			return 0, err
+		}
+	}
+	return receiptLine.LineTotal, nil
+}
+
+func GetGoodsReceipt(ctx context.Context, scope *utils.BranchClaim, id int) (*GoodsReceipt, error) {
+
+	db := config.GetDB()
+	var receipt GoodsReceipt
+
+	err := db.WithContext(ctx).Scopes(ScopeBranches(scope, "branch_id")).Preload("Lines.Lots").First(&receipt, id).Error
+	if err != nil {
+		return nil, utils.ErrorRecordNotFound
+	}
+	return &receipt, nil
+}
+
+func GetGoodsReceipts(ctx context.Context, scope *utils.BranchClaim, purchaseOrderId *int, supplierId *int, branchId *int) ([]*GoodsReceipt, error) {
+
+	db := config.GetDB()
+	var results []*GoodsReceipt
+
+	dbCtx := db.WithContext(ctx).Scopes(ScopeBranches(scope, "branch_id"))
+	if purchaseOrderId != nil {
+		dbCtx = dbCtx.Where("purchase_order_id = ?", *purchaseOrderId)
+	}
+	if supplierId != nil {
+		dbCtx = dbCtx.Where("supplier_id = ?", *supplierId)
+	}
+	if branchId != nil {
+		dbCtx = dbCtx.Where("branch_id = ?", *branchId)
+	}
+
+	err := dbCtx.Preload("Lines.Lots").Order("created_at desc").Limit(200).Find(&results).Error
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
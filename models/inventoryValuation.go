@@ -0,0 +1,151 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/config"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/utils"
+	"gorm.io/gorm"
+)
+
+// InventoryValuation is the value of the stock on hand at a moment, grouped by category.
+type InventoryValuation struct {
+	BranchId *int                       `json:"branch_id"`
+	AsOf     time.Time                  `json:"as_of"`
+	Value    float64                    `json:"value"`
+	Groups   []*InventoryValuationGroup `json:"groups"`
+}
+
+// InventoryValuationGroup holds the lines of one category; TotalValue includes the categories below.
+type InventoryValuationGroup struct {
+	CategoryId       int                       `json:"category_id"`
+	ParentCategoryId int                       `json:"parent_category_id"`
+	Value            float64                   `json:"value"`
+	TotalValue       float64                   `json:"total_value"`
+	Lines            []*InventoryValuationLine `json:"lines"`
+}
+
+// InventoryValuationLine is the stock of one variation, or of a product without variations,
+// summed over the branches valued.
+type InventoryValuationLine struct {
+	ProductId          int     `json:"product_id"`
+	ProductVariationId int     `json:"product_variation_id"`
+	ProductTitle       string  `json:"product_title"`
+	VariantName        string  `json:"variant_name"`
+	CategoryId         int     `json:"category_id"`
+	Quantity           float64 `json:"quantity"`
+	UnitCost           float64 `json:"unit_cost"`
+	Value              float64 `json:"value"`
+}
+
+// GetInventoryValuation values the stock of the accessible branches, or of branchId only. Without
+// asOf it reads the current stock levels; with it, the ledger balances as they stood at asOf.
+func GetInventoryValuation(ctx context.Context, scope *utils.BranchClaim, branchId *int, asOf *time.Time) (*InventoryValuation, error) {
+
+	db := config.GetDB()
+	var rows []*InventoryValuationLine
+
+	report := InventoryValuation{BranchId: branchId, AsOf: time.Now()}
+
+	if asOf == nil {
+		dbCtx := valuationLines(db, ctx, "stock_levels", "stock_levels.on_hand AS quantity, stock_levels.value").
+			Scopes(ScopeBranches(scope, "stock_levels.branch_id")).
+			Where("stock_levels.on_hand <> 0 OR stock_levels.value <> 0")
+		if branchId != nil {
+			dbCtx = dbCtx.Where("stock_levels.branch_id = ?", *branchId)
+		}
+		if err := dbCtx.Scan(&rows).Error; err != nil {
+			return nil, err
+		}
+	} else {
+		report.AsOf = *asOf
+
+		// The last movement of each item up to asOf carries its balances at that moment
+		latest := db.Model(&StockMovement{}).
+			Select("MAX(id) AS id").
+			Scopes(ScopeBranches(scope, "branch_id")).
+			Where("created_at <= ?", *asOf).
+			Group("branch_id, product_id, product_variation_id")
+		if branchId != nil {
+			latest = latest.Where("branch_id = ?", *branchId)
+		}
+
+		err := valuationLines(db, ctx, "stock_movements", "stock_movements.balance AS quantity, stock_movements.value_balance AS value").
+			Joins("JOIN (?) AS latest ON latest.id = stock_movements.id", latest).
+			Where("stock_movements.balance <> 0 OR stock_movements.value_balance <> 0").
+			Scan(&rows).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	parents, err := categoryTree(db, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Branches of the same item fold into one line
+	type lineKey struct{ productId, variationId int }
+	lines := make(map[lineKey]*InventoryValuationLine, len(rows))
+	order := make([]*InventoryValuationLine, 0, len(rows))
+	for _, row := range rows {
+		key := lineKey{row.ProductId, row.ProductVariationId}
+		line, ok := lines[key]
+		if !ok {
+			line = &InventoryValuationLine{
+				ProductId:          row.ProductId,
+				ProductVariationId: row.ProductVariationId,
+				ProductTitle:       row.ProductTitle,
+				VariantName:        row.VariantName,
+				CategoryId:         row.CategoryId,
+			}
+			lines[key] = line
+			order = append(order, line)
+		}
+		line.Quantity = roundQuantity(line.Quantity + row.Quantity)
+		line.Value = roundValue(line.Value + row.Value)
+	}
+
+	rollups := newCategoryRollups(parents, 0)
+	groups := make(map[int]*InventoryValuationGroup)
+	for _, line := range order {
+		if line.Quantity != 0 {
+			line.UnitCost = roundUnitCost(line.Value / line.Quantity)
+		}
+		g, ok := groups[line.CategoryId]
+		if !ok {
+			g = &InventoryValuationGroup{CategoryId: line.CategoryId}
+			groups[line.CategoryId] = g
+		}
+		g.Lines = append(g.Lines, line)
+		rollups.add(line.CategoryId, line.Value)
+		report.Value += line.Value
+	}
+
+	report.Groups = make([]*InventoryValuationGroup, 0, len(groups))
+	for _, rollup := range rollups.sorted() {
+		g, ok := groups[rollup.CategoryId]
+		if !ok {
+			g = &InventoryValuationGroup{CategoryId: rollup.CategoryId, Lines: []*InventoryValuationLine{}}
+		}
+		g.ParentCategoryId = rollup.ParentCategoryId
+		g.Value = rollup.Value
+		g.TotalValue = rollup.TotalValue
+		report.Groups = append(report.Groups, g)
+	}
+	report.Value = roundValue(report.Value)
+	return &report, nil
+}
+
+// valuationLines selects columns from table, which has an item per row, along with the product
+// details of each item.
+func valuationLines(db *gorm.DB, ctx context.Context, table string, columns string) *gorm.DB {
+	return db.WithContext(ctx).Table(table).
+		Select(table + ".product_id, " + table + ".product_variation_id, " + columns + ", " +
+			"products.title AS product_title, products.category_id, " +
+			"COALESCE(product_variations.variant_name, '') AS variant_name").
+		Joins("JOIN products ON products.id = " + table + ".product_id").
+		Joins("LEFT JOIN product_variations ON product_variations.id = " + table + ".product_variation_id").
+		Order("products.category_id, products.title, " + table + ".product_variation_id")
+}
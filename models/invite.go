@@ -0,0 +1,330 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/config"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/utils"
+)
+
+type Invite struct {
+	ID             int        `gorm:"primary_key" json:"id"`
+	Email          string     `gorm:"index;size:255;not null" json:"email"`
+	RoleId         int        `gorm:"not null;default:0" json:"role_id"`
+	TokenHash      string     `gorm:"size:64;not null;uniqueIndex" json:"-"`
+	CreatedBy      int        `gorm:"not null;default:0" json:"created_by"`
+	ExpiresAt      time.Time  `gorm:"not null" json:"expires_at"`
+	AcceptedAt     *time.Time `json:"accepted_at"`
+	AcceptedUserId int        `gorm:"not null;default:0" json:"accepted_user_id"`
+	RevokedAt      *time.Time `json:"revoked_at"`
+	CreatedAt      time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	BranchIds      []int      `gorm:"-" json:"branch_ids"`
+}
+
+type InviteBranches struct {
+	InviteID int `gorm:"primaryKey"`
+	BranchID int `gorm:"primaryKey"`
+}
+
+type NewInvite struct {
+	Email     string `json:"email" binding:"required"`
+	RoleId    int    `json:"role_id" binding:"required"`
+	BranchIds []int  `json:"branch_ids"`
+}
+
+type InviteCreated struct {
+	Invite *Invite `json:"invite"`
+	Token  string  `json:"token"`
+}
+
+const (
+	InviteStatusPending  = "pending"
+	InviteStatusAccepted = "accepted"
+	InviteStatusRevoked  = "revoked"
+	InviteStatusExpired  = "expired"
+)
+
+var errInvalidInvite = errors.New("invalid or expired invite")
+
+// Status is derived from the timestamps so an invite never needs updating just because it expired.
+func (invite *Invite) Status() string {
+	switch {
+	case invite.AcceptedAt != nil:
+		return InviteStatusAccepted
+	case invite.RevokedAt != nil:
+		return InviteStatusRevoked
+	case !invite.ExpiresAt.After(time.Now()):
+		return InviteStatusExpired
+	}
+	return InviteStatusPending
+}
+
+// CreateInvite invites a new user into a role that is within the creator's own.
+func CreateInvite(ctx context.Context, input *NewInvite, createdBy int, roleId int) (*InviteCreated, error) {
+
+	db := config.GetDB()
+	var count int64
+
+	email := strings.ToLower(strings.TrimSpace(input.Email))
+	if !utils.IsValidEmail(email) {
+		return nil, errors.New("invalid email address")
+	}
+
+	if !utils.IsRecordValidByID(input.RoleId, &Role{}, db) {
+		return nil, errors.New("invalid role id")
+	}
+	ceiling, err := actorCeiling(db, ctx, createdBy, roleId)
+	if err != nil {
+		return nil, err
+	}
+	if err := ceiling.checkRole(db, ctx, input.RoleId); err != nil {
+		return nil, err
+	}
+
+	if err := validateBranchIds(db, ctx, input.BranchIds); err != nil {
+		return nil, err
+	}
+
+	err = db.WithContext(ctx).Model(&User{}).Where("email = ?", email).Count(&count).Error
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, errors.New("a user with this email already exists")
+	}
+
+	err = db.WithContext(ctx).Model(&Invite{}).
+		Where("email = ? AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > ?", email, time.Now()).
+		Count(&count).Error
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, errors.New("a pending invite for this email already exists")
+	}
+
+	lifespan, err := utils.InviteTokenLifespan()
+	if err != nil {
+		return nil, err
+	}
+
+	tx := db.Begin()
+
+	// The row needs an id before the token naming it can be signed
+	placeholder, err := utils.GenerateRandomToken()
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	invite := Invite{
+		Email:     email,
+		RoleId:    input.RoleId,
+		TokenHash: utils.HashToken(placeholder),
+		CreatedBy: createdBy,
+		ExpiresAt: time.Now().Add(lifespan),
+	}
+	if err := tx.WithContext(ctx).Create(&invite).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	token, err := utils.JwtGenerateInvite(invite.ID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	invite.TokenHash = utils.HashToken(token)
+	if err := tx.WithContext(ctx).Model(&invite).Update("TokenHash", invite.TokenHash).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	for _, branchId := range uniqueInts(input.BranchIds) {
+		inviteBranch := InviteBranches{
+			InviteID: invite.ID,
+			BranchID: branchId,
+		}
+		if err := tx.WithContext(ctx).Create(&inviteBranch).Error; err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	invite.BranchIds = uniqueInts(input.BranchIds)
+	return &InviteCreated{
+		Invite: &invite,
+		Token:  token,
+	}, nil
+}
+
+func RevokeInvite(ctx context.Context, id int) (*Invite, error) {
+
+	db := config.GetDB()
+	var invite Invite
+
+	err := db.WithContext(ctx).First(&invite, id).Error
+	if err != nil {
+		return nil, utils.ErrorRecordNotFound
+	}
+
+	if invite.Status() != InviteStatusPending {
+		return nil, errors.New("only pending invites can be revoked")
+	}
+
+	result := db.WithContext(ctx).Model(&invite).
+		Where("accepted_at IS NULL AND revoked_at IS NULL").
+		Update("RevokedAt", time.Now())
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, errors.New("only pending invites can be revoked")
+	}
+
+	if err := loadInviteBranches(ctx, []*Invite{&invite}); err != nil {
+		return nil, err
+	}
+	return &invite, nil
+}
+
+func GetInvites(ctx context.Context, status *string, email *string) ([]*Invite, error) {
+
+	db := config.GetDB()
+	var results []*Invite
+
+	dbCtx := db.WithContext(ctx)
+	if email != nil && len(*email) > 0 {
+		dbCtx = dbCtx.Where("email = ?", strings.ToLower(*email))
+	}
+	if status != nil {
+		switch *status {
+		case InviteStatusPending:
+			dbCtx = dbCtx.Where("accepted_at IS NULL AND revoked_at IS NULL AND expires_at > ?", time.Now())
+		case InviteStatusAccepted:
+			dbCtx = dbCtx.Where("accepted_at IS NOT NULL")
+		case InviteStatusRevoked:
+			dbCtx = dbCtx.Where("accepted_at IS NULL AND revoked_at IS NOT NULL")
+		case InviteStatusExpired:
+			dbCtx = dbCtx.Where("accepted_at IS NULL AND revoked_at IS NULL AND expires_at <= ?", time.Now())
+		default:
+			return nil, errors.New("invalid invite status")
+		}
+	}
+
+	err := dbCtx.Order("created_at desc").Find(&results).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if err := loadInviteBranches(ctx, results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// AcceptInvite creates the invited user; the invite is claimed in the same transaction so it works only once.
+func AcceptInvite(ctx context.Context, token string, username string, password string, name string) (*User, error) {
+
+	db := config.GetDB()
+	var invite Invite
+
+	inviteId, err := utils.JwtValidateInvite(token)
+	if err != nil {
+		return nil, errInvalidInvite
+	}
+
+	err = db.WithContext(ctx).
+		Where("id = ? AND token_hash = ?", inviteId, utils.HashToken(token)).
+		First(&invite).Error
+	if err != nil || invite.Status() != InviteStatusPending {
+		return nil, errInvalidInvite
+	}
+
+	if err := loadInviteBranches(ctx, []*Invite{&invite}); err != nil {
+		return nil, err
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = strings.TrimSpace(username)
+	}
+	isActive := true
+
+	tx := db.Begin()
+
+	result := tx.WithContext(ctx).Model(&Invite{}).
+		Where("id = ? AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > ?", invite.ID, time.Now()).
+		Update("AcceptedAt", time.Now())
+	if result.Error != nil {
+		tx.Rollback()
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		tx.Rollback()
+		return nil, errInvalidInvite
+	}
+
+	user, err := insertUser(tx, ctx, &NewUser{
+		Username:  username,
+		Name:      name,
+		Email:     invite.Email,
+		Password:  password,
+		IsActive:  &isActive,
+		RoleId:    invite.RoleId,
+		BranchIds: invite.BranchIds,
+	})
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.WithContext(ctx).Model(&Invite{}).Where("id = ?", invite.ID).Update("AcceptedUserId", user.ID).Error
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	user.PrepareGive()
+	return user, nil
+}
+
+func loadInviteBranches(ctx context.Context, invites []*Invite) error {
+
+	if len(invites) == 0 {
+		return nil
+	}
+
+	db := config.GetDB()
+	var rows []InviteBranches
+
+	ids := make([]int, 0, len(invites))
+	byId := make(map[int]*Invite, len(invites))
+	for _, invite := range invites {
+		ids = append(ids, invite.ID)
+		byId[invite.ID] = invite
+	}
+
+	err := db.WithContext(ctx).Where("invite_id IN ?", ids).Order("branch_id").Find(&rows).Error
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		invite := byId[row.InviteID]
+		invite.BranchIds = append(invite.BranchIds, row.BranchID)
+	}
+	return nil
+}
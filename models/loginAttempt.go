@@ -0,0 +1,263 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/config"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/utils"
+	"gorm.io/gorm"
+)
+
+type LoginAttempt struct {
+	ID        int       `gorm:"primary_key" json:"id"`
+	Username  string    `gorm:"index;size:255;not null" json:"username"`
+	ClientIp  string    `gorm:"index;size:45" json:"client_ip"`
+	Succeeded bool      `gorm:"not null;default:false" json:"succeeded"`
+	CreatedAt time.Time `gorm:"index;autoCreateTime" json:"created_at"`
+}
+
+type AccountLockout struct {
+	ID             int        `gorm:"primary_key" json:"id"`
+	Username       string     `gorm:"index;size:255" json:"username"`
+	ClientIp       string     `gorm:"index;size:45" json:"client_ip"`
+	FailedAttempts int        `gorm:"not null;default:0" json:"failed_attempts"`
+	LockedUntil    time.Time  `gorm:"index;not null" json:"locked_until"`
+	UnlockedAt     *time.Time `json:"unlocked_at"`
+	UnlockedBy     int        `gorm:"not null;default:0" json:"unlocked_by"`
+	CreatedAt      time.Time  `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// errInvalidLogin is returned for every failed login so callers cannot tell which accounts exist.
+var errInvalidLogin = errors.New("invalid username or password")
+
+const (
+	maxLoginDelay = 4 * time.Second
+)
+
+func loginSetting(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+// maxFailedLogins is how many failures a username may have within the window, from LOGIN_MAX_ATTEMPTS.
+func maxFailedLogins() int {
+	return loginSetting("LOGIN_MAX_ATTEMPTS", 5)
+}
+
+// maxFailedLoginsPerIp is how many failures one client IP may have within the window, from LOGIN_MAX_ATTEMPTS_PER_IP.
+func maxFailedLoginsPerIp() int {
+	return loginSetting("LOGIN_MAX_ATTEMPTS_PER_IP", 20)
+}
+
+// loginLockoutDuration is both the failure counting window and the lockout length, from LOGIN_LOCKOUT_MINUTES.
+func loginLockoutDuration() time.Duration {
+	return time.Minute * time.Duration(loginSetting("LOGIN_LOCKOUT_MINUTES", 15))
+}
+
+func isLoginLocked(ctx context.Context, username string, clientIp string) (bool, error) {
+
+	db := config.GetDB()
+	var count int64
+
+	query := db.WithContext(ctx).Model(&AccountLockout{}).
+		Where("locked_until > ? AND unlocked_at IS NULL", time.Now())
+	if clientIp != "" {
+		query = query.Where("username = ? OR (username = '' AND client_ip = ?)", username, clientIp)
+	} else {
+		query = query.Where("username = ?", username)
+	}
+
+	if err := query.Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// recentFailedLogins counts failures inside the lockout window that happened after the last
+// successful login or manual unlock.
+func recentFailedLogins(ctx context.Context, column string, value string) (int, error) {
+
+	db := config.GetDB()
+	since := time.Now().Add(-loginLockoutDuration())
+
+	var lastSuccess LoginAttempt
+	err := db.WithContext(ctx).
+		Where(column+" = ? AND succeeded = ? AND created_at > ?", value, true, since).
+		Order("created_at desc").
+		Take(&lastSuccess).Error
+	if err == nil {
+		since = lastSuccess.CreatedAt
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, err
+	}
+
+	var lastUnlock AccountLockout
+	err = db.WithContext(ctx).
+		Where(column+" = ? AND unlocked_at > ?", value, since).
+		Order("unlocked_at desc").
+		Take(&lastUnlock).Error
+	if err == nil {
+		since = *lastUnlock.UnlockedAt
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, err
+	}
+
+	var count int64
+	err = db.WithContext(ctx).Model(&LoginAttempt{}).
+		Where(column+" = ? AND succeeded = ? AND created_at > ?", value, false, since).
+		Count(&count).Error
+	if err != nil {
+		return 0, err
+	}
+	return int(count), nil
+}
+
+// loginDelay doubles with every recent failure, starting at 250ms and capped at maxLoginDelay.
+func loginDelay(failures int) time.Duration {
+	if failures <= 0 {
+		return 0
+	}
+	delay := 250 * time.Millisecond
+	for i := 1; i < failures && delay < maxLoginDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxLoginDelay {
+		delay = maxLoginDelay
+	}
+	return delay
+}
+
+func recordLoginAttempt(ctx context.Context, username string, clientIp string, succeeded bool) error {
+
+	db := config.GetDB()
+
+	attempt := LoginAttempt{
+		Username:  username,
+		ClientIp:  clientIp,
+		Succeeded: succeeded,
+	}
+	return db.WithContext(ctx).Create(&attempt).Error
+}
+
+// recordFailedLogin stores the failure and locks the username or client IP once it crosses its limit.
+func recordFailedLogin(ctx context.Context, username string, clientIp string) error {
+
+	db := config.GetDB()
+
+	if err := recordLoginAttempt(ctx, username, clientIp, false); err != nil {
+		return err
+	}
+
+	failures, err := recentFailedLogins(ctx, "username", username)
+	if err != nil {
+		return err
+	}
+	if failures >= maxFailedLogins() {
+		lockout := AccountLockout{
+			Username:       username,
+			ClientIp:       clientIp,
+			FailedAttempts: failures,
+			LockedUntil:    time.Now().Add(loginLockoutDuration()),
+		}
+		if err := db.WithContext(ctx).Create(&lockout).Error; err != nil {
+			return err
+		}
+	}
+
+	if clientIp == "" {
+		return nil
+	}
+
+	failures, err = recentFailedLogins(ctx, "client_ip", clientIp)
+	if err != nil {
+		return err
+	}
+	if failures >= maxFailedLoginsPerIp() {
+		lockout := AccountLockout{
+			ClientIp:       clientIp,
+			FailedAttempts: failures,
+			LockedUntil:    time.Now().Add(loginLockoutDuration()),
+		}
+		if err := db.WithContext(ctx).Create(&lockout).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// throttleLogin rejects locked usernames and IPs and slows down repeated failures.
+func throttleLogin(ctx context.Context, username string, clientIp string) error {
+
+	locked, err := isLoginLocked(ctx, username, clientIp)
+	if err != nil {
+		return err
+	}
+	if locked {
+		if err := recordLoginAttempt(ctx, username, clientIp, false); err != nil {
+			return err
+		}
+		return errInvalidLogin
+	}
+
+	failures, err := recentFailedLogins(ctx, "username", username)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-time.After(loginDelay(failures)):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
+
+func UnlockUser(ctx context.Context, userId int, adminId int) (bool, error) {
+
+	db := config.GetDB()
+	var user User
+
+	err := db.WithContext(ctx).First(&user, userId).Error
+	if err != nil {
+		return false, utils.ErrorRecordNotFound
+	}
+
+	err = db.WithContext(ctx).Model(&AccountLockout{}).
+		Where("username = ? AND unlocked_at IS NULL", user.Username).
+		Updates(map[string]interface{}{
+			"UnlockedAt": time.Now(),
+			"UnlockedBy": adminId,
+		}).Error
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func GetAccountLockouts(ctx context.Context, username *string, activeOnly *bool) ([]*AccountLockout, error) {
+
+	db := config.GetDB()
+	var results []*AccountLockout
+
+	dbCtx := db.WithContext(ctx)
+	if username != nil && len(*username) > 0 {
+		dbCtx = dbCtx.Where("username = ?", *username)
+	}
+	if activeOnly != nil && *activeOnly {
+		dbCtx = dbCtx.Where("locked_until > ? AND unlocked_at IS NULL", time.Now())
+	}
+
+	err := dbCtx.Order("created_at desc").Limit(200).Find(&results).Error
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
@@ -12,8 +12,20 @@ func MigrateTable() {
 	err := db.AutoMigrate(
 		&Branch{}, 
 		&Role{},
+		&RoleModule{},
 		&Category{}, 
 		&User{}, 
+		&UserBranches{},
+		&Session{},
+		&PasswordReset{},
+		&Invite{},
+		&InviteBranches{},
+		&LoginAttempt{},
+		&AccountLockout{},
+		&TotpRecoveryCode{},
+		&ApiKey{},
+		&AuditLog{},
+		&SigningKey{},
 		&Supplier{},
 		&Product{},
 		&ProductOption{},
@@ -21,8 +33,36 @@ func MigrateTable() {
 		&Tag{},
 		&ProductTags{},
 		&Image{},
+		&StockLevel{},
+		&StockMovement{},
+		&Transfer{},
+		&TransferLine{},
+		&TransferLineLot{},
+		&StockLot{},
+		&AdjustmentReason{},
+		&StockAdjustment{},
+		&StockCount{},
+		&StockCountLine{},
+		&ReorderRule{},
+		&Notification{},
+		&UnitOfMeasure{},
+		&ProductUnit{},
+		&CostLayer{},
+		&Reservation{},
+		&DocumentSequence{},
+		&PurchaseOrder{},
+		&PurchaseOrderLine{},
+		&GoodsReceipt{},
+		&GoodsReceiptLine{},
+		&GoodsReceiptLineLot{},
+		&SupplierProduct{},
 	)
 	if err != nil {
 		log.Fatal(err)
 	}
+
+	// Supplier catalogs came to need supplier:read; roles that manage suppliers keep seeing them
+	if err := backfillPermission(db, "supplier", "read"); err != nil {
+		log.Fatal(err)
+	}
 }
@@ -0,0 +1,77 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/config"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/utils"
+	"gorm.io/gorm"
+)
+
+// Notification is an alert raised for the staff of one branch.
+type Notification struct {
+	ID            int        `gorm:"primary_key" json:"id"`
+	BranchId      int        `gorm:"index;not null" json:"branch_id"`
+	Type          string     `gorm:"index;size:50;not null" json:"type"`
+	Message       string     `gorm:"size:255;not null" json:"message"`
+	ReferenceType string     `gorm:"size:50" json:"reference_type"`
+	ReferenceId   int        `gorm:"not null;default:0" json:"reference_id"`
+	ReadBy        int        `gorm:"not null;default:0" json:"read_by"`
+	ReadAt        *time.Time `json:"read_at"`
+	CreatedAt     time.Time  `gorm:"index;autoCreateTime" json:"created_at"`
+}
+
+const NotificationTypeLowStock = "low_stock"
+
+func createNotification(tx *gorm.DB, ctx context.Context, notification *Notification) error {
+	notification.Message = truncate(notification.Message, 255)
+	return tx.WithContext(ctx).Create(notification).Error
+}
+
+func GetNotifications(ctx context.Context, scope *utils.BranchClaim, branchId *int, unreadOnly bool) ([]*Notification, error) {
+
+	db := config.GetDB()
+	var results []*Notification
+
+	dbCtx := db.WithContext(ctx).Scopes(ScopeBranches(scope, "branch_id"))
+	if branchId != nil {
+		dbCtx = dbCtx.Where("branch_id = ?", *branchId)
+	}
+	if unreadOnly {
+		dbCtx = dbCtx.Where("read_at IS NULL")
+	}
+
+	err := dbCtx.Order("id desc").Limit(200).Find(&results).Error
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func MarkNotificationRead(ctx context.Context, scope *utils.BranchClaim, id int, actorId int) (*Notification, error) {
+
+	db := config.GetDB()
+	var notification Notification
+
+	err := db.WithContext(ctx).Scopes(ScopeBranches(scope, "branch_id")).First(&notification, id).Error
+	if err != nil {
+		return nil, utils.ErrorRecordNotFound
+	}
+	if notification.ReadAt != nil {
+		return &notification, nil
+	}
+
+	now := time.Now()
+	notification.ReadBy = actorId
+	notification.ReadAt = &now
+
+	err = db.WithContext(ctx).Model(&notification).Updates(map[string]interface{}{
+		"ReadBy": notification.ReadBy,
+		"ReadAt": notification.ReadAt,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+	return &notification, nil
+}
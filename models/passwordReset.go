@@ -0,0 +1,141 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/config"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/utils"
+)
+
+type PasswordReset struct {
+	ID        int        `gorm:"primary_key" json:"id"`
+	UserId    int        `gorm:"index;not null" json:"user_id"`
+	TokenHash string     `gorm:"size:64;not null;uniqueIndex" json:"-"`
+	CreatedBy int        `gorm:"not null;default:0" json:"created_by"`
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `gorm:"autoCreateTime" json:"created_at"`
+}
+
+type PasswordResetToken struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+const passwordResetLifespan = time.Hour
+
+var errInvalidResetToken = errors.New("invalid or expired reset token")
+
+// ResetUserPassword issues a one-time token the user can redeem with CompletePasswordReset. The
+// token hands over the account, so the admin must hold every permission the user has, and cannot
+// reset their own password this way.
+func ResetUserPassword(ctx context.Context, userId int, adminId int, roleId int) (*PasswordResetToken, error) {
+
+	db := config.GetDB()
+	var user User
+
+	if userId == adminId {
+		return nil, errors.New("use changePassword for your own account")
+	}
+
+	err := db.WithContext(ctx).Select("id", "role_id").First(&user, userId).Error
+	if err != nil {
+		return nil, utils.ErrorRecordNotFound
+	}
+
+	ceiling, err := actorCeiling(db, ctx, adminId, roleId)
+	if err != nil {
+		return nil, err
+	}
+	if err := ceiling.checkRole(db, ctx, user.RoleId); err != nil {
+		return nil, err
+	}
+
+	token, err := utils.GenerateRandomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	tx := db.Begin()
+
+	// Only the newest reset token stays usable
+	err = tx.WithContext(ctx).Model(&PasswordReset{}).
+		Where("user_id = ? AND used_at IS NULL", userId).
+		Update("UsedAt", time.Now()).Error
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	reset := PasswordReset{
+		UserId:    userId,
+		TokenHash: utils.HashToken(token),
+		CreatedBy: adminId,
+		ExpiresAt: time.Now().Add(passwordResetLifespan),
+	}
+
+	if err := tx.WithContext(ctx).Create(&reset).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	return &PasswordResetToken{
+		Token:     token,
+		ExpiresAt: reset.ExpiresAt,
+	}, nil
+}
+
+func CompletePasswordReset(ctx context.Context, token string, newPassword string) (bool, error) {
+
+	db := config.GetDB()
+	var reset PasswordReset
+	var user User
+
+	err := db.WithContext(ctx).
+		Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", utils.HashToken(token), time.Now()).
+		First(&reset).Error
+	if err != nil {
+		return false, errInvalidResetToken
+	}
+
+	err = db.WithContext(ctx).First(&user, reset.UserId).Error
+	if err != nil {
+		return false, errInvalidResetToken
+	}
+
+	tx := db.Begin()
+
+	// Claim the token first so two concurrent requests cannot both redeem it
+	result := tx.WithContext(ctx).Model(&PasswordReset{}).
+		Where("id = ? AND used_at IS NULL", reset.ID).
+		Update("UsedAt", time.Now())
+	if result.Error != nil {
+		tx.Rollback()
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		tx.Rollback()
+		return false, errInvalidResetToken
+	}
+
+	if err := setUserPassword(tx, ctx, &user, newPassword); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	if err := revokeUserSessions(tx, ctx, user.ID); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -20,6 +20,9 @@ type Product struct {
 	SKU                             string             `gorm:"size:100;unique;default:null" json:"sku"`
 	Barcode                         string             `gorm:"size:100;unique;default:null" json:"barcode"`
 	IsQtyTracked                    bool               `gorm:"default:false" json:"is_qty_tracked"`
+	IsLotTracked                    bool               `gorm:"default:false" json:"is_lot_tracked"`
+	BaseUomId                       int                `gorm:"not null;default:0" json:"base_uom_id"`
+	CostingMethod                   string             `gorm:"size:20;not null;default:average" json:"costing_method"`
 	IsPhysicalProduct               bool               `gorm:"default:false" json:"is_physical_product"`
 	IsContinueSellingOutOfStock 	bool               `gorm:"default:false" json:"is_continue_selling_out_of_stock"`
 	Weight                          float64            `gorm:"type:decimal(10,2);default:0.0" json:"weight"`
@@ -45,6 +48,8 @@ type NewProduct struct {
 	SKU      						string 					`json:"sku"`
 	Barcode      					string 					`json:"barcode"`
 	IsQtyTracked                    bool					`json:"is_qty_tracked"`
+	IsLotTracked                    bool					`json:"is_lot_tracked"`
+	CostingMethod                   string					`json:"costing_method"`
 	IsPhysicalProduct               bool					`json:"is_physical_product"`
 	IsContinueSellingOutOfStock 	bool					`json:"is_continue_selling_out_of_stock"`
 	Weight                          float64					`json:"weight"`
@@ -65,6 +70,8 @@ type UpdateProductInput struct {
 	SKU      						string 					`json:"sku"`
 	Barcode      					string 					`json:"barcode"`
 	IsQtyTracked                    bool					`json:"is_qty_tracked"`
+	IsLotTracked                    *bool					`json:"is_lot_tracked"`
+	CostingMethod                   string					`json:"costing_method"`
 	IsPhysicalProduct               bool					`json:"is_physical_product"`
 	IsContinueSellingOutOfStock 	bool					`json:"is_continue_selling_out_of_stock"`
 	Weight                          float64					`json:"weight"`
@@ -200,6 +207,14 @@ func CreateProduct(ctx context.Context, input *NewProduct) (*Product, error) {
 		return &Product{}, errors.New("duplicate sku or barcode or product title")
 	}
 
+	costingMethod := CostingMethodAverage
+	if input.CostingMethod != "" {
+		if !costingMethods[input.CostingMethod] {
+			return &Product{}, errors.New("costing method must be fifo or average")
+		}
+		costingMethod = input.CostingMethod
+	}
+
 	tx := db.Begin()
 	
 	images, err  :=  mapImageInput(input.Images)
@@ -224,6 +239,8 @@ func CreateProduct(ctx context.Context, input *NewProduct) (*Product, error) {
 		SKU:     						input.SKU,
 		Barcode:     					input.Barcode,
 		IsQtyTracked:     				input.IsQtyTracked,
+		IsLotTracked:     				input.IsLotTracked,
+		CostingMethod:     				costingMethod,
 		IsPhysicalProduct:     			input.IsPhysicalProduct,
 		IsContinueSellingOutOfStock:    input.IsContinueSellingOutOfStock,
 		Weight:     					input.Weight,
@@ -306,6 +323,40 @@ func UpdateProduct(ctx context.Context, id int, input *UpdateProductInput) (*Pro
 		return &Product{}, errors.New("error fetching product")
 	}
 
+	// Stock already on hand has no lots to issue from, and stock in lots cannot be issued without them.
+	// Leaving is_lot_tracked out keeps the current setting.
+	if input.IsLotTracked != nil && *input.IsLotTracked != product.IsLotTracked {
+		var err error
+		if *input.IsLotTracked {
+			err = checkNoUnlottedStock(tx, ctx, product.ID)
+		} else {
+			err = checkNoLottedStock(tx, ctx, product.ID)
+		}
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		product.IsLotTracked = *input.IsLotTracked
+	}
+
+	// Stock on hand is valued under the old method, with no layers to switch it over
+	if input.CostingMethod != "" && input.CostingMethod != product.CostingMethod {
+		if !costingMethods[input.CostingMethod] {
+			tx.Rollback()
+			return nil, errors.New("costing method must be fifo or average")
+		}
+		hasStock, err := productHasStock(tx, ctx, product.ID)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		if hasStock {
+			tx.Rollback()
+			return nil, errors.New("the costing method can only change while the product has no stock")
+		}
+		product.CostingMethod = input.CostingMethod
+	}
+
 	images, err  :=  mapImageInput(input.Images)
 	if err != nil {
 		tx.Rollback()
@@ -0,0 +1,494 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/config"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PurchaseOrder is an order for goods from a supplier, delivered to one branch.
+type PurchaseOrder struct {
+	ID           int                 `gorm:"primary_key" json:"id"`
+	Number       string              `gorm:"size:30;not null;uniqueIndex" json:"number"`
+	SupplierId   int                 `gorm:"index;not null" json:"supplier_id"`
+	BranchId     int                 `gorm:"index;not null" json:"branch_id"`
+	Status       string              `gorm:"index;size:20;not null" json:"status"`
+	ExpectedDate *time.Time          `gorm:"type:date" json:"expected_date"`
+	Note         string              `gorm:"size:255" json:"note"`
+	Subtotal     float64             `gorm:"type:decimal(14,2);not null;default:0" json:"subtotal"`
+	TaxTotal     float64             `gorm:"type:decimal(14,2);not null;default:0" json:"tax_total"`
+	Total        float64             `gorm:"type:decimal(14,2);not null;default:0" json:"total"`
+	CreatedBy    int                 `gorm:"not null;default:0" json:"created_by"`
+	SubmittedBy  int                 `gorm:"not null;default:0" json:"submitted_by"`
+	SubmittedAt  *time.Time          `json:"submitted_at"`
+	ApprovedBy   int                 `gorm:"not null;default:0" json:"approved_by"`
+	ApprovedAt   *time.Time          `json:"approved_at"`
+	ClosedBy     int                 `gorm:"not null;default:0" json:"closed_by"`
+	ClosedAt     *time.Time          `json:"closed_at"`
+	CancelReason string              `gorm:"size:255" json:"cancel_reason"`
+	Lines        []PurchaseOrderLine `gorm:"foreignKey:PurchaseOrderId" json:"lines"`
+	CreatedAt    time.Time           `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt    time.Time           `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// PurchaseOrderLine orders Quantity of an item in UomId at UnitCost each, before tax. BaseQuantity
+// is the same quantity in the base unit, which ReceivedQuantity is also kept in.
+type PurchaseOrderLine struct {
+	ID                 int     `gorm:"primary_key" json:"id"`
+	PurchaseOrderId    int     `gorm:"index;not null" json:"purchase_order_id"`
+	ProductId          int     `gorm:"index;not null" json:"product_id"`
+	ProductVariationId int     `gorm:"not null;default:0" json:"product_variation_id"`
+	UomId              int     `gorm:"not null;default:0" json:"uom_id"`
+	Quantity           float64 `gorm:"type:decimal(14,3);not null" json:"quantity"`
+	BaseQuantity       float64 `gorm:"type:decimal(14,3);not null" json:"base_quantity"`
+	UnitCost           float64 `gorm:"type:decimal(14,4);not null;default:0" json:"unit_cost"`
+	TaxRate            float64 `gorm:"type:decimal(5,2);not null;default:0" json:"tax_rate"`
+	LineTotal          float64 `gorm:"type:decimal(14,2);not null;default:0" json:"line_total"`
+	TaxAmount          float64 `gorm:"type:decimal(14,2);not null;default:0" json:"tax_amount"`
+	ReceivedQuantity   float64 `gorm:"type:decimal(14,3);not null;default:0" json:"received_quantity"`
+}
+
+type NewPurchaseOrder struct {
+	SupplierId   int                     `json:"supplier_id" binding:"required"`
+	BranchId     int                     `json:"branch_id" binding:"required"`
+	ExpectedDate *time.Time              `json:"expected_date"`
+	Note         string                  `json:"note"`
+	Lines        []*NewPurchaseOrderLine `json:"lines" binding:"required,dive,required"`
+}
+
+// NewPurchaseOrderLine leaves UomId at 0 to order in the item's purchase unit, and UnitCost nil to
+// take the default cost of that unit.
+type NewPurchaseOrderLine struct {
+	ProductId          int      `json:"product_id" binding:"required"`
+	ProductVariationId int      `json:"product_variation_id"`
+	UomId              int      `json:"uom_id"`
+	Quantity           float64  `json:"quantity" binding:"required"`
+	UnitCost           *float64 `json:"unit_cost"`
+	TaxRate            float64  `json:"tax_rate"`
+}
+
+const (
+	PurchaseOrderStatusDraft             = "draft"
+	PurchaseOrderStatusSubmitted         = "submitted"
+	PurchaseOrderStatusApproved          = "approved"
+	PurchaseOrderStatusPartiallyReceived = "partially_received"
+	PurchaseOrderStatusClosed            = "closed"
+	PurchaseOrderStatusCancelled         = "cancelled"
+)
+
+const purchaseOrderPrefix = "PO"
+
+// OpenQuantity is what is still to be received, in the base unit.
+func (line *PurchaseOrderLine) OpenQuantity() float64 {
+	open := roundQuantity(line.BaseQuantity - line.ReceivedQuantity)
+	if open < 0 {
+		return 0
+	}
+	return open
+}
+
+func validatePurchaseOrderInput(ctx context.Context, scope *utils.BranchClaim, input *NewPurchaseOrder) error {
+
+	db := config.GetDB()
+	var supplier Supplier
+
+	if scope == nil || !scope.CanAccessBranch(input.BranchId) {
+		return errors.New("branch is not accessible")
+	}
+	if !utils.IsRecordValidByID(input.BranchId, &Branch{}, db) {
+		return errors.New("invalid branch id")
+	}
+	if err := db.WithContext(ctx).First(&supplier, input.SupplierId).Error; err != nil {
+		return errors.New("invalid supplier id")
+	}
+	if supplier.IsActive != nil && !*supplier.IsActive {
+		return errors.New("supplier is not active")
+	}
+	if len(input.Lines) == 0 {
+		return errors.New("a purchase order needs at least one line")
+	}
+
+	type lineKey struct {
+		item  StockItem
+		uomId int
+	}
+	seen := make(map[lineKey]bool, len(input.Lines))
+	for _, line := range input.Lines {
+		if line.Quantity <= 0 {
+			return errors.New("line quantity must be positive")
+		}
+		if line.UnitCost != nil && *line.UnitCost < 0 {
+			return errors.New("unit cost cannot be negative")
+		}
+		if line.TaxRate < 0 || line.TaxRate > 100 {
+			return errors.New("tax rate must be between 0 and 100")
+		}
+		item := StockItem{ProductId: line.ProductId, ProductVariationId: line.ProductVariationId, BranchId: input.BranchId}
+		key := lineKey{item, line.UomId}
+		if seen[key] {
+			return errors.New("duplicate purchase order line")
+		}
+		seen[key] = true
+		if _, err := validateStockItem(db, ctx, item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createPurchaseOrderLines adds the lines to the order and returns its subtotal and tax total.
+func createPurchaseOrderLines(tx *gorm.DB, ctx context.Context, order *PurchaseOrder, lines []*NewPurchaseOrderLine) (float64, float64, error) {
+
+	subtotal, taxTotal := 0.0, 0.0
+	for _, input := range lines {
+		uomId := input.UomId
+		if uomId == 0 {
+			unit, err := purchaseUnit(tx, ctx, input.ProductId, input.ProductVariationId)
+			if err != nil {
+				return 0, 0, err
+			}
+			if unit != nil {
+				uomId = unit.UomId
+			}
+		}
+		factor, err := unitFactor(tx, ctx, input.ProductId, input.ProductVariationId, uomId)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		var unitCost float64
+		if input.UnitCost != nil {
+			unitCost = roundUnitCost(*input.UnitCost)
+		} else {
+			unitCost, err = defaultPurchaseCost(tx, ctx, order.SupplierId, input.ProductId, input.ProductVariationId, factor)
+			if err != nil {
+				return 0, 0, err
+			}
+		}
+
+		quantity := roundQuantity(input.Quantity)
+		lineTotal := roundValue(quantity * unitCost)
+		line := PurchaseOrderLine{
+			PurchaseOrderId:    order.ID,
+			ProductId:          input.ProductId,
+			ProductVariationId: input.ProductVariationId,
+			UomId:              uomId,
+			Quantity:           quantity,
+			BaseQuantity:       roundQuantity(quantity * factor),
+			UnitCost:           unitCost,
+			TaxRate:            input.TaxRate,
+			LineTotal:          lineTotal,
+			TaxAmount:          roundValue(lineTotal * input.TaxRate / 100),
+		}
+		if err := tx.WithContext(ctx).Create(&line).Error; err != nil {
+			return 0, 0, err
+		}
+		subtotal += line.LineTotal
+		taxTotal += line.TaxAmount
+	}
+	return roundValue(subtotal), roundValue(taxTotal), nil
+}
+
+// defaultPurchaseCost is what one uomId of the item costs when the line gives no cost: the
+// supplier's catalog price or else the product's cost, per base unit, times the units in uomId.
+func defaultPurchaseCost(tx *gorm.DB, ctx context.Context, supplierId int, productId int, productVariationId int, factor float64) (float64, error) {
+
+	var product Product
+
+	cost, ok, err := supplierUnitCost(tx, ctx, supplierId, productId, productVariationId)
+	if err != nil {
+		return 0, err
+	}
+	if ok {
+		return roundUnitCost(cost * factor), nil
+	}
+
+	if err := tx.WithContext(ctx).Select("id", "cost").First(&product, productId).Error; err != nil {
+		return 0, errors.New("invalid product id")
+	}
+	return roundUnitCost(product.Cost * factor), nil
+}
+
+func CreatePurchaseOrder(ctx context.Context, scope *utils.BranchClaim, input *NewPurchaseOrder, createdBy int) (*PurchaseOrder, error) {
+
+	db := config.GetDB()
+
+	if err := validatePurchaseOrderInput(ctx, scope, input); err != nil {
+		return nil, err
+	}
+
+	tx := db.Begin()
+
+	number, err := nextDocumentNumber(tx, ctx, purchaseOrderPrefix)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	order := PurchaseOrder{
+		Number:       number,
+		SupplierId:   input.SupplierId,
+		BranchId:     input.BranchId,
+		Status:       PurchaseOrderStatusDraft,
+		ExpectedDate: input.ExpectedDate,
+		Note:         truncate(input.Note, 255),
+		CreatedBy:    createdBy,
+	}
+	if err := tx.WithContext(ctx).Create(&order).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := savePurchaseOrderLines(tx, ctx, &order, input.Lines); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return GetPurchaseOrder(ctx, scope, order.ID)
+}
+
+// UpdatePurchaseOrder replaces the supplier, branch, dates, note and lines of a draft order.
+func UpdatePurchaseOrder(ctx context.Context, scope *utils.BranchClaim, id int, input *NewPurchaseOrder) (*PurchaseOrder, error) {
+
+	db := config.GetDB()
+
+	if err := validatePurchaseOrderInput(ctx, scope, input); err != nil {
+		return nil, err
+	}
+
+	tx := db.Begin()
+
+	order, err := lockPurchaseOrder(tx, ctx, scope, id)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if order.Status != PurchaseOrderStatusDraft {
+		tx.Rollback()
+		return nil, errors.New("only draft purchase orders can be edited")
+	}
+
+	order.SupplierId = input.SupplierId
+	order.BranchId = input.BranchId
+	err = tx.WithContext(ctx).Model(order).Updates(map[string]interface{}{
+		"SupplierId":   input.SupplierId,
+		"BranchId":     input.BranchId,
+		"ExpectedDate": input.ExpectedDate,
+		"Note":         truncate(input.Note, 255),
+	}).Error
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.WithContext(ctx).Where("purchase_order_id = ?", id).Delete(&PurchaseOrderLine{}).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := savePurchaseOrderLines(tx, ctx, order, input.Lines); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return GetPurchaseOrder(ctx, scope, id)
+}
+
+func savePurchaseOrderLines(tx *gorm.DB, ctx context.Context, order *PurchaseOrder, lines []*NewPurchaseOrderLine) error {
+
+	subtotal, taxTotal, err := createPurchaseOrderLines(tx, ctx, order, lines)
+	if err != nil {
+		return err
+	}
+	return tx.WithContext(ctx).Model(order).Updates(map[string]interface{}{
+		"Subtotal": subtotal,
+		"TaxTotal": taxTotal,
+		"Total":    roundValue(subtotal + taxTotal),
+	}).Error
+}
+
+// DeletePurchaseOrder removes a draft order; orders that went further are cancelled instead.
+func DeletePurchaseOrder(ctx context.Context, scope *utils.BranchClaim, id int) (*PurchaseOrder, error) {
+
+	db := config.GetDB()
+
+	tx := db.Begin()
+
+	order, err := lockPurchaseOrder(tx, ctx, scope, id)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if order.Status != PurchaseOrderStatusDraft {
+		tx.Rollback()
+		return nil, errors.New("only draft purchase orders can be deleted")
+	}
+
+	if err := tx.WithContext(ctx).Where("purchase_order_id = ?", id).Delete(&PurchaseOrderLine{}).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.WithContext(ctx).Delete(order).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+func SubmitPurchaseOrder(ctx context.Context, scope *utils.BranchClaim, id int, actorId int) (*PurchaseOrder, error) {
+	return transitionPurchaseOrder(ctx, scope, id, []string{PurchaseOrderStatusDraft}, PurchaseOrderStatusSubmitted, map[string]interface{}{
+		"SubmittedBy": actorId,
+		"SubmittedAt": time.Now(),
+	}, nil)
+}
+
+// ApprovePurchaseOrder approves a submitted order. Like adjustments, nobody reviews their own: the
+// order's creator and submitter cannot approve it.
+func ApprovePurchaseOrder(ctx context.Context, scope *utils.BranchClaim, id int, actorId int) (*PurchaseOrder, error) {
+	return transitionPurchaseOrder(ctx, scope, id, []string{PurchaseOrderStatusSubmitted}, PurchaseOrderStatusApproved, map[string]interface{}{
+		"ApprovedBy": actorId,
+		"ApprovedAt": time.Now(),
+	}, func(order *PurchaseOrder) error {
+		if actorId == order.CreatedBy || actorId == order.SubmittedBy {
+			return errors.New("purchase orders cannot be approved by their creator or submitter")
+		}
+		return nil
+	})
+}
+
+// CancelPurchaseOrder calls off an order nothing has been received against yet.
+func CancelPurchaseOrder(ctx context.Context, scope *utils.BranchClaim, id int, reason string, actorId int) (*PurchaseOrder, error) {
+	from := []string{PurchaseOrderStatusDraft, PurchaseOrderStatusSubmitted, PurchaseOrderStatusApproved}
+	return transitionPurchaseOrder(ctx, scope, id, from, PurchaseOrderStatusCancelled, map[string]interface{}{
+		"CancelReason": truncate(reason, 255),
+		"ClosedBy":     actorId,
+		"ClosedAt":     time.Now(),
+	}, nil)
+}
+
+// ClosePurchaseOrder closes an order short, when what is still open will not be delivered.
+func ClosePurchaseOrder(ctx context.Context, scope *utils.BranchClaim, id int, actorId int) (*PurchaseOrder, error) {
+	from := []string{PurchaseOrderStatusApproved, PurchaseOrderStatusPartiallyReceived}
+	return transitionPurchaseOrder(ctx, scope, id, from, PurchaseOrderStatusClosed, map[string]interface{}{
+		"ClosedBy": actorId,
+		"ClosedAt": time.Now(),
+	}, nil)
+}
+
+// transitionPurchaseOrder moves a locked order from one of the from statuses to to. check, when
+// given, can refuse the move after the order is locked.
+func transitionPurchaseOrder(ctx context.Context, scope *utils.BranchClaim, id int, from []string, to string, updates map[string]interface{}, check func(*PurchaseOrder) error) (*PurchaseOrder, error) {
+
+	db := config.GetDB()
+
+	tx := db.Begin()
+
+	order, err := lockPurchaseOrder(tx, ctx, scope, id)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	allowed := false
+	for _, status := range from {
+		allowed = allowed || order.Status == status
+	}
+	if !allowed {
+		tx.Rollback()
+		return nil, errors.New("cannot move a " + order.Status + " purchase order to " + to)
+	}
+	if check != nil {
+		if err := check(order); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	updates["Status"] = to
+	if err := tx.WithContext(ctx).Model(order).Updates(updates).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return GetPurchaseOrder(ctx, scope, id)
+}
+
+// lockPurchaseOrder reads an order FOR UPDATE along with its lines in a fixed order.
+func lockPurchaseOrder(tx *gorm.DB, ctx context.Context, scope *utils.BranchClaim, id int) (*PurchaseOrder, error) {
+
+	var order PurchaseOrder
+
+	err := tx.WithContext(ctx).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Scopes(ScopeBranches(scope, "branch_id")).
+		First(&order, id).Error
+	if err != nil {
+		return nil, utils.ErrorRecordNotFound
+	}
+
+	err = tx.WithContext(ctx).Where("purchase_order_id = ?", id).Find(&order.Lines).Error
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(order.Lines, func(i, j int) bool {
+		a, b := order.Lines[i], order.Lines[j]
+		if a.ProductId != b.ProductId {
+			return a.ProductId < b.ProductId
+		}
+		return a.ProductVariationId < b.ProductVariationId
+	})
+	return &order, nil
+}
+
+func GetPurchaseOrder(ctx context.Context, scope *utils.BranchClaim, id int) (*PurchaseOrder, error) {
+
+	db := config.GetDB()
+	var order PurchaseOrder
+
+	err := db.WithContext(ctx).Scopes(ScopeBranches(scope, "branch_id")).Preload("Lines").First(&order, id).Error
+	if err != nil {
+		return nil, utils.ErrorRecordNotFound
+	}
+	return &order, nil
+}
+
+func GetPurchaseOrders(ctx context.Context, scope *utils.BranchClaim, status *string, supplierId *int, branchId *int) ([]*PurchaseOrder, error) {
+
+	db := config.GetDB()
+	var results []*PurchaseOrder
+
+	dbCtx := db.WithContext(ctx).Scopes(ScopeBranches(scope, "branch_id"))
+	if status != nil && len(*status) > 0 {
+		dbCtx = dbCtx.Where("status = ?", *status)
+	}
+	if supplierId != nil {
+		dbCtx = dbCtx.Where("supplier_id = ?", *supplierId)
+	}
+	if branchId != nil {
+		dbCtx = dbCtx.Where("branch_id = ?", *branchId)
+	}
+
+	err := dbCtx.Preload("Lines").Order("created_at desc").Limit(200).Find(&results).Error
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
@@ -0,0 +1,385 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/config"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ReorderRule is the reorder point of one item at one branch. IsBelow remembers whether the item
+// was below the point at the last check, so a notification is raised only when it crosses.
+type ReorderRule struct {
+	ID                 int       `gorm:"primary_key" json:"id"`
+	BranchId           int       `gorm:"uniqueIndex:idx_reorder_rule_item;not null" json:"branch_id"`
+	ProductId          int       `gorm:"uniqueIndex:idx_reorder_rule_item;index;not null" json:"product_id"`
+	ProductVariationId int       `gorm:"uniqueIndex:idx_reorder_rule_item;not null;default:0" json:"product_variation_id"`
+	ReorderPoint       float64   `gorm:"type:decimal(14,3);not null;default:0" json:"reorder_point"`
+	ReorderQuantity    float64   `gorm:"type:decimal(14,3);not null;default:0" json:"reorder_quantity"`
+	MaxQuantity        float64   `gorm:"type:decimal(14,3);not null;default:0" json:"max_quantity"`
+	IsBelow            bool      `gorm:"not null;default:false" json:"is_below"`
+	CreatedAt          time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt          time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// reorderThreshold is what a rule orders: below ReorderPoint, order ReorderQuantity, or up to
+// MaxQuantity when that is set.
+type reorderThreshold struct {
+	ReorderPoint    float64 `json:"reorder_point"`
+	ReorderQuantity float64 `json:"reorder_quantity"`
+	MaxQuantity     float64 `json:"max_quantity"`
+}
+
+type NewReorderRule struct {
+	BranchId           int     `json:"branch_id" binding:"required"`
+	ProductId          int     `json:"product_id" binding:"required"`
+	ProductVariationId int     `json:"product_variation_id"`
+	ReorderPoint       float64 `json:"reorder_point"`
+	ReorderQuantity    float64 `json:"reorder_quantity"`
+	MaxQuantity        float64 `json:"max_quantity"`
+}
+
+// BulkReorderRule sets the same threshold on every item of a category, including the categories
+// below it, and/or of a supplier at one branch.
+type BulkReorderRule struct {
+	BranchId        int     `json:"branch_id" binding:"required"`
+	CategoryId      int     `json:"category_id"`
+	SupplierId      int     `json:"supplier_id"`
+	ReorderPoint    float64 `json:"reorder_point"`
+	ReorderQuantity float64 `json:"reorder_quantity"`
+	MaxQuantity     float64 `json:"max_quantity"`
+}
+
+// LowStockItem is a rule whose item is below its reorder point. The stock position counts what
+// is free to sell plus what is already on the way.
+type LowStockItem struct {
+	ReorderRule
+	ProductTitle      string  `json:"product_title"`
+	VariantName       string  `json:"variant_name"`
+	OnHand            float64 `json:"on_hand"`
+	Available         float64 `json:"available"`
+	InTransit         float64 `json:"in_transit"`
+	SuggestedQuantity float64 `json:"suggested_quantity"`
+}
+
+func validateReorderThreshold(threshold reorderThreshold) error {
+	if threshold.ReorderPoint < 0 || threshold.ReorderQuantity < 0 || threshold.MaxQuantity < 0 {
+		return errors.New("reorder quantities cannot be negative")
+	}
+	if threshold.MaxQuantity > 0 && threshold.MaxQuantity < threshold.ReorderPoint {
+		return errors.New("max quantity cannot be below the reorder point")
+	}
+	if threshold.ReorderQuantity == 0 && threshold.MaxQuantity == 0 {
+		return errors.New("a reorder quantity or max quantity is required")
+	}
+	return nil
+}
+
+// upsertReorderRules writes rules, replacing the threshold of items that already have one.
+func upsertReorderRules(tx *gorm.DB, ctx context.Context, rules []ReorderRule) error {
+	return tx.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "branch_id"}, {Name: "product_id"}, {Name: "product_variation_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"reorder_point", "reorder_quantity", "max_quantity", "updated_at"}),
+	}).CreateInBatches(rules, 500).Error
+}
+
+func SetReorderRule(ctx context.Context, scope *utils.BranchClaim, input *NewReorderRule) (*ReorderRule, error) {
+
+	db := config.GetDB()
+	var rule ReorderRule
+
+	if scope == nil || !scope.CanAccessBranch(input.BranchId) {
+		return nil, errors.New("branch is not accessible")
+	}
+
+	threshold := reorderThreshold{
+		ReorderPoint:    roundQuantity(input.ReorderPoint),
+		ReorderQuantity: roundQuantity(input.ReorderQuantity),
+		MaxQuantity:     roundQuantity(input.MaxQuantity),
+	}
+	if err := validateReorderThreshold(threshold); err != nil {
+		return nil, err
+	}
+
+	item := StockItem{
+		BranchId:           input.BranchId,
+		ProductId:          input.ProductId,
+		ProductVariationId: input.ProductVariationId,
+	}
+	if _, err := validateStockItem(db, ctx, item); err != nil {
+		return nil, err
+	}
+
+	err := upsertReorderRules(db, ctx, []ReorderRule{{
+		BranchId:           item.BranchId,
+		ProductId:          item.ProductId,
+		ProductVariationId: item.ProductVariationId,
+		ReorderPoint:       threshold.ReorderPoint,
+		ReorderQuantity:    threshold.ReorderQuantity,
+		MaxQuantity:        threshold.MaxQuantity,
+	}})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.WithContext(ctx).
+		Where("branch_id = ? AND product_id = ? AND product_variation_id = ?", item.BranchId, item.ProductId, item.ProductVariationId).
+		Take(&rule).Error
+	if err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// BulkSetReorderRules sets the threshold on every matching item and returns how many rules were written.
+func BulkSetReorderRules(ctx context.Context, scope *utils.BranchClaim, input *BulkReorderRule) (int, error) {
+
+	db := config.GetDB()
+	var products []Product
+	var variations []ProductVariation
+
+	if scope == nil || !scope.CanAccessBranch(input.BranchId) {
+		return 0, errors.New("branch is not accessible")
+	}
+	if !utils.IsRecordValidByID(input.BranchId, &Branch{}, db) {
+		return 0, errors.New("invalid branch id")
+	}
+	if input.CategoryId == 0 && input.SupplierId == 0 {
+		return 0, errors.New("a category or supplier is required")
+	}
+
+	threshold := reorderThreshold{
+		ReorderPoint:    roundQuantity(input.ReorderPoint),
+		ReorderQuantity: roundQuantity(input.ReorderQuantity),
+		MaxQuantity:     roundQuantity(input.MaxQuantity),
+	}
+	if err := validateReorderThreshold(threshold); err != nil {
+		return 0, err
+	}
+
+	dbCtx := db.WithContext(ctx).Select("id")
+	if input.CategoryId > 0 {
+		if !utils.IsRecordValidByID(input.CategoryId, &Category{}, db) {
+			return 0, errors.New("invalid category id")
+		}
+		parents, err := categoryTree(db, ctx)
+		if err != nil {
+			return 0, err
+		}
+		dbCtx = dbCtx.Where("category_id IN ?", categorySubtree(parents, input.CategoryId))
+	}
+	if input.SupplierId > 0 {
+		if !utils.IsRecordValidByID(input.SupplierId, &Supplier{}, db) {
+			return 0, errors.New("invalid supplier id")
+		}
+		dbCtx = dbCtx.Where("supplier_id = ?", input.SupplierId)
+	}
+	if err := dbCtx.Order("id").Find(&products).Error; err != nil {
+		return 0, err
+	}
+	if len(products) == 0 {
+		return 0, nil
+	}
+
+	productIds := make([]int, len(products))
+	for i, product := range products {
+		productIds[i] = product.ID
+	}
+	err := db.WithContext(ctx).Select("id", "product_id").Where("product_id IN ?", productIds).Order("id").Find(&variations).Error
+	if err != nil {
+		return 0, err
+	}
+
+	variationsByProduct := make(map[int][]int)
+	for _, variation := range variations {
+		variationsByProduct[variation.ProductId] = append(variationsByProduct[variation.ProductId], variation.ID)
+	}
+
+	var rules []ReorderRule
+	for _, productId := range productIds {
+		// A product with variations is only stocked per variation
+		variationIds := variationsByProduct[productId]
+		if len(variationIds) == 0 {
+			variationIds = []int{0}
+		}
+		for _, variationId := range variationIds {
+			rules = append(rules, ReorderRule{
+				BranchId:           input.BranchId,
+				ProductId:          productId,
+				ProductVariationId: variationId,
+				ReorderPoint:       threshold.ReorderPoint,
+				ReorderQuantity:    threshold.ReorderQuantity,
+				MaxQuantity:        threshold.MaxQuantity,
+			})
+		}
+	}
+
+	tx := db.Begin()
+
+	if err := upsertReorderRules(tx, ctx, rules); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return 0, err
+	}
+	return len(rules), nil
+}
+
+func DeleteReorderRule(ctx context.Context, scope *utils.BranchClaim, id int) (*ReorderRule, error) {
+
+	db := config.GetDB()
+	var rule ReorderRule
+
+	err := db.WithContext(ctx).Scopes(ScopeBranches(scope, "branch_id")).First(&rule, id).Error
+	if err != nil {
+		return nil, utils.ErrorRecordNotFound
+	}
+
+	if err := db.WithContext(ctx).Delete(&rule).Error; err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+func GetReorderRules(ctx context.Context, scope *utils.BranchClaim, branchId *int, productId *int) ([]*ReorderRule, error) {
+
+	db := config.GetDB()
+	var results []*ReorderRule
+
+	dbCtx := db.WithContext(ctx).Scopes(ScopeBranches(scope, "branch_id"))
+	if branchId != nil {
+		dbCtx = dbCtx.Where("branch_id = ?", *branchId)
+	}
+	if productId != nil {
+		dbCtx = dbCtx.Where("product_id = ?", *productId)
+	}
+
+	err := dbCtx.Order("branch_id, product_id, product_variation_id").Limit(1000).Find(&results).Error
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// reorderPositions loads rules with the stock and title of their items. Items never stocked have
+// no stock level and count as zero.
+func reorderPositions(db *gorm.DB, ctx context.Context) *gorm.DB {
+	return db.WithContext(ctx).Table("reorder_rules").
+		Select("reorder_rules.*, products.title AS product_title, " +
+			"COALESCE(product_variations.variant_name, '') AS variant_name, " +
+			"COALESCE(stock_levels.on_hand, 0) AS on_hand, " +
+			"COALESCE(stock_levels.available, 0) AS available, " +
+			"COALESCE(stock_levels.in_transit, 0) AS in_transit").
+		Joins("JOIN products ON products.id = reorder_rules.product_id AND products.deleted_at IS NULL").
+		Joins("LEFT JOIN product_variations ON product_variations.id = reorder_rules.product_variation_id").
+		Joins("LEFT JOIN stock_levels ON stock_levels.branch_id = reorder_rules.branch_id " +
+			"AND stock_levels.product_id = reorder_rules.product_id " +
+			"AND stock_levels.product_variation_id = reorder_rules.product_variation_id")
+}
+
+const belowReorderPoint = "COALESCE(stock_levels.available, 0) + COALESCE(stock_levels.in_transit, 0) < reorder_rules.reorder_point"
+
+func (item *LowStockItem) position() float64 {
+	return roundQuantity(item.Available + item.InTransit)
+}
+
+func (item *LowStockItem) suggest() {
+	item.SuggestedQuantity = item.ReorderQuantity
+	if item.MaxQuantity > 0 {
+		item.SuggestedQuantity = roundQuantity(item.MaxQuantity - item.position())
+	}
+}
+
+func GetLowStockItems(ctx context.Context, scope *utils.BranchClaim, branchId *int) ([]*LowStockItem, error) {
+
+	db := config.GetDB()
+	var results []*LowStockItem
+
+	dbCtx := reorderPositions(db, ctx).Scopes(ScopeBranches(scope, "reorder_rules.branch_id")).Where(belowReorderPoint)
+	if branchId != nil {
+		dbCtx = dbCtx.Where("reorder_rules.branch_id = ?", *branchId)
+	}
+
+	err := dbCtx.Order("reorder_rules.branch_id, reorder_rules.product_id, reorder_rules.product_variation_id").Scan(&results).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range results {
+		item.suggest()
+	}
+	return results, nil
+}
+
+// CheckLowStock raises a notification for every item that fell below its reorder point since the
+// last check and re-arms the rules of items that recovered. The flag is flipped conditionally, so
+// several servers running the check raise each notification once.
+func CheckLowStock(ctx context.Context) error {
+
+	db := config.GetDB()
+	var items []*LowStockItem
+
+	err := reorderPositions(db, ctx).Where("reorder_rules.is_below = ?", false).Where(belowReorderPoint).Scan(&items).Error
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		item.suggest()
+		if err := raiseLowStock(db, ctx, item); err != nil {
+			return err
+		}
+	}
+
+	// Items back at or above their point alert again the next time they drop
+	return db.WithContext(ctx).Exec(
+		"UPDATE reorder_rules LEFT JOIN stock_levels ON stock_levels.branch_id = reorder_rules.branch_id "+
+			"AND stock_levels.product_id = reorder_rules.product_id "+
+			"AND stock_levels.product_variation_id = reorder_rules.product_variation_id "+
+			"SET reorder_rules.is_below = ? WHERE reorder_rules.is_below = ? AND NOT ("+belowReorderPoint+")",
+		false, true,
+	).Error
+}
+
+func raiseLowStock(db *gorm.DB, ctx context.Context, item *LowStockItem) error {
+
+	name := item.ProductTitle
+	if item.VariantName != "" {
+		name += " (" + item.VariantName + ")"
+	}
+
+	tx := db.Begin()
+
+	result := tx.WithContext(ctx).Model(&ReorderRule{}).
+		Where("id = ? AND is_below = ?", item.ID, false).
+		Update("IsBelow", true)
+	if result.Error != nil {
+		tx.Rollback()
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		tx.Rollback()
+		return nil
+	}
+
+	err := createNotification(tx, ctx, &Notification{
+		BranchId: item.BranchId,
+		Type:     NotificationTypeLowStock,
+		Message: fmt.Sprintf("%s is below its reorder point (%g left, reorder point %g, suggested order %g)",
+			name, item.position(), item.ReorderPoint, item.SuggestedQuantity),
+		ReferenceType: "reorder_rule",
+		ReferenceId:   item.ID,
+	})
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
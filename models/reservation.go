@@ -0,0 +1,365 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/config"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Reservation holds stock of one item at a branch for a confirmed order. While active its quantity
+// counts as reserved and is not available to anyone else.
+type Reservation struct {
+	ID                 int        `gorm:"primary_key" json:"id"`
+	BranchId           int        `gorm:"index;not null" json:"branch_id"`
+	ProductId          int        `gorm:"index;not null" json:"product_id"`
+	ProductVariationId int        `gorm:"not null;default:0" json:"product_variation_id"`
+	Quantity           float64    `gorm:"type:decimal(14,3);not null" json:"quantity"`
+	OrderReference     string     `gorm:"index;size:100;not null" json:"order_reference"`
+	Status             string     `gorm:"index:idx_reservation_expiry;size:20;not null" json:"status"`
+	ExpiresAt          *time.Time `gorm:"index:idx_reservation_expiry" json:"expires_at"`
+	CreatedBy          int        `gorm:"not null;default:0" json:"created_by"`
+	ClosedBy           int        `gorm:"not null;default:0" json:"closed_by"`
+	ClosedAt           *time.Time `json:"closed_at"`
+	CreatedAt          time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt          time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+type NewReservation struct {
+	BranchId           int     `json:"branch_id" binding:"required"`
+	ProductId          int     `json:"product_id" binding:"required"`
+	ProductVariationId int     `json:"product_variation_id"`
+	UomId              int     `json:"uom_id"`
+	Quantity           float64 `json:"quantity" binding:"required"`
+	OrderReference     string  `json:"order_reference" binding:"required"`
+	// ExpiresInMinutes overrides the default hold time; 0 holds the stock until released
+	ExpiresInMinutes *int `json:"expires_in_minutes"`
+}
+
+const (
+	ReservationStatusActive    = "active"
+	ReservationStatusReleased  = "released"
+	ReservationStatusFulfilled = "fulfilled"
+	ReservationStatusExpired   = "expired"
+)
+
+const reservationReference = "reservation"
+
+var errReservationNotActive = errors.New("reservation is not active")
+
+// OutOfStockError is returned when a reservation asks for more than is available. Its extensions
+// let API clients tell the shortfall apart from other failures.
+type OutOfStockError struct {
+	BranchId           int
+	ProductId          int
+	ProductVariationId int
+	Requested          float64
+	Available          float64
+}
+
+func (e *OutOfStockError) Error() string {
+	return fmt.Sprintf("out of stock: %g requested, %g available", e.Requested, e.Available)
+}
+
+func (e *OutOfStockError) Unwrap() error {
+	return ErrInsufficientStock
+}
+
+func (e *OutOfStockError) Extensions() map[string]interface{} {
+	return map[string]interface{}{
+		"code":               "OUT_OF_STOCK",
+		"branchId":           e.BranchId,
+		"productId":          e.ProductId,
+		"productVariationId": e.ProductVariationId,
+		"requested":          e.Requested,
+		"available":          e.Available,
+	}
+}
+
+// CreateReservation moves quantity from available to reserved. Unless the product may be sold out
+// of stock, asking for more than is available fails with an OutOfStockError.
+func CreateReservation(ctx context.Context, scope *utils.BranchClaim, input *NewReservation, actorId int) (*Reservation, error) {
+
+	db := config.GetDB()
+
+	if scope == nil || !scope.CanAccessBranch(input.BranchId) {
+		return nil, errors.New("branch is not accessible")
+	}
+	reference := strings.TrimSpace(input.OrderReference)
+	if reference == "" {
+		return nil, errors.New("order reference is required")
+	}
+
+	item := StockItem{
+		BranchId:           input.BranchId,
+		ProductId:          input.ProductId,
+		ProductVariationId: input.ProductVariationId,
+	}
+	if _, err := validateStockItem(db, ctx, item); err != nil {
+		return nil, err
+	}
+	quantity, err := toBaseQuantity(db, ctx, input.ProductId, input.ProductVariationId, input.UomId, input.Quantity)
+	if err != nil {
+		return nil, err
+	}
+	quantity = roundQuantity(quantity)
+	if quantity <= 0 {
+		return nil, errors.New("reservation quantity must be positive")
+	}
+
+	lifespan := utils.ReservationLifespan()
+	if input.ExpiresInMinutes != nil {
+		if *input.ExpiresInMinutes < 0 {
+			return nil, errors.New("expiry cannot be negative")
+		}
+		lifespan = time.Minute * time.Duration(*input.ExpiresInMinutes)
+	}
+
+	reservation := Reservation{
+		BranchId:           input.BranchId,
+		ProductId:          input.ProductId,
+		ProductVariationId: input.ProductVariationId,
+		Quantity:           quantity,
+		OrderReference:     truncate(reference, 100),
+		Status:             ReservationStatusActive,
+		CreatedBy:          actorId,
+	}
+	if lifespan > 0 {
+		expiresAt := time.Now().Add(lifespan)
+		reservation.ExpiresAt = &expiresAt
+	}
+
+	tx := db.Begin()
+
+	if _, err := applyStockChange(tx, ctx, item, StockChange{Reserved: quantity}); err != nil {
+		if errors.Is(err, ErrInsufficientStock) {
+			err = outOfStock(tx, ctx, item, quantity)
+		}
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.WithContext(ctx).Create(&reservation).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return &reservation, nil
+}
+
+// outOfStock describes the shortfall of a refused reservation from the stock level it locked.
+func outOfStock(tx *gorm.DB, ctx context.Context, item StockItem, requested float64) error {
+
+	var level StockLevel
+	var product Product
+
+	err := tx.WithContext(ctx).
+		Where("branch_id = ? AND product_id = ? AND product_variation_id = ?", item.BranchId, item.ProductId, item.ProductVariationId).
+		Take(&level).Error
+	if err != nil {
+		return err
+	}
+
+	// Of a lot-tracked item, only what its sellable lots hold beyond the reservations is available
+	available := level.Available
+	if err := tx.WithContext(ctx).Select("id", "is_lot_tracked").First(&product, item.ProductId).Error; err != nil {
+		return err
+	}
+	if product.IsLotTracked {
+		sellable, err := sellableLotQuantity(tx, ctx, item)
+		if err != nil {
+			return err
+		}
+		if free := roundQuantity(sellable - level.Reserved); free < available {
+			available = free
+		}
+	}
+
+	return &OutOfStockError{
+		BranchId:           item.BranchId,
+		ProductId:          item.ProductId,
+		ProductVariationId: item.ProductVariationId,
+		Requested:          requested,
+		Available:          available,
+	}
+}
+
+// ReleaseReservation gives the held quantity back to available, as when an order is cancelled.
+func ReleaseReservation(ctx context.Context, scope *utils.BranchClaim, id int, actorId int) (*Reservation, error) {
+	return closeReservation(ctx, scope, id, ReservationStatusReleased, actorId)
+}
+
+// FulfilReservation issues the held quantity as a sale when the order is dispatched.
+func FulfilReservation(ctx context.Context, scope *utils.BranchClaim, id int, actorId int) (*Reservation, error) {
+	return closeReservation(ctx, scope, id, ReservationStatusFulfilled, actorId)
+}
+
+func closeReservation(ctx context.Context, scope *utils.BranchClaim, id int, status string, actorId int) (*Reservation, error) {
+
+	db := config.GetDB()
+
+	tx := db.Begin()
+
+	reservation, err := lockReservation(tx, ctx, scope, id)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if reservation.Status != ReservationStatusActive {
+		tx.Rollback()
+		return nil, errReservationNotActive
+	}
+
+	if err := finishReservation(tx, ctx, reservation, status, actorId); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return reservation, nil
+}
+
+// finishReservation takes a locked active reservation out of reserved, issuing it as a sale when
+// it is fulfilled.
+func finishReservation(tx *gorm.DB, ctx context.Context, reservation *Reservation, status string, actorId int) error {
+
+	change := StockChange{Reserved: -reservation.Quantity}
+	if status == ReservationStatusFulfilled {
+		change.OnHand = -reservation.Quantity
+		change.Reason = StockReasonSale
+		change.ReferenceType = reservationReference
+		change.ReferenceId = reservation.ID
+		change.ActorId = actorId
+		change.Note = reservation.OrderReference
+	}
+	if _, err := applyStockChange(tx, ctx, reservation.stockItem(), change); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	reservation.Status = status
+	reservation.ClosedBy = actorId
+	reservation.ClosedAt = &now
+	return tx.WithContext(ctx).Model(reservation).Updates(map[string]interface{}{
+		"Status":   reservation.Status,
+		"ClosedBy": reservation.ClosedBy,
+		"ClosedAt": reservation.ClosedAt,
+	}).Error
+}
+
+// ExpireReservations releases active reservations whose hold has run out. Each one is released in
+// its own transaction so one failure does not keep the rest reserved.
+func ExpireReservations(ctx context.Context) error {
+
+	db := config.GetDB()
+	var ids []int
+
+	err := db.WithContext(ctx).Model(&Reservation{}).
+		Where("status = ? AND expires_at <= ?", ReservationStatusActive, time.Now()).
+		Order("id").
+		Limit(500).
+		Pluck("id", &ids).Error
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if err := expireReservation(ctx, db, id); err != nil {
+			log.Printf("expire reservation %d: %v", id, err)
+		}
+	}
+	return nil
+}
+
+func expireReservation(ctx context.Context, db *gorm.DB, id int) error {
+
+	tx := db.Begin()
+
+	reservation, err := lockReservation(tx, ctx, nil, id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	// It may have been released or fulfilled since it was picked
+	if reservation.Status != ReservationStatusActive || reservation.ExpiresAt == nil || reservation.ExpiresAt.After(time.Now()) {
+		tx.Rollback()
+		return nil
+	}
+
+	if err := finishReservation(tx, ctx, reservation, ReservationStatusExpired, 0); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// lockReservation reads a reservation FOR UPDATE, limited to scope unless scope is nil, which only
+// the sweeper passes.
+func lockReservation(tx *gorm.DB, ctx context.Context, scope *utils.BranchClaim, id int) (*Reservation, error) {
+
+	var reservation Reservation
+
+	dbCtx := tx.WithContext(ctx).Clauses(clause.Locking{Strength: "UPDATE"})
+	if scope != nil {
+		dbCtx = dbCtx.Scopes(ScopeBranches(scope, "branch_id"))
+	}
+	if err := dbCtx.First(&reservation, id).Error; err != nil {
+		return nil, utils.ErrorRecordNotFound
+	}
+	return &reservation, nil
+}
+
+func (reservation *Reservation) stockItem() StockItem {
+	return StockItem{
+		BranchId:           reservation.BranchId,
+		ProductId:          reservation.ProductId,
+		ProductVariationId: reservation.ProductVariationId,
+	}
+}
+
+func GetReservation(ctx context.Context, scope *utils.BranchClaim, id int) (*Reservation, error) {
+
+	db := config.GetDB()
+	var reservation Reservation
+
+	err := db.WithContext(ctx).Scopes(ScopeBranches(scope, "branch_id")).First(&reservation, id).Error
+	if err != nil {
+		return nil, utils.ErrorRecordNotFound
+	}
+	return &reservation, nil
+}
+
+func GetReservations(ctx context.Context, scope *utils.BranchClaim, branchId *int, status *string, orderReference *string) ([]*Reservation, error) {
+
+	db := config.GetDB()
+	var results []*Reservation
+
+	dbCtx := db.WithContext(ctx).Scopes(ScopeBranches(scope, "branch_id"))
+	if branchId != nil {
+		dbCtx = dbCtx.Where("branch_id = ?", *branchId)
+	}
+	if status != nil && len(*status) > 0 {
+		dbCtx = dbCtx.Where("status = ?", *status)
+	}
+	if orderReference != nil && len(*orderReference) > 0 {
+		dbCtx = dbCtx.Where("order_reference = ?", *orderReference)
+	}
+
+	err := dbCtx.Order("created_at desc").Limit(200).Find(&results).Error
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
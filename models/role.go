@@ -11,17 +11,22 @@ import (
 
 
 type Role struct {
-	ID         int       `gorm:"primary_key" json:"id"`
-	Name       string    `gorm:"index;size:100;not null" json:"name" binding:"required"`
-	CreatedAt  time.Time `gorm:"autoCreateTime" json:"created_at"`
-	UpdatedAt  time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+	ID   int    `gorm:"primary_key" json:"id"`
+	Name string `gorm:"index;size:100;not null" json:"name" binding:"required"`
+	// Stock adjustments worth more than this need approval
+	AdjustmentApprovalLimit float64   `gorm:"type:decimal(14,2);not null;default:0" json:"adjustment_approval_limit"`
+	CreatedAt               time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt               time.Time `gorm:"autoUpdateTime" json:"updated_at"`
 }
 
 type NewRole struct {
-	Name       string `json:"name" binding:"required"`
+	Name                    string           `json:"name" binding:"required"`
+	Permissions             []*NewRoleModule `json:"permissions"`
+	AdjustmentApprovalLimit *float64         `json:"adjustment_approval_limit"`
 }
 
-func CreateRole(ctx context.Context, input *NewRole) (*Role, error) {
+// CreateRole adds a role whose permissions and approval limit are within the actor's own.
+func CreateRole(ctx context.Context, input *NewRole, actorId int, roleId int) (*Role, error) {
 
 	db := config.GetDB()
 	var count int64
@@ -34,29 +39,62 @@ func CreateRole(ctx context.Context, input *NewRole) (*Role, error) {
 		return nil, errors.New("duplicate name")
 	}
 
+	if err := validatePermissions(input.Permissions); err != nil {
+		return nil, err
+	}
+
+	if input.AdjustmentApprovalLimit != nil && *input.AdjustmentApprovalLimit < 0 {
+		return nil, errors.New("adjustment approval limit cannot be negative")
+	}
+
+	ceiling, err := actorCeiling(db, ctx, actorId, roleId)
+	if err != nil {
+		return nil, err
+	}
+	if err := ceiling.checkPermissions(db, ctx, input.Permissions); err != nil {
+		return nil, err
+	}
+	if err := ceiling.checkApprovalLimit(input.AdjustmentApprovalLimit); err != nil {
+		return nil, err
+	}
+
 	role := Role{
 		Name:       input.Name,
 	}
+	if input.AdjustmentApprovalLimit != nil {
+		role.AdjustmentApprovalLimit = *input.AdjustmentApprovalLimit
+	}
 
-	err = db.WithContext(ctx).Create(&role).Error
+	tx := db.Begin()
+
+	err = tx.WithContext(ctx).Create(&role).Error
 	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := grantPermissions(tx, ctx, role.ID, input.Permissions); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
 		return nil, err
 	}
 	return &role, nil
 }
 
-func UpdateRole(ctx context.Context, id int, input *NewRole) (*Role, error) {
+// UpdateRole edits a role other than the actor's own. Like CreateRole, it cannot give the role
+// more than the actor holds, nor edit a role that already has more.
+func UpdateRole(ctx context.Context, id int, input *NewRole, actorId int, roleId int) (*Role, error) {
 
 	db := config.GetDB()
 	var count int64
 
-	err := db.WithContext(ctx).Model(&Role{}).Where("id = ?", id).Count(&count).Error
+	_, ceiling, err := editableRole(db, ctx, id, actorId, roleId)
 	if err != nil {
 		return nil, err
 	}
-	if count <= 0 {
-		return nil, utils.ErrorRecordNotFound
-	}
 
 	if err = db.WithContext(ctx).Model(&Role{}).
 		Where("name = ?", input.Name).
@@ -68,41 +106,93 @@ func UpdateRole(ctx context.Context, id int, input *NewRole) (*Role, error) {
 		return nil, errors.New("duplicate name")
 	}
 
+	if err := validatePermissions(input.Permissions); err != nil {
+		return nil, err
+	}
+
+	if input.AdjustmentApprovalLimit != nil && *input.AdjustmentApprovalLimit < 0 {
+		return nil, errors.New("adjustment approval limit cannot be negative")
+	}
+
+	if err := ceiling.checkPermissions(db, ctx, input.Permissions); err != nil {
+		return nil, err
+	}
+	if err := ceiling.checkApprovalLimit(input.AdjustmentApprovalLimit); err != nil {
+		return nil, err
+	}
+
 	role := Role{
 		ID:         id,
 		Name:       input.Name,
 	}
 
-	err = db.WithContext(ctx).Model(&role).Updates(map[string]interface{}{
+	updates := map[string]interface{}{
 		"Name":       input.Name,
-	}).Error
+	}
+	// Keep the current limit when the caller did not send one
+	if input.AdjustmentApprovalLimit != nil {
+		updates["AdjustmentApprovalLimit"] = *input.AdjustmentApprovalLimit
+	}
+
+	tx := db.Begin()
+
+	err = tx.WithContext(ctx).Model(&role).Updates(updates).Error
 	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	// Replace permissions only when the caller sent them
+	if input.Permissions != nil {
+		if err := tx.WithContext(ctx).Where("role_id = ?", id).Delete(&RoleModule{}).Error; err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		if err := grantPermissions(tx, ctx, id, input.Permissions); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	// Reload so fields the caller left out are returned as stored
+	if err := db.WithContext(ctx).First(&role, id).Error; err != nil {
 		return nil, err
 	}
 	return &role, nil
 }
 
-func DeleteRole(ctx context.Context, id int) (*Role, error) {
+func DeleteRole(ctx context.Context, id int, actorId int, roleId int) (*Role, error) {
 
 	db := config.GetDB()
-	var result Role
 
-	err := db.WithContext(ctx).First(&result, id).Error
+	result, _, err := editableRole(db, ctx, id, actorId, roleId)
 	if err != nil {
-		return nil, utils.ErrorRecordNotFound
+		return nil, err
 	}
 
+	tx := db.Begin()
+
 	// Delete role module if any
-	// err = db.WithContext(ctx).Where("role_id = ?", id).Delete(&RoleModule{}).Error
-	// if err != nil {
-	// 	return nil, err
-	// }
+	err = tx.WithContext(ctx).Where("role_id = ?", id).Delete(&RoleModule{}).Error
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
 
-	err = db.WithContext(ctx).Delete(&result).Error
+	err = tx.WithContext(ctx).Delete(result).Error
 	if err != nil {
+		tx.Rollback()
 		return nil, err
 	}
-	return &result, nil
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return result, nil
 }
 
 func GetRole(ctx context.Context, id int) (*Role, error) {
@@ -0,0 +1,396 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/config"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/utils"
+	"gorm.io/gorm"
+)
+
+type RoleModule struct {
+	ID        int       `gorm:"primary_key" json:"id"`
+	RoleId    int       `gorm:"not null;uniqueIndex:idx_role_module_action" json:"role_id"`
+	Module    string    `gorm:"size:100;not null;uniqueIndex:idx_role_module_action" json:"module" binding:"required"`
+	Action    string    `gorm:"size:50;not null;uniqueIndex:idx_role_module_action" json:"action" binding:"required"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+type NewRoleModule struct {
+	Module string `json:"module" binding:"required"`
+	Action string `json:"action" binding:"required"`
+}
+
+type PermissionModule struct {
+	Module  string   `json:"module"`
+	Actions []string `json:"actions"`
+}
+
+// PermissionModules lists every module/action pair a role can be granted.
+var PermissionModules = []*PermissionModule{
+	{Module: "branch", Actions: []string{"create", "update", "delete", "access_all"}},
+	{Module: "role", Actions: []string{"read", "create", "update", "delete"}},
+	{Module: "category", Actions: []string{"create", "update", "delete"}},
+	{Module: "supplier", Actions: []string{"read", "create", "update", "delete"}},
+	{Module: "user", Actions: []string{"read", "create", "update", "delete"}},
+	{Module: "product", Actions: []string{"create", "update", "delete"}},
+	{Module: "image", Actions: []string{"upload"}},
+	{Module: "api_key", Actions: []string{"read", "create", "delete"}},
+	{Module: "audit_log", Actions: []string{"read"}},
+	{Module: "transfer", Actions: []string{"read", "create", "dispatch", "receive"}},
+	{Module: "stock_adjustment", Actions: []string{"read", "create", "approve", "configure"}},
+	{Module: "stock_count", Actions: []string{"read", "create", "count", "post"}},
+	{Module: "reorder_rule", Actions: []string{"read", "update"}},
+	{Module: "stock_lot", Actions: []string{"read", "quarantine"}},
+	{Module: "unit_of_measure", Actions: []string{"create", "update", "delete"}},
+	{Module: "inventory_valuation", Actions: []string{"read"}},
+	{Module: "reservation", Actions: []string{"read", "create", "release", "fulfil"}},
+	{Module: "purchase_order", Actions: []string{"read", "create", "update", "delete", "approve", "close"}},
+	{Module: "goods_receipt", Actions: []string{"read", "create"}},
+}
+
+func GetPermissionModules() []*PermissionModule {
+	return PermissionModules
+}
+
+// AllPermissions expands PermissionModules into one entry per module/action pair.
+func AllPermissions() []*NewRoleModule {
+	var results []*NewRoleModule
+	for _, m := range PermissionModules {
+		for _, action := range m.Actions {
+			results = append(results, &NewRoleModule{Module: m.Module, Action: action})
+		}
+	}
+	return results
+}
+
+func isValidPermission(module string, action string) bool {
+	for _, m := range PermissionModules {
+		if m.Module != module {
+			continue
+		}
+		for _, a := range m.Actions {
+			if a == action {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func validatePermissions(input []*NewRoleModule) error {
+	for _, p := range input {
+		if !isValidPermission(p.Module, p.Action) {
+			return errors.New("invalid permission " + p.Module + ":" + p.Action)
+		}
+	}
+	return nil
+}
+
+// backfillPermission grants module:action to every role that holds another action of the module, so
+// an action added later does not take away access those roles already had.
+func backfillPermission(db *gorm.DB, module string, action string) error {
+
+	var roleIds []int
+
+	err := db.Model(&RoleModule{}).
+		Distinct("role_id").
+		Where("module = ? AND action <> ?", module, action).
+		Where("role_id NOT IN (?)", db.Model(&RoleModule{}).Select("role_id").Where("module = ? AND action = ?", module, action)).
+		Pluck("role_id", &roleIds).Error
+	if err != nil {
+		return err
+	}
+
+	for _, roleId := range roleIds {
+		roleModule := RoleModule{
+			RoleId: roleId,
+			Module: module,
+			Action: action,
+		}
+		if err := db.Create(&roleModule).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HasPermission reports whether the role of the given user grants module/action.
+func HasPermission(ctx context.Context, userId int, module string, action string) (bool, error) {
+
+	db := config.GetDB()
+	var count int64
+
+	err := db.WithContext(ctx).Model(&RoleModule{}).
+		Joins("JOIN users ON users.role_id = role_modules.role_id").
+		Where("users.id = ? AND role_modules.module = ? AND role_modules.action = ?", userId, module, action).
+		Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// RoleHasPermission reports whether the role grants module/action.
+func RoleHasPermission(ctx context.Context, roleId int, module string, action string) (bool, error) {
+
+	db := config.GetDB()
+	var count int64
+
+	err := db.WithContext(ctx).Model(&RoleModule{}).
+		Where("role_id = ? AND module = ? AND action = ?", roleId, module, action).
+		Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// actorRoleId is the role the actor works with: the role an API key carries, or else the user's.
+func actorRoleId(db *gorm.DB, ctx context.Context, actorId int, roleId int) (int, error) {
+
+	var user User
+
+	if roleId > 0 {
+		return roleId, nil
+	}
+	if err := db.WithContext(ctx).Select("id", "role_id").First(&user, actorId).Error; err != nil {
+		return 0, err
+	}
+	return user.RoleId, nil
+}
+
+var errRoleExceedsActor = errors.New("role has permissions you do not have")
+
+// roleCeiling is the most access an actor can hand out: the permissions and approval limit of its
+// own role. Every path that assigns a role or a permission checks against it, so nobody can hand
+// out, or take over, more than they hold themselves.
+type roleCeiling struct {
+	RoleId        int
+	ApprovalLimit float64
+}
+
+// actorCeiling loads the ceiling of the actor's role; an actor without a role can hand out nothing.
+func actorCeiling(db *gorm.DB, ctx context.Context, actorId int, roleId int) (*roleCeiling, error) {
+
+	var role Role
+
+	roleId, err := actorRoleId(db, ctx, actorId, roleId)
+	if err != nil {
+		return nil, err
+	}
+	if roleId == 0 {
+		return &roleCeiling{}, nil
+	}
+
+	if err := db.WithContext(ctx).Select("id", "adjustment_approval_limit").First(&role, roleId).Error; err != nil {
+		return nil, err
+	}
+	return &roleCeiling{RoleId: role.ID, ApprovalLimit: role.AdjustmentApprovalLimit}, nil
+}
+
+// checkRole refuses roleId unless the ceiling grants every permission it has.
+func (c *roleCeiling) checkRole(db *gorm.DB, ctx context.Context, roleId int) error {
+
+	var count int64
+
+	if roleId == c.RoleId {
+		return nil
+	}
+
+	err := db.WithContext(ctx).Model(&RoleModule{}).
+		Where("role_id = ?", roleId).
+		Where("NOT EXISTS (SELECT 1 FROM role_modules AS own "+
+			"WHERE own.role_id = ? AND own.module = role_modules.module AND own.action = role_modules.action)", c.RoleId).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return errRoleExceedsActor
+	}
+	return nil
+}
+
+// checkPermissions refuses any permission the ceiling does not grant.
+func (c *roleCeiling) checkPermissions(db *gorm.DB, ctx context.Context, input []*NewRoleModule) error {
+
+	var own []*RoleModule
+
+	if len(input) == 0 {
+		return nil
+	}
+
+	err := db.WithContext(ctx).Select("module", "action").Where("role_id = ?", c.RoleId).Find(&own).Error
+	if err != nil {
+		return err
+	}
+
+	granted := make(map[string]bool, len(own))
+	for _, p := range own {
+		granted[p.Module+":"+p.Action] = true
+	}
+	for _, p := range input {
+		if !granted[p.Module+":"+p.Action] {
+			return errors.New("you do not have permission " + p.Module + ":" + p.Action)
+		}
+	}
+	return nil
+}
+
+// checkApprovalLimit refuses an adjustment approval limit above the ceiling's own.
+func (c *roleCeiling) checkApprovalLimit(limit *float64) error {
+	if limit != nil && *limit > c.ApprovalLimit {
+		return errors.New("adjustment approval limit is above your own")
+	}
+	return nil
+}
+
+// checkRoleEdit refuses changes to the actor's own role, and to a role with access the actor lacks.
+func (c *roleCeiling) checkRoleEdit(db *gorm.DB, ctx context.Context, roleId int) error {
+	if roleId == c.RoleId {
+		return errors.New("you cannot change your own role")
+	}
+	return c.checkRole(db, ctx, roleId)
+}
+
+// editableRole loads role id for an edit by the actor, along with the actor's ceiling.
+func editableRole(db *gorm.DB, ctx context.Context, id int, actorId int, roleId int) (*Role, *roleCeiling, error) {
+
+	var role Role
+
+	if err := db.WithContext(ctx).First(&role, id).Error; err != nil {
+		return nil, nil, utils.ErrorRecordNotFound
+	}
+
+	ceiling, err := actorCeiling(db, ctx, actorId, roleId)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := ceiling.checkRoleEdit(db, ctx, id); err != nil {
+		return nil, nil, err
+	}
+	return &role, ceiling, nil
+}
+
+// SetRolePermissions replaces the role's permissions, which may not go beyond the actor's own.
+func SetRolePermissions(ctx context.Context, id int, input []*NewRoleModule, actorId int, roleId int) (*Role, error) {
+
+	db := config.GetDB()
+
+	if err := validatePermissions(input); err != nil {
+		return nil, err
+	}
+
+	role, ceiling, err := editableRole(db, ctx, id, actorId, roleId)
+	if err != nil {
+		return nil, err
+	}
+	if err := ceiling.checkPermissions(db, ctx, input); err != nil {
+		return nil, err
+	}
+
+	tx := db.Begin()
+
+	if err := tx.WithContext(ctx).Where("role_id = ?", id).Delete(&RoleModule{}).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := grantPermissions(tx, ctx, id, input); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return role, nil
+}
+
+// GrantRolePermissions adds permissions to the role, which may not go beyond the actor's own.
+func GrantRolePermissions(ctx context.Context, id int, input []*NewRoleModule, actorId int, roleId int) (*Role, error) {
+
+	db := config.GetDB()
+
+	if err := validatePermissions(input); err != nil {
+		return nil, err
+	}
+
+	role, ceiling, err := editableRole(db, ctx, id, actorId, roleId)
+	if err != nil {
+		return nil, err
+	}
+	if err := ceiling.checkPermissions(db, ctx, input); err != nil {
+		return nil, err
+	}
+
+	tx := db.Begin()
+
+	if err := grantPermissions(tx, ctx, id, input); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return role, nil
+}
+
+func RevokeRolePermissions(ctx context.Context, id int, input []*NewRoleModule, actorId int, roleId int) (*Role, error) {
+
+	db := config.GetDB()
+
+	role, _, err := editableRole(db, ctx, id, actorId, roleId)
+	if err != nil {
+		return nil, err
+	}
+
+	tx := db.Begin()
+
+	for _, p := range input {
+		err := tx.WithContext(ctx).
+			Where("role_id = ? AND module = ? AND action = ?", id, p.Module, p.Action).
+			Delete(&RoleModule{}).Error
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return role, nil
+}
+
+func grantPermissions(tx *gorm.DB, ctx context.Context, roleId int, input []*NewRoleModule) error {
+
+	for _, p := range input {
+		var count int64
+
+		err := tx.WithContext(ctx).Model(&RoleModule{}).
+			Where("role_id = ? AND module = ? AND action = ?", roleId, p.Module, p.Action).
+			Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+
+		roleModule := RoleModule{
+			RoleId: roleId,
+			Module: p.Module,
+			Action: p.Action,
+		}
+		if err := tx.WithContext(ctx).Create(&roleModule).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
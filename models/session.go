@@ -0,0 +1,232 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/config"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/utils"
+	"gorm.io/gorm"
+)
+
+type Session struct {
+	ID               int        `gorm:"primary_key" json:"id"`
+	UserId           int        `gorm:"index;not null" json:"user_id"`
+	BranchId         int        `gorm:"not null;default:0" json:"branch_id"`
+	RefreshTokenHash string     `gorm:"size:64;not null;uniqueIndex" json:"-"`
+	ClientIp         string     `gorm:"size:45" json:"client_ip"`
+	UserAgent        string     `gorm:"size:255" json:"user_agent"`
+	ExpiresAt        time.Time  `gorm:"not null" json:"expires_at"`
+	LastUsedAt       time.Time  `json:"last_used_at"`
+	RevokedAt        *time.Time `gorm:"index" json:"revoked_at"`
+	CreatedAt        time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt        time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+type ClientInfo struct {
+	ClientIp  string
+	UserAgent string
+}
+
+var errInvalidRefreshToken = errors.New("invalid or expired refresh token")
+
+// createSession opens a new session for the user and fills the tokens of result.
+func createSession(tx *gorm.DB, ctx context.Context, user *User, client *ClientInfo, result *LoginInfo) error {
+
+	refreshToken, err := utils.GenerateRandomToken()
+	if err != nil {
+		return err
+	}
+
+	lifespan, err := utils.RefreshTokenLifespan()
+	if err != nil {
+		return err
+	}
+
+	branch, err := userBranchClaim(tx, ctx, user.ID, 0)
+	if err != nil {
+		return err
+	}
+
+	session := Session{
+		UserId:           user.ID,
+		BranchId:         branch.BranchId,
+		RefreshTokenHash: utils.HashToken(refreshToken),
+		ExpiresAt:        time.Now().Add(lifespan),
+		LastUsedAt:       time.Now(),
+	}
+	if client != nil {
+		session.ClientIp = client.ClientIp
+		session.UserAgent = truncate(client.UserAgent, 255)
+	}
+
+	if err := tx.WithContext(ctx).Create(&session).Error; err != nil {
+		return err
+	}
+
+	return fillLoginInfo(result, user, &session, branch, refreshToken)
+}
+
+// RefreshToken exchanges a refresh token for a new access token, rotating the refresh token.
+func RefreshToken(ctx context.Context, refreshToken string) (*LoginInfo, error) {
+
+	db := config.GetDB()
+	var session Session
+	var user User
+
+	err := db.WithContext(ctx).
+		Where("refresh_token_hash = ? AND revoked_at IS NULL AND expires_at > ?", utils.HashToken(refreshToken), time.Now()).
+		First(&session).Error
+	if err != nil {
+		return nil, errInvalidRefreshToken
+	}
+
+	err = db.WithContext(ctx).First(&user, session.UserId).Error
+	if err != nil || user.IsActive == nil || !*user.IsActive {
+		return nil, errInvalidRefreshToken
+	}
+
+	return rotateSession(ctx, &session, &user, session.BranchId)
+}
+
+// SwitchBranch moves the caller's session to another branch and issues tokens carrying it.
+func SwitchBranch(ctx context.Context, userId int, sessionId int, branchId int) (*LoginInfo, error) {
+
+	db := config.GetDB()
+	var session Session
+	var user User
+
+	err := db.WithContext(ctx).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL AND expires_at > ?", sessionId, userId, time.Now()).
+		First(&session).Error
+	if err != nil {
+		return nil, errors.New("session is no longer active")
+	}
+
+	err = db.WithContext(ctx).First(&user, userId).Error
+	if err != nil {
+		return nil, utils.ErrorRecordNotFound
+	}
+
+	if !utils.IsRecordValidByID(branchId, &Branch{}, db) {
+		return nil, errors.New("invalid branch id")
+	}
+
+	branch, err := userBranchClaim(db, ctx, userId, branchId)
+	if err != nil {
+		return nil, err
+	}
+	if branch.BranchId != branchId {
+		return nil, errors.New("branch is not assigned to user")
+	}
+
+	return rotateSession(ctx, &session, &user, branchId)
+}
+
+// rotateSession replaces the session's refresh token and signs a new access token for it.
+func rotateSession(ctx context.Context, session *Session, user *User, activeBranchId int) (*LoginInfo, error) {
+
+	db := config.GetDB()
+	var result LoginInfo
+
+	branch, err := userBranchClaim(db, ctx, user.ID, activeBranchId)
+	if err != nil {
+		return nil, err
+	}
+
+	newRefreshToken, err := utils.GenerateRandomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	// Only rotate when the stored hash is still the one presented, so a refresh token cannot be used twice
+	tx := db.WithContext(ctx).Model(&Session{}).
+		Where("id = ? AND refresh_token_hash = ?", session.ID, session.RefreshTokenHash).
+		Updates(map[string]interface{}{
+			"RefreshTokenHash": utils.HashToken(newRefreshToken),
+			"BranchId":         branch.BranchId,
+			"LastUsedAt":       time.Now(),
+		})
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return nil, errInvalidRefreshToken
+	}
+
+	if err := fillLoginInfo(&result, user, session, branch, newRefreshToken); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func fillLoginInfo(result *LoginInfo, user *User, session *Session, branch utils.BranchClaim, refreshToken string) error {
+
+	token, err := utils.JwtGenerate(user.ID, session.ID, branch)
+	if err != nil {
+		return err
+	}
+
+	result.Token = token
+	result.RefreshToken = refreshToken
+	result.UserId = user.ID
+	result.Username = user.Username
+	result.Name = user.Name
+	result.BranchId = branch.BranchId
+	return nil
+}
+
+func Logout(ctx context.Context, sessionId int) (bool, error) {
+
+	db := config.GetDB()
+
+	err := db.WithContext(ctx).Model(&Session{}).
+		Where("id = ? AND revoked_at IS NULL", sessionId).
+		Update("RevokedAt", time.Now()).Error
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func LogoutAllSessions(ctx context.Context, userId int) (bool, error) {
+
+	db := config.GetDB()
+
+	if err := revokeUserSessions(db, ctx, userId); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func revokeUserSessions(tx *gorm.DB, ctx context.Context, userId int) error {
+	return tx.WithContext(ctx).Model(&Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userId).
+		Update("RevokedAt", time.Now()).Error
+}
+
+// IsSessionActive reports whether the session behind an access token may still be used.
+func IsSessionActive(ctx context.Context, sessionId int, userId int) (bool, error) {
+
+	db := config.GetDB()
+	var count int64
+
+	err := db.WithContext(ctx).Model(&Session{}).
+		Joins("JOIN users ON users.id = sessions.user_id").
+		Where("sessions.id = ? AND sessions.user_id = ?", sessionId, userId).
+		Where("sessions.revoked_at IS NULL AND sessions.expires_at > ?", time.Now()).
+		Where("users.is_active = ?", true).
+		Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func truncate(s string, max int) string {
+	if len(s) > max {
+		return s[:max]
+	}
+	return s
+}
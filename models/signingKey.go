@@ -0,0 +1,104 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/config"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/utils"
+)
+
+type SigningKey struct {
+	ID         int        `gorm:"primary_key" json:"id"`
+	Kid        string     `gorm:"size:64;not null;uniqueIndex" json:"kid"`
+	Algorithm  string     `gorm:"size:16;not null" json:"algorithm"`
+	PrivateKey string     `gorm:"type:text;not null" json:"-"`
+	IsActive   bool       `gorm:"index;not null;default:false" json:"is_active"`
+	RetiredAt  *time.Time `gorm:"index" json:"retired_at"`
+	CreatedAt  time.Time  `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// LoadSigningKeys returns the active key and every retired key that may still verify an unexpired token.
+func LoadSigningKeys(ctx context.Context) ([]utils.KeyMaterial, error) {
+
+	db := config.GetDB()
+	var keys []SigningKey
+
+	retention, err := utils.MaxTokenLifespan()
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.WithContext(ctx).
+		Where("is_active = ? OR retired_at > ?", true, time.Now().Add(-retention)).
+		Order("created_at").
+		Find(&keys).Error
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]utils.KeyMaterial, 0, len(keys))
+	for _, key := range keys {
+		results = append(results, utils.KeyMaterial{
+			Kid:        key.Kid,
+			Algorithm:  key.Algorithm,
+			PrivateKey: key.PrivateKey,
+			IsActive:   key.IsActive,
+		})
+	}
+	return results, nil
+}
+
+// LoadKeyring installs the stored signing keys into the token keyring.
+func LoadKeyring(ctx context.Context) error {
+	keys, err := LoadSigningKeys(ctx)
+	if err != nil {
+		return err
+	}
+	return utils.SetKeyring(keys)
+}
+
+// RotateSigningKey makes a new key the one tokens are signed with; the previous key keeps
+// verifying tokens until they have all expired.
+func RotateSigningKey(ctx context.Context, algorithm string) (*SigningKey, error) {
+
+	db := config.GetDB()
+
+	material, err := utils.GenerateKeyMaterial(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	tx := db.Begin()
+
+	err = tx.WithContext(ctx).Model(&SigningKey{}).
+		Where("is_active = ?", true).
+		Updates(map[string]interface{}{
+			"IsActive":  false,
+			"RetiredAt": time.Now(),
+		}).Error
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	key := SigningKey{
+		Kid:        material.Kid,
+		Algorithm:  material.Algorithm,
+		PrivateKey: material.PrivateKey,
+		IsActive:   true,
+	}
+	if err := tx.WithContext(ctx).Create(&key).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	if err := LoadKeyring(ctx); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
@@ -0,0 +1,451 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/config"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// AdjustmentReason is a configurable reason code such as breakage, theft or miscount. StockReason
+// decides how adjustments using it are recorded in the stock ledger.
+type AdjustmentReason struct {
+	ID          int       `gorm:"primary_key" json:"id"`
+	Code        string    `gorm:"size:50;not null;uniqueIndex" json:"code"`
+	Name        string    `gorm:"size:100;not null" json:"name"`
+	StockReason string    `gorm:"size:20;not null" json:"stock_reason"`
+	IsActive    bool      `gorm:"not null;default:true" json:"is_active"`
+	CreatedAt   time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt   time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+type NewAdjustmentReason struct {
+	Code        string `json:"code" binding:"required"`
+	Name        string `json:"name" binding:"required"`
+	StockReason string `json:"stock_reason"`
+	IsActive    *bool  `json:"is_active"`
+}
+
+// StockAdjustment is a signed correction to the on-hand quantity of one item. Value is the absolute
+// quantity at the product cost and decides whether the adjustment needs approval before it posts.
+type StockAdjustment struct {
+	ID                 int        `gorm:"primary_key" json:"id"`
+	BranchId           int        `gorm:"index;not null" json:"branch_id"`
+	ProductId          int        `gorm:"index;not null" json:"product_id"`
+	ProductVariationId int        `gorm:"not null;default:0" json:"product_variation_id"`
+	LotId              int        `gorm:"not null;default:0" json:"lot_id"`
+	Quantity           float64    `gorm:"type:decimal(14,3);not null" json:"quantity"`
+	UomId              int        `gorm:"not null;default:0" json:"uom_id"`
+	UomQuantity        float64    `gorm:"type:decimal(14,3);not null;default:0" json:"uom_quantity"`
+	ReasonId           int        `gorm:"index;not null" json:"reason_id"`
+	Note               string     `gorm:"size:255" json:"note"`
+	UnitCost           float64    `gorm:"type:decimal(10,2);not null;default:0" json:"unit_cost"`
+	Value              float64    `gorm:"type:decimal(14,2);not null;default:0" json:"value"`
+	Status             string     `gorm:"index;size:20;not null" json:"status"`
+	CreatedBy          int        `gorm:"not null;default:0" json:"created_by"`
+	ReviewedBy         int        `gorm:"not null;default:0" json:"reviewed_by"`
+	ReviewedAt         *time.Time `json:"reviewed_at"`
+	ReviewNote         string     `gorm:"size:255" json:"review_note"`
+	StockCountId       int        `gorm:"index;not null;default:0" json:"stock_count_id"`
+	CreatedAt          time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt          time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+type NewStockAdjustment struct {
+	BranchId           int     `json:"branch_id" binding:"required"`
+	ProductId          int     `json:"product_id" binding:"required"`
+	ProductVariationId int     `json:"product_variation_id"`
+	LotId              int     `json:"lot_id"`
+	UomId              int     `json:"uom_id"`
+	Quantity           float64 `json:"quantity" binding:"required"`
+	ReasonId           int     `json:"reason_id" binding:"required"`
+	Note               string  `json:"note"`
+}
+
+const (
+	StockAdjustmentStatusPending  = "pending"
+	StockAdjustmentStatusApproved = "approved"
+	StockAdjustmentStatusRejected = "rejected"
+)
+
+const stockAdjustmentReference = "stock_adjustment"
+
+var errAdjustmentNotPending = errors.New("only pending adjustments can be reviewed")
+
+func validateAdjustmentReasonInput(input *NewAdjustmentReason) error {
+	if strings.TrimSpace(input.Code) == "" || strings.TrimSpace(input.Name) == "" {
+		return errors.New("code and name are required")
+	}
+	// Adjustments only ever correct or write off stock
+	if input.StockReason != "" && input.StockReason != StockReasonAdjustment && input.StockReason != StockReasonWaste {
+		return errors.New("stock reason must be adjustment or waste")
+	}
+	return nil
+}
+
+func CreateAdjustmentReason(ctx context.Context, input *NewAdjustmentReason) (*AdjustmentReason, error) {
+
+	db := config.GetDB()
+	var count int64
+
+	if err := validateAdjustmentReasonInput(input); err != nil {
+		return nil, err
+	}
+
+	code := strings.ToLower(strings.TrimSpace(input.Code))
+	err := db.WithContext(ctx).Model(&AdjustmentReason{}).Where("code = ?", code).Count(&count).Error
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, errors.New("duplicate code")
+	}
+
+	reason := AdjustmentReason{
+		Code:        code,
+		Name:        strings.TrimSpace(input.Name),
+		StockReason: input.StockReason,
+		IsActive:    input.IsActive == nil || *input.IsActive,
+	}
+	if reason.StockReason == "" {
+		reason.StockReason = StockReasonAdjustment
+	}
+
+	if err := db.WithContext(ctx).Create(&reason).Error; err != nil {
+		return nil, err
+	}
+	return &reason, nil
+}
+
+func UpdateAdjustmentReason(ctx context.Context, id int, input *NewAdjustmentReason) (*AdjustmentReason, error) {
+
+	db := config.GetDB()
+	var reason AdjustmentReason
+	var count int64
+
+	err := db.WithContext(ctx).First(&reason, id).Error
+	if err != nil {
+		return nil, utils.ErrorRecordNotFound
+	}
+
+	if err := validateAdjustmentReasonInput(input); err != nil {
+		return nil, err
+	}
+
+	code := strings.ToLower(strings.TrimSpace(input.Code))
+	err = db.WithContext(ctx).Model(&AdjustmentReason{}).
+		Where("code = ?", code).
+		Not("id = ?", id).
+		Count(&count).Error
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, errors.New("duplicate code")
+	}
+
+	updates := map[string]interface{}{
+		"Code": code,
+		"Name": strings.TrimSpace(input.Name),
+	}
+	if input.StockReason != "" {
+		updates["StockReason"] = input.StockReason
+	}
+	if input.IsActive != nil {
+		updates["IsActive"] = *input.IsActive
+	}
+
+	if err := db.WithContext(ctx).Model(&reason).Updates(updates).Error; err != nil {
+		return nil, err
+	}
+	return &reason, nil
+}
+
+func GetAdjustmentReason(ctx context.Context, id int) (*AdjustmentReason, error) {
+
+	db := config.GetDB()
+	var reason AdjustmentReason
+
+	err := db.WithContext(ctx).First(&reason, id).Error
+	if err != nil {
+		return nil, utils.ErrorRecordNotFound
+	}
+	return &reason, nil
+}
+
+func GetAdjustmentReasons(ctx context.Context, includeInactive bool) ([]*AdjustmentReason, error) {
+
+	db := config.GetDB()
+	var results []*AdjustmentReason
+
+	dbCtx := db.WithContext(ctx)
+	if !includeInactive {
+		dbCtx = dbCtx.Where("is_active = ?", true)
+	}
+
+	err := dbCtx.Order("name").Find(&results).Error
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// approvalLimit is how much a single adjustment by the actor may be worth before it needs approval.
+// API keys carry their role; users are looked up.
+func approvalLimit(ctx context.Context, actorId int, roleId int) (float64, error) {
+
+	ceiling, err := actorCeiling(config.GetDB(), ctx, actorId, roleId)
+	if err != nil {
+		return 0, err
+	}
+	return ceiling.ApprovalLimit, nil
+}
+
+// CreateStockAdjustment records an adjustment and posts it at once when its value is within the
+// creator's approval limit; otherwise it waits as pending for a reviewer.
+func CreateStockAdjustment(ctx context.Context, scope *utils.BranchClaim, input *NewStockAdjustment, actorId int, roleId int) (*StockAdjustment, error) {
+
+	db := config.GetDB()
+	var reason AdjustmentReason
+
+	if scope == nil || !scope.CanAccessBranch(input.BranchId) {
+		return nil, errors.New("branch is not accessible")
+	}
+
+	if roundQuantity(input.Quantity) == 0 {
+		return nil, errors.New("adjustment quantity cannot be zero")
+	}
+
+	err := db.WithContext(ctx).Where("id = ? AND is_active = ?", input.ReasonId, true).First(&reason).Error
+	if err != nil {
+		return nil, errors.New("invalid reason id")
+	}
+
+	item := StockItem{
+		BranchId:           input.BranchId,
+		ProductId:          input.ProductId,
+		ProductVariationId: input.ProductVariationId,
+	}
+	product, err := validateStockItem(db, ctx, item)
+	if err != nil {
+		return nil, err
+	}
+	quantity, err := toBaseQuantity(db, ctx, input.ProductId, input.ProductVariationId, input.UomId, input.Quantity)
+	if err != nil {
+		return nil, err
+	}
+	quantity = roundQuantity(quantity)
+	if input.LotId > 0 {
+		if _, err := itemLot(db, ctx, item, input.LotId); err != nil {
+			return nil, err
+		}
+	}
+
+	limit, err := approvalLimit(ctx, actorId, roleId)
+	if err != nil {
+		return nil, err
+	}
+
+	adjustment := StockAdjustment{
+		BranchId:           input.BranchId,
+		ProductId:          input.ProductId,
+		ProductVariationId: input.ProductVariationId,
+		LotId:              input.LotId,
+		Quantity:           quantity,
+		UomId:              input.UomId,
+		UomQuantity:        roundQuantity(input.Quantity),
+		ReasonId:           reason.ID,
+		Note:               truncate(input.Note, 255),
+		UnitCost:           product.Cost,
+		Value:              math.Round(math.Abs(quantity)*product.Cost*100) / 100,
+		Status:             StockAdjustmentStatusPending,
+		CreatedBy:          actorId,
+	}
+
+	tx := db.Begin()
+
+	if err := tx.WithContext(ctx).Create(&adjustment).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if adjustment.Value <= limit {
+		if err := postStockAdjustment(tx, ctx, &adjustment, &reason, actorId, ""); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return &adjustment, nil
+}
+
+// ApproveStockAdjustment posts a pending adjustment to the ledger. Nobody approves their own adjustment.
+func ApproveStockAdjustment(ctx context.Context, scope *utils.BranchClaim, id int, note *string, actorId int) (*StockAdjustment, error) {
+
+	db := config.GetDB()
+	var reason AdjustmentReason
+
+	tx := db.Begin()
+
+	adjustment, err := lockPendingAdjustment(tx, ctx, scope, id, actorId)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	// The reason may have been deactivated since, but it still explains this adjustment
+	if err := tx.WithContext(ctx).First(&reason, adjustment.ReasonId).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := postStockAdjustment(tx, ctx, adjustment, &reason, actorId, stringValue(note)); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return adjustment, nil
+}
+
+func RejectStockAdjustment(ctx context.Context, scope *utils.BranchClaim, id int, note *string, actorId int) (*StockAdjustment, error) {
+
+	db := config.GetDB()
+
+	tx := db.Begin()
+
+	adjustment, err := lockPendingAdjustment(tx, ctx, scope, id, actorId)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := reviewStockAdjustment(tx, ctx, adjustment, StockAdjustmentStatusRejected, actorId, stringValue(note)); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return adjustment, nil
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func lockPendingAdjustment(tx *gorm.DB, ctx context.Context, scope *utils.BranchClaim, id int, actorId int) (*StockAdjustment, error) {
+
+	var adjustment StockAdjustment
+
+	err := tx.WithContext(ctx).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Scopes(ScopeBranches(scope, "branch_id")).
+		First(&adjustment, id).Error
+	if err != nil {
+		return nil, utils.ErrorRecordNotFound
+	}
+	if adjustment.Status != StockAdjustmentStatusPending {
+		return nil, errAdjustmentNotPending
+	}
+	if adjustment.CreatedBy == actorId {
+		return nil, errors.New("adjustments cannot be reviewed by their creator")
+	}
+	return &adjustment, nil
+}
+
+// postStockAdjustment applies the adjustment to stock and marks it approved; only approved
+// adjustments ever reach the ledger.
+func postStockAdjustment(tx *gorm.DB, ctx context.Context, adjustment *StockAdjustment, reason *AdjustmentReason, actorId int, note string) error {
+
+	item := StockItem{
+		BranchId:           adjustment.BranchId,
+		ProductId:          adjustment.ProductId,
+		ProductVariationId: adjustment.ProductVariationId,
+	}
+	var lots []LotAllocation
+	if adjustment.LotId > 0 {
+		lots = []LotAllocation{{LotId: adjustment.LotId, Quantity: math.Abs(adjustment.Quantity)}}
+	}
+	ledgerNote := reason.Code
+	if adjustment.Note != "" {
+		ledgerNote += ": " + adjustment.Note
+	}
+	_, err := applyStockChange(tx, ctx, item, StockChange{
+		OnHand:        adjustment.Quantity,
+		Lots:          lots,
+		Reason:        reason.StockReason,
+		ReferenceType: stockAdjustmentReference,
+		ReferenceId:   adjustment.ID,
+		ActorId:       actorId,
+		Note:          ledgerNote,
+	})
+	if err != nil {
+		return err
+	}
+
+	return reviewStockAdjustment(tx, ctx, adjustment, StockAdjustmentStatusApproved, actorId, note)
+}
+
+func reviewStockAdjustment(tx *gorm.DB, ctx context.Context, adjustment *StockAdjustment, status string, actorId int, note string) error {
+
+	now := time.Now()
+	adjustment.Status = status
+	adjustment.ReviewedBy = actorId
+	adjustment.ReviewedAt = &now
+	adjustment.ReviewNote = truncate(note, 255)
+
+	return tx.WithContext(ctx).Model(adjustment).Updates(map[string]interface{}{
+		"Status":     adjustment.Status,
+		"ReviewedBy": adjustment.ReviewedBy,
+		"ReviewedAt": adjustment.ReviewedAt,
+		"ReviewNote": adjustment.ReviewNote,
+	}).Error
+}
+
+func GetStockAdjustment(ctx context.Context, scope *utils.BranchClaim, id int) (*StockAdjustment, error) {
+
+	db := config.GetDB()
+	var adjustment StockAdjustment
+
+	err := db.WithContext(ctx).Scopes(ScopeBranches(scope, "branch_id")).First(&adjustment, id).Error
+	if err != nil {
+		return nil, utils.ErrorRecordNotFound
+	}
+	return &adjustment, nil
+}
+
+func GetStockAdjustments(ctx context.Context, scope *utils.BranchClaim, status *string, branchId *int) ([]*StockAdjustment, error) {
+
+	db := config.GetDB()
+	var results []*StockAdjustment
+
+	dbCtx := db.WithContext(ctx).Scopes(ScopeBranches(scope, "branch_id"))
+	if status != nil && len(*status) > 0 {
+		dbCtx = dbCtx.Where("status = ?", *status)
+	}
+	if branchId != nil {
+		dbCtx = dbCtx.Where("branch_id = ?", *branchId)
+	}
+
+	err := dbCtx.Order("created_at desc").Limit(200).Find(&results).Error
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
@@ -0,0 +1,716 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/config"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// StockCount is a stock take of one branch, either in full or limited to a category and the
+// categories below it.
+type StockCount struct {
+	ID         int        `gorm:"primary_key" json:"id"`
+	BranchId   int        `gorm:"index;not null" json:"branch_id"`
+	CategoryId int        `gorm:"not null;default:0" json:"category_id"`
+	Status     string     `gorm:"index;size:20;not null" json:"status"`
+	Note       string     `gorm:"size:255" json:"note"`
+	CreatedBy  int        `gorm:"not null;default:0" json:"created_by"`
+	ReasonId   int        `gorm:"not null;default:0" json:"reason_id"`
+	PostedBy   int        `gorm:"not null;default:0" json:"posted_by"`
+	PostedAt   *time.Time `json:"posted_at"`
+	CreatedAt  time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt  time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// StockCountLine holds the on-hand quantity expected when the count started and what was counted.
+// CountedQuantity stays nil until somebody counts the item.
+type StockCountLine struct {
+	ID                 int        `gorm:"primary_key" json:"id"`
+	StockCountId       int        `gorm:"uniqueIndex:idx_stock_count_line_item;not null" json:"stock_count_id"`
+	ProductId          int        `gorm:"uniqueIndex:idx_stock_count_line_item;not null" json:"product_id"`
+	ProductVariationId int        `gorm:"uniqueIndex:idx_stock_count_line_item;not null;default:0" json:"product_variation_id"`
+	CategoryId         int        `gorm:"not null;default:0" json:"category_id"`
+	ExpectedQuantity   float64    `gorm:"type:decimal(14,3);not null;default:0" json:"expected_quantity"`
+	CountedQuantity    *float64   `gorm:"type:decimal(14,3)" json:"counted_quantity"`
+	UnitCost           float64    `gorm:"type:decimal(10,2);not null;default:0" json:"unit_cost"`
+	CountedBy          int        `gorm:"not null;default:0" json:"counted_by"`
+	CountedAt          *time.Time `json:"counted_at"`
+	AdjustmentId       int        `gorm:"not null;default:0" json:"adjustment_id"`
+}
+
+type NewStockCount struct {
+	BranchId   int    `json:"branch_id" binding:"required"`
+	CategoryId int    `json:"category_id"`
+	Note       string `json:"note"`
+}
+
+// StockCountEntry is one scan or typed count. Barcode is looked up on variations first, then on
+// products; without it the product and variation ids are used.
+type StockCountEntry struct {
+	Barcode            string  `json:"barcode"`
+	ProductId          int     `json:"product_id"`
+	ProductVariationId int     `json:"product_variation_id"`
+	UomId              int     `json:"uom_id"`
+	Quantity           float64 `json:"quantity"`
+}
+
+// StockCountVariance is the variance report of a count, grouped by category.
+type StockCountVariance struct {
+	StockCountId     int                        `json:"stock_count_id"`
+	VarianceQuantity float64                    `json:"variance_quantity"`
+	VarianceValue    float64                    `json:"variance_value"`
+	UncountedLines   int                        `json:"uncounted_lines"`
+	Groups           []*StockCountVarianceGroup `json:"groups"`
+}
+
+// StockCountVarianceGroup holds the lines of one category; TotalVarianceValue includes the
+// categories below.
+type StockCountVarianceGroup struct {
+	CategoryId         int               `json:"category_id"`
+	ParentCategoryId   int               `json:"parent_category_id"`
+	VarianceQuantity   float64           `json:"variance_quantity"`
+	VarianceValue      float64           `json:"variance_value"`
+	TotalVarianceValue float64           `json:"total_variance_value"`
+	Lines              []*StockCountLine `json:"lines"`
+}
+
+const (
+	StockCountStatusOpen      = "open"
+	StockCountStatusPosted    = "posted"
+	StockCountStatusCancelled = "cancelled"
+)
+
+var errStockCountNotOpen = errors.New("stock count is not open")
+
+// Variance is counted minus expected, or nil while the line is uncounted.
+func (line *StockCountLine) Variance() *float64 {
+	if line.CountedQuantity == nil {
+		return nil
+	}
+	variance := roundQuantity(*line.CountedQuantity - line.ExpectedQuantity)
+	return &variance
+}
+
+func (line *StockCountLine) varianceValue() float64 {
+	variance := line.Variance()
+	if variance == nil {
+		return 0
+	}
+	return math.Round(*variance*line.UnitCost*100) / 100
+}
+
+// StartStockCount opens a count and snapshots the expected on-hand quantity of every item in its scope.
+func StartStockCount(ctx context.Context, scope *utils.BranchClaim, input *NewStockCount, actorId int) (*StockCount, error) {
+
+	db := config.GetDB()
+	var openCategoryIds []int
+	var products []Product
+	var variations []ProductVariation
+	var levels []StockLevel
+
+	if scope == nil || !scope.CanAccessBranch(input.BranchId) {
+		return nil, errors.New("branch is not accessible")
+	}
+	if !utils.IsRecordValidByID(input.BranchId, &Branch{}, db) {
+		return nil, errors.New("invalid branch id")
+	}
+	if input.CategoryId > 0 && !utils.IsRecordValidByID(input.CategoryId, &Category{}, db) {
+		return nil, errors.New("invalid category id")
+	}
+
+	parents, err := categoryTree(db, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	dbCtx := db.WithContext(ctx).Select("id", "category_id", "cost")
+	if input.CategoryId > 0 {
+		dbCtx = dbCtx.Where("category_id IN ?", categorySubtree(parents, input.CategoryId))
+	}
+	if err := dbCtx.Order("id").Find(&products).Error; err != nil {
+		return nil, err
+	}
+
+	productIds := make([]int, len(products))
+	for i, product := range products {
+		productIds[i] = product.ID
+	}
+
+	if len(productIds) > 0 {
+		err := db.WithContext(ctx).Select("id", "product_id").Where("product_id IN ?", productIds).Order("id").Find(&variations).Error
+		if err != nil {
+			return nil, err
+		}
+		err = db.WithContext(ctx).Where("branch_id = ? AND product_id IN ?", input.BranchId, productIds).Find(&levels).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	onHand := make(map[StockItem]float64, len(levels))
+	for _, level := range levels {
+		onHand[StockItem{ProductId: level.ProductId, ProductVariationId: level.ProductVariationId}] = level.OnHand
+	}
+	variationsByProduct := make(map[int][]int)
+	for _, variation := range variations {
+		variationsByProduct[variation.ProductId] = append(variationsByProduct[variation.ProductId], variation.ID)
+	}
+
+	var lines []StockCountLine
+	for _, product := range products {
+		// A product with variations is only stocked per variation
+		variationIds := variationsByProduct[product.ID]
+		if len(variationIds) == 0 {
+			variationIds = []int{0}
+		}
+		for _, variationId := range variationIds {
+			lines = append(lines, StockCountLine{
+				ProductId:          product.ID,
+				ProductVariationId: variationId,
+				CategoryId:         product.CategoryId,
+				ExpectedQuantity:   onHand[StockItem{ProductId: product.ID, ProductVariationId: variationId}],
+				UnitCost:           product.Cost,
+			})
+		}
+	}
+
+	stockCount := StockCount{
+		BranchId:   input.BranchId,
+		CategoryId: input.CategoryId,
+		Status:     StockCountStatusOpen,
+		Note:       truncate(input.Note, 255),
+		CreatedBy:  actorId,
+	}
+
+	tx := db.Begin()
+
+	// Two open counts of the same items would post the same variance twice; the branch row
+	// lock keeps two counts from starting side by side
+	if err := tx.WithContext(ctx).Clauses(clause.Locking{Strength: "UPDATE"}).First(&Branch{}, input.BranchId).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	err = tx.WithContext(ctx).Model(&StockCount{}).
+		Where("branch_id = ? AND status = ?", input.BranchId, StockCountStatusOpen).
+		Pluck("category_id", &openCategoryIds).Error
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	for _, openCategoryId := range openCategoryIds {
+		if input.CategoryId == 0 || openCategoryId == 0 ||
+			containsInt(categorySubtree(parents, input.CategoryId), openCategoryId) ||
+			containsInt(categorySubtree(parents, openCategoryId), input.CategoryId) {
+			tx.Rollback()
+			return nil, errors.New("an open stock count already covers these items")
+		}
+	}
+
+	if err := tx.WithContext(ctx).Create(&stockCount).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	for i := range lines {
+		lines[i].StockCountId = stockCount.ID
+	}
+	if len(lines) > 0 {
+		if err := tx.WithContext(ctx).CreateInBatches(lines, 500).Error; err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return &stockCount, nil
+}
+
+// RecordStockCount adds the entries to the counted quantities, or replaces them when replace is set.
+// Counters on several devices only hold a shared lock on the count and add to each line in SQL,
+// so their scans never overwrite each other.
+func RecordStockCount(ctx context.Context, scope *utils.BranchClaim, id int, entries []*StockCountEntry, replace bool, actorId int) ([]*StockCountLine, error) {
+
+	db := config.GetDB()
+	var results []*StockCountLine
+
+	if len(entries) == 0 {
+		return nil, errors.New("nothing to record")
+	}
+
+	tx := db.Begin()
+
+	stockCount, err := lockStockCount(tx, ctx, scope, id, "SHARE")
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if stockCount.Status != StockCountStatusOpen {
+		tx.Rollback()
+		return nil, errStockCountNotOpen
+	}
+
+	items := make([]StockItem, len(entries))
+	quantities := make([]float64, len(entries))
+	for i, entry := range entries {
+		item, quantity, err := resolveStockCountEntry(tx, ctx, entry)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		item.BranchId = stockCount.BranchId
+		items[i] = item
+		quantities[i] = quantity
+	}
+
+	// Lock lines in one order so two devices scanning the same items cannot deadlock
+	order := make([]int, len(entries))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := items[order[i]], items[order[j]]
+		if a.ProductId != b.ProductId {
+			return a.ProductId < b.ProductId
+		}
+		return a.ProductVariationId < b.ProductVariationId
+	})
+
+	now := time.Now()
+	lineIds := make([]int, 0, len(entries))
+	for _, i := range order {
+		quantity := quantities[i]
+		if replace && quantity < 0 {
+			tx.Rollback()
+			return nil, errors.New("counted quantity cannot be negative")
+		}
+
+		line, err := stockCountLineFor(tx, ctx, stockCount, items[i])
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+
+		counted := gorm.Expr("COALESCE(counted_quantity, 0) + ?", quantity)
+		if replace {
+			counted = gorm.Expr("?", quantity)
+		}
+		err = tx.WithContext(ctx).Model(&StockCountLine{}).Where("id = ?", line.ID).Updates(map[string]interface{}{
+			"counted_quantity": counted,
+			"counted_by":       actorId,
+			"counted_at":       now,
+		}).Error
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		lineIds = append(lineIds, line.ID)
+	}
+
+	err = tx.WithContext(ctx).Where("id IN ?", lineIds).Order("product_id, product_variation_id").Find(&results).Error
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	for _, line := range results {
+		if *line.CountedQuantity < 0 {
+			tx.Rollback()
+			return nil, errors.New("counted quantity cannot be negative")
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// resolveStockCountEntry finds the item an entry counts and its quantity in base units. A barcode
+// is looked up on variations, then products, then packaging units, so scanning a carton counts
+// every piece in it.
+func resolveStockCountEntry(tx *gorm.DB, ctx context.Context, entry *StockCountEntry) (StockItem, float64, error) {
+
+	item, factor, err := stockCountEntryItem(tx, ctx, entry)
+	if err != nil {
+		return StockItem{}, 0, err
+	}
+	if entry.UomId > 0 {
+		if factor != 1 {
+			return StockItem{}, 0, errors.New("a packaging barcode already sets the unit")
+		}
+		quantity, err := toBaseQuantity(tx, ctx, item.ProductId, item.ProductVariationId, entry.UomId, entry.Quantity)
+		if err != nil {
+			return StockItem{}, 0, err
+		}
+		return item, quantity, nil
+	}
+	return item, roundQuantity(entry.Quantity * factor), nil
+}
+
+func stockCountEntryItem(tx *gorm.DB, ctx context.Context, entry *StockCountEntry) (StockItem, float64, error) {
+
+	if entry.Barcode == "" {
+		if entry.ProductId == 0 {
+			return StockItem{}, 0, errors.New("barcode or product id is required")
+		}
+		return StockItem{ProductId: entry.ProductId, ProductVariationId: entry.ProductVariationId}, 1, nil
+	}
+
+	var variation ProductVariation
+	err := tx.WithContext(ctx).Select("id", "product_id").Where("barcode = ?", entry.Barcode).Take(&variation).Error
+	if err == nil {
+		return StockItem{ProductId: variation.ProductId, ProductVariationId: variation.ID}, 1, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return StockItem{}, 0, err
+	}
+
+	var product Product
+	err = tx.WithContext(ctx).Select("id").Where("barcode = ?", entry.Barcode).Take(&product).Error
+	if err == nil {
+		return StockItem{ProductId: product.ID}, 1, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return StockItem{}, 0, err
+	}
+
+	unit, err := unitByBarcode(tx, ctx, entry.Barcode)
+	if err != nil {
+		return StockItem{}, 0, fmt.Errorf("unknown barcode %s", entry.Barcode)
+	}
+	return StockItem{ProductId: unit.ProductId, ProductVariationId: unit.ProductVariationId}, unit.Factor, nil
+}
+
+// stockCountLineFor returns the line counting item. Items created after the count started get a
+// line expecting nothing, as long as they fall within the count's category.
+func stockCountLineFor(tx *gorm.DB, ctx context.Context, stockCount *StockCount, item StockItem) (*StockCountLine, error) {
+
+	var line StockCountLine
+
+	err := tx.WithContext(ctx).
+		Where("stock_count_id = ? AND product_id = ? AND product_variation_id = ?", stockCount.ID, item.ProductId, item.ProductVariationId).
+		Take(&line).Error
+	if err == nil {
+		return &line, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	product, err := validateStockItem(tx, ctx, item)
+	if err != nil {
+		return nil, err
+	}
+
+	if stockCount.CategoryId > 0 {
+		parents, err := categoryTree(tx, ctx)
+		if err != nil {
+			return nil, err
+		}
+		if !containsInt(categorySubtree(parents, stockCount.CategoryId), product.CategoryId) {
+			return nil, errors.New("product is outside the category being counted")
+		}
+	}
+
+	line = StockCountLine{
+		StockCountId:       stockCount.ID,
+		ProductId:          item.ProductId,
+		ProductVariationId: item.ProductVariationId,
+		CategoryId:         product.CategoryId,
+		UnitCost:           product.Cost,
+	}
+	err = tx.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&line).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// Another device may have added the line first
+	line = StockCountLine{}
+	err = tx.WithContext(ctx).
+		Where("stock_count_id = ? AND product_id = ? AND product_variation_id = ?", stockCount.ID, item.ProductId, item.ProductVariationId).
+		Take(&line).Error
+	if err != nil {
+		return nil, err
+	}
+	return &line, nil
+}
+
+// PostStockCount books every variance as a stock adjustment with the given reason. Like any
+// adjustment, a variance worth more than the actor's approval limit stays pending for another
+// reviewer. Variances are applied as differences, so sales made while counting are kept.
+// Uncounted lines are skipped unless zeroUncounted is set, in which case they are counted as zero.
+func PostStockCount(ctx context.Context, scope *utils.BranchClaim, id int, reasonId int, zeroUncounted bool, actorId int, roleId int) (*StockCount, error) {
+
+	db := config.GetDB()
+	var reason AdjustmentReason
+	var lines []*StockCountLine
+
+	err := db.WithContext(ctx).Where("id = ? AND is_active = ?", reasonId, true).First(&reason).Error
+	if err != nil {
+		return nil, errors.New("invalid reason id")
+	}
+
+	limit, err := approvalLimit(ctx, actorId, roleId)
+	if err != nil {
+		return nil, err
+	}
+
+	tx := db.Begin()
+
+	stockCount, err := lockStockCount(tx, ctx, scope, id, "UPDATE")
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if stockCount.Status != StockCountStatusOpen {
+		tx.Rollback()
+		return nil, errStockCountNotOpen
+	}
+
+	err = tx.WithContext(ctx).Where("stock_count_id = ?", id).Order("product_id, product_variation_id").Find(&lines).Error
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	for _, line := range lines {
+		updates := map[string]interface{}{}
+		if line.CountedQuantity == nil {
+			if !zeroUncounted {
+				continue
+			}
+			zero := 0.0
+			line.CountedQuantity = &zero
+			updates["CountedQuantity"] = zero
+		}
+
+		variance := *line.Variance()
+		if variance != 0 {
+			// Found stock of a lot-tracked item goes into its newest lot; shortfalls are taken FEFO
+			lotId := 0
+			if variance > 0 {
+				lotId, err = newestLot(tx, ctx, StockItem{BranchId: stockCount.BranchId, ProductId: line.ProductId, ProductVariationId: line.ProductVariationId})
+				if err != nil {
+					tx.Rollback()
+					return nil, err
+				}
+			}
+			adjustment := StockAdjustment{
+				BranchId:           stockCount.BranchId,
+				ProductId:          line.ProductId,
+				ProductVariationId: line.ProductVariationId,
+				LotId:              lotId,
+				Quantity:           variance,
+				ReasonId:           reason.ID,
+				Note:               fmt.Sprintf("stock count #%d", stockCount.ID),
+				UnitCost:           line.UnitCost,
+				Value:              roundValue(math.Abs(line.varianceValue())),
+				Status:             StockAdjustmentStatusPending,
+				CreatedBy:          actorId,
+				StockCountId:       stockCount.ID,
+			}
+			if err := tx.WithContext(ctx).Create(&adjustment).Error; err != nil {
+				tx.Rollback()
+				return nil, err
+			}
+			if adjustment.Value <= limit {
+				if err := postStockAdjustment(tx, ctx, &adjustment, &reason, actorId, ""); err != nil {
+					tx.Rollback()
+					return nil, err
+				}
+			}
+			updates["AdjustmentId"] = adjustment.ID
+		}
+
+		if len(updates) > 0 {
+			if err := tx.WithContext(ctx).Model(line).Updates(updates).Error; err != nil {
+				tx.Rollback()
+				return nil, err
+			}
+		}
+	}
+
+	err = tx.WithContext(ctx).Model(stockCount).Updates(map[string]interface{}{
+		"Status":   StockCountStatusPosted,
+		"ReasonId": reason.ID,
+		"PostedBy": actorId,
+		"PostedAt": time.Now(),
+	}).Error
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return stockCount, nil
+}
+
+func CancelStockCount(ctx context.Context, scope *utils.BranchClaim, id int) (*StockCount, error) {
+
+	db := config.GetDB()
+
+	tx := db.Begin()
+
+	stockCount, err := lockStockCount(tx, ctx, scope, id, "UPDATE")
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if stockCount.Status != StockCountStatusOpen {
+		tx.Rollback()
+		return nil, errStockCountNotOpen
+	}
+
+	if err := tx.WithContext(ctx).Model(stockCount).Update("Status", StockCountStatusCancelled).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return stockCount, nil
+}
+
+// lockStockCount loads a count visible to scope; recording takes a SHARE lock so several devices
+// can count at once, while posting and cancelling take an UPDATE lock and wait for them.
+func lockStockCount(tx *gorm.DB, ctx context.Context, scope *utils.BranchClaim, id int, strength string) (*StockCount, error) {
+
+	var stockCount StockCount
+
+	err := tx.WithContext(ctx).
+		Clauses(clause.Locking{Strength: strength}).
+		Scopes(ScopeBranches(scope, "branch_id")).
+		First(&stockCount, id).Error
+	if err != nil {
+		return nil, utils.ErrorRecordNotFound
+	}
+	return &stockCount, nil
+}
+
+// GetStockCountVariance reports the counted lines that differ from the snapshot, and the lines not
+// yet counted, grouped by category.
+func GetStockCountVariance(ctx context.Context, scope *utils.BranchClaim, id int) (*StockCountVariance, error) {
+
+	db := config.GetDB()
+	var lines []*StockCountLine
+
+	stockCount, err := GetStockCount(ctx, scope, id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.WithContext(ctx).Where("stock_count_id = ?", id).Order("product_id, product_variation_id").Find(&lines).Error
+	if err != nil {
+		return nil, err
+	}
+
+	parents, err := categoryTree(db, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := StockCountVariance{StockCountId: stockCount.ID}
+	rollups := newCategoryRollups(parents, stockCount.CategoryId)
+	groups := make(map[int]*StockCountVarianceGroup)
+
+	for _, line := range lines {
+		variance := line.Variance()
+		if variance != nil && *variance == 0 {
+			continue
+		}
+
+		g, ok := groups[line.CategoryId]
+		if !ok {
+			g = &StockCountVarianceGroup{CategoryId: line.CategoryId}
+			groups[line.CategoryId] = g
+		}
+		g.Lines = append(g.Lines, line)
+		if variance == nil {
+			report.UncountedLines++
+			rollups.add(line.CategoryId, 0)
+			continue
+		}
+
+		value := line.varianceValue()
+		g.VarianceQuantity = roundQuantity(g.VarianceQuantity + *variance)
+		rollups.add(line.CategoryId, value)
+		report.VarianceQuantity = roundQuantity(report.VarianceQuantity + *variance)
+		report.VarianceValue += value
+	}
+
+	report.Groups = make([]*StockCountVarianceGroup, 0, len(groups))
+	for _, rollup := range rollups.sorted() {
+		g, ok := groups[rollup.CategoryId]
+		if !ok {
+			g = &StockCountVarianceGroup{CategoryId: rollup.CategoryId, Lines: []*StockCountLine{}}
+		}
+		g.ParentCategoryId = rollup.ParentCategoryId
+		g.VarianceValue = rollup.Value
+		g.TotalVarianceValue = rollup.TotalValue
+		report.Groups = append(report.Groups, g)
+	}
+	report.VarianceValue = roundValue(report.VarianceValue)
+	return &report, nil
+}
+
+func GetStockCount(ctx context.Context, scope *utils.BranchClaim, id int) (*StockCount, error) {
+
+	db := config.GetDB()
+	var stockCount StockCount
+
+	err := db.WithContext(ctx).Scopes(ScopeBranches(scope, "branch_id")).First(&stockCount, id).Error
+	if err != nil {
+		return nil, utils.ErrorRecordNotFound
+	}
+	return &stockCount, nil
+}
+
+func GetStockCounts(ctx context.Context, scope *utils.BranchClaim, status *string, branchId *int) ([]*StockCount, error) {
+
+	db := config.GetDB()
+	var results []*StockCount
+
+	dbCtx := db.WithContext(ctx).Scopes(ScopeBranches(scope, "branch_id"))
+	if status != nil && len(*status) > 0 {
+		dbCtx = dbCtx.Where("status = ?", *status)
+	}
+	if branchId != nil {
+		dbCtx = dbCtx.Where("branch_id = ?", *branchId)
+	}
+
+	err := dbCtx.Order("created_at desc").Limit(200).Find(&results).Error
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// GetStockCountLines returns the lines of a count the caller has already loaded through its scope.
+func GetStockCountLines(ctx context.Context, stockCountId int, uncountedOnly bool) ([]*StockCountLine, error) {
+
+	db := config.GetDB()
+	var results []*StockCountLine
+
+	dbCtx := db.WithContext(ctx).Where("stock_count_id = ?", stockCountId)
+	if uncountedOnly {
+		dbCtx = dbCtx.Where("counted_quantity IS NULL")
+	}
+
+	err := dbCtx.Order("product_id, product_variation_id").Find(&results).Error
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
@@ -0,0 +1,340 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/config"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// StockLevel is the quantity of one product variation, or of a product without variations, at one branch.
+type StockLevel struct {
+	ID                 int       `gorm:"primary_key" json:"id"`
+	BranchId           int       `gorm:"uniqueIndex:idx_stock_level_item;not null" json:"branch_id"`
+	ProductId          int       `gorm:"uniqueIndex:idx_stock_level_item;index;not null" json:"product_id"`
+	ProductVariationId int       `gorm:"uniqueIndex:idx_stock_level_item;not null;default:0" json:"product_variation_id"`
+	OnHand             float64   `gorm:"type:decimal(14,3);not null;default:0" json:"on_hand"`
+	Reserved           float64   `gorm:"type:decimal(14,3);not null;default:0" json:"reserved"`
+	Quarantined        float64   `gorm:"type:decimal(14,3);not null;default:0" json:"quarantined"`
+	Available          float64   `gorm:"type:decimal(14,3);not null;default:0" json:"available"`
+	InTransit          float64   `gorm:"type:decimal(14,3);not null;default:0" json:"in_transit"`
+	Value              float64   `gorm:"type:decimal(16,2);not null;default:0" json:"value"`
+	CreatedAt          time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt          time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// StockItem identifies what a stock level counts; ProductVariationId is 0 for a product without variations.
+type StockItem struct {
+	BranchId           int
+	ProductId          int
+	ProductVariationId int
+}
+
+// StockChange is a signed change to the quantities of one stock level. Any on-hand change is
+// written to the movement ledger with the reason and reference given here. Lots says which lots
+// an on-hand change of a lot-tracked product goes into or comes out of. UnitCost is what incoming
+// stock cost, which may be zero for free goods; when nil, stock comes in at the current cost.
+type StockChange struct {
+	OnHand        float64
+	Reserved      float64
+	Quarantined   float64
+	InTransit     float64
+	Lots          []LotAllocation
+	UnitCost      *float64
+	Reason        string
+	ReferenceType string
+	ReferenceId   int
+	ActorId       int
+	Note          string
+}
+
+// StockPosting is what applying a StockChange did: the updated level, the lots an on-hand change
+// went through, and the cost per unit and signed value it was booked at.
+type StockPosting struct {
+	Level    *StockLevel
+	Lots     []LotAllocation
+	UnitCost float64
+	Value    float64
+}
+
+var ErrInsufficientStock = errors.New("insufficient stock")
+
+// validateStockItem checks that the branch exists and that the variation, if any, belongs to the product.
+// A product with variations is only stocked per variation.
+func validateStockItem(tx *gorm.DB, ctx context.Context, item StockItem) (*Product, error) {
+
+	var product Product
+	var count int64
+
+	if !utils.IsRecordValidByID(item.BranchId, &Branch{}, tx) {
+		return nil, errors.New("invalid branch id")
+	}
+
+	err := tx.WithContext(ctx).First(&product, item.ProductId).Error
+	if err != nil {
+		return nil, errors.New("invalid product id")
+	}
+
+	if item.ProductVariationId > 0 {
+		err = tx.WithContext(ctx).Model(&ProductVariation{}).
+			Where("id = ? AND product_id = ?", item.ProductVariationId, item.ProductId).
+			Count(&count).Error
+		if err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			return nil, errors.New("variation does not belong to product")
+		}
+		return &product, nil
+	}
+
+	err = tx.WithContext(ctx).Model(&ProductVariation{}).Where("product_id = ?", item.ProductId).Count(&count).Error
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, errors.New("product variation id is required")
+	}
+	return &product, nil
+}
+
+// lockStockLevel returns the stock level of item locked FOR UPDATE inside tx, creating an empty one first
+// when the item has never been stocked at the branch.
+func lockStockLevel(tx *gorm.DB, ctx context.Context, item StockItem) (*StockLevel, error) {
+
+	var level StockLevel
+
+	err := takeStockLevelForUpdate(tx, ctx, item, &level)
+	if err == nil {
+		return &level, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	// Another transaction may create the same row first, so ignore the conflict and lock whichever row won
+	level = StockLevel{
+		BranchId:           item.BranchId,
+		ProductId:          item.ProductId,
+		ProductVariationId: item.ProductVariationId,
+	}
+	err = tx.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&level).Error
+	if err != nil {
+		return nil, err
+	}
+
+	level = StockLevel{}
+	if err := takeStockLevelForUpdate(tx, ctx, item, &level); err != nil {
+		return nil, err
+	}
+	return &level, nil
+}
+
+// lockStockLevels locks the stock levels of items across branches in one global order, so two
+// transactions touching the same rows in opposite directions cannot deadlock.
+func lockStockLevels(tx *gorm.DB, ctx context.Context, items []StockItem) error {
+
+	sorted := append([]StockItem(nil), items...)
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.BranchId != b.BranchId {
+			return a.BranchId < b.BranchId
+		}
+		if a.ProductId != b.ProductId {
+			return a.ProductId < b.ProductId
+		}
+		return a.ProductVariationId < b.ProductVariationId
+	})
+
+	for _, item := range sorted {
+		if _, err := lockStockLevel(tx, ctx, item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func takeStockLevelForUpdate(tx *gorm.DB, ctx context.Context, item StockItem, level *StockLevel) error {
+	return tx.WithContext(ctx).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("branch_id = ? AND product_id = ? AND product_variation_id = ?", item.BranchId, item.ProductId, item.ProductVariationId).
+		Take(level).Error
+}
+
+// applyStockChange adds change to the locked stock level of item. It refuses to take available stock
+// below zero unless the product may be sold out of stock, so concurrent callers cannot oversell.
+// Callers must run it inside a transaction.
+func applyStockChange(tx *gorm.DB, ctx context.Context, item StockItem, change StockChange) (*StockLevel, error) {
+	posting, err := postStockChange(tx, ctx, item, change)
+	if err != nil {
+		return nil, err
+	}
+	return posting.Level, nil
+}
+
+// postStockChange is applyStockChange that also returns how the change was spread over lots and
+// what it was valued at.
+func postStockChange(tx *gorm.DB, ctx context.Context, item StockItem, change StockChange) (*StockPosting, error) {
+
+	product, err := validateStockItem(tx, ctx, item)
+	if err != nil {
+		return nil, err
+	}
+
+	level, err := lockStockLevel(tx, ctx, item)
+	if err != nil {
+		return nil, err
+	}
+
+	posting := StockPosting{Level: level}
+	if product.IsLotTracked && change.OnHand != 0 {
+		var quarantined float64
+		posting.Lots, quarantined, err = applyLotChange(tx, ctx, product, item, change)
+		if err != nil {
+			return nil, err
+		}
+		change.Quarantined += quarantined
+	}
+	if change.OnHand != 0 {
+		posting.Value, posting.UnitCost, err = costStockChange(tx, ctx, product, level, item, change.OnHand, change.UnitCost)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	onHand := roundQuantity(level.OnHand + change.OnHand)
+	reserved := roundQuantity(level.Reserved + change.Reserved)
+	quarantined := roundQuantity(level.Quarantined + change.Quarantined)
+	available := roundQuantity(onHand - reserved - quarantined)
+	inTransit := roundQuantity(level.InTransit + change.InTransit)
+	value := roundValue(level.Value + posting.Value)
+
+	if reserved < 0 {
+		return nil, errors.New("reserved quantity cannot be negative")
+	}
+	if quarantined < 0 {
+		return nil, errors.New("quarantined quantity cannot be negative")
+	}
+	if inTransit < 0 {
+		return nil, errors.New("in-transit quantity cannot be negative")
+	}
+	// Only changes that reduce what is free to sell are checked, so stock can always be put back
+	// and a lot can always be quarantined
+	if available < 0 && (change.OnHand < 0 || change.Reserved > 0) && !product.IsContinueSellingOutOfStock {
+		return nil, ErrInsufficientStock
+	}
+	// Expired lots count as on hand but cannot be issued, so reservations of a lot-tracked item are
+	// also held to what its sellable lots can fill
+	if change.Reserved > 0 && product.IsLotTracked && !product.IsContinueSellingOutOfStock {
+		sellable, err := sellableLotQuantity(tx, ctx, item)
+		if err != nil {
+			return nil, err
+		}
+		if reserved > sellable {
+			return nil, ErrInsufficientStock
+		}
+	}
+
+	err = tx.WithContext(ctx).Model(level).Updates(map[string]interface{}{
+		"OnHand":      onHand,
+		"Reserved":    reserved,
+		"Quarantined": quarantined,
+		"Available":   available,
+		"InTransit":   inTransit,
+		"Value":       value,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if change.OnHand == 0 {
+		return &posting, nil
+	}
+	if len(posting.Lots) == 0 {
+		movement := StockMovement{
+			Quantity:     change.OnHand,
+			Balance:      onHand,
+			UnitCost:     posting.UnitCost,
+			Value:        posting.Value,
+			ValueBalance: value,
+		}
+		if err := recordStockMovement(tx, ctx, item, change, &movement); err != nil {
+			return nil, err
+		}
+		return &posting, nil
+	}
+
+	// One movement per lot, each carrying the balances after it; the last takes any rounding left
+	balance := onHand - change.OnHand
+	valueBalance := value - posting.Value
+	for i, allocation := range posting.Lots {
+		lotValue := roundValue(allocation.Quantity * posting.UnitCost)
+		if i == len(posting.Lots)-1 {
+			lotValue = roundValue(value - valueBalance)
+		}
+		balance = roundQuantity(balance + allocation.Quantity)
+		valueBalance = roundValue(valueBalance + lotValue)
+		movement := StockMovement{
+			LotId:        allocation.LotId,
+			Quantity:     allocation.Quantity,
+			Balance:      balance,
+			UnitCost:     posting.UnitCost,
+			Value:        lotValue,
+			ValueBalance: valueBalance,
+		}
+		if err := recordStockMovement(tx, ctx, item, change, &movement); err != nil {
+			return nil, err
+		}
+	}
+	return &posting, nil
+}
+
+// productHasStock reports whether any branch holds, reserves or expects stock of the product.
+func productHasStock(tx *gorm.DB, ctx context.Context, productId int) (bool, error) {
+
+	var count int64
+
+	err := tx.WithContext(ctx).Model(&StockLevel{}).
+		Where("product_id = ? AND (on_hand <> 0 OR reserved <> 0 OR in_transit <> 0)", productId).
+		Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// roundQuantity keeps quantities to the three decimals the columns store.
+func roundQuantity(quantity float64) float64 {
+	if quantity < 0 {
+		return -roundQuantity(-quantity)
+	}
+	return float64(int64(quantity*1000+0.5)) / 1000
+}
+
+func GetStockLevels(ctx context.Context, scope *utils.BranchClaim, branchId *int, productId *int, productVariationId *int) ([]*StockLevel, error) {
+
+	db := config.GetDB()
+	var results []*StockLevel
+
+	dbCtx := db.WithContext(ctx).Scopes(ScopeBranches(scope, "branch_id"))
+	if branchId != nil {
+		dbCtx = dbCtx.Where("branch_id = ?", *branchId)
+	}
+	if productId != nil {
+		dbCtx = dbCtx.Where("product_id = ?", *productId)
+	}
+	if productVariationId != nil {
+		dbCtx = dbCtx.Where("product_variation_id = ?", *productVariationId)
+	}
+
+	err := dbCtx.Order("branch_id, product_id, product_variation_id").Find(&results).Error
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
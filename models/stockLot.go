@@ -0,0 +1,494 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/config"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/utils"
+	"gorm.io/gorm"
+)
+
+// StockLot is the quantity of one lot of an item at one branch. Lots only exist for products with
+// IsLotTracked set, and the lots of an item always add up to its on-hand quantity.
+type StockLot struct {
+	ID                 int        `gorm:"primary_key" json:"id"`
+	BranchId           int        `gorm:"uniqueIndex:idx_stock_lot_number;not null" json:"branch_id"`
+	ProductId          int        `gorm:"uniqueIndex:idx_stock_lot_number;index;not null" json:"product_id"`
+	ProductVariationId int        `gorm:"uniqueIndex:idx_stock_lot_number;not null;default:0" json:"product_variation_id"`
+	LotNumber          string     `gorm:"uniqueIndex:idx_stock_lot_number;size:100;not null" json:"lot_number"`
+	ProductionDate     *time.Time `gorm:"type:date" json:"production_date"`
+	ExpiryDate         *time.Time `gorm:"type:date;index" json:"expiry_date"`
+	Quantity           float64    `gorm:"type:decimal(14,3);not null;default:0" json:"quantity"`
+	IsQuarantined      bool       `gorm:"not null;default:false" json:"is_quarantined"`
+	QuarantineReason   string     `gorm:"size:255" json:"quarantine_reason"`
+	QuarantinedBy      int        `gorm:"not null;default:0" json:"quarantined_by"`
+	QuarantinedAt      *time.Time `json:"quarantined_at"`
+	CreatedAt          time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt          time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// LotAllocation is the part of a stock change that went into or came out of one lot. To receive,
+// give the lot number and dates, or the id of an existing lot; to issue, give lot ids.
+type LotAllocation struct {
+	LotId          int        `json:"lot_id"`
+	LotNumber      string     `json:"lot_number"`
+	ProductionDate *time.Time `json:"production_date"`
+	ExpiryDate     *time.Time `json:"expiry_date"`
+	Quantity       float64    `json:"quantity"`
+}
+
+var errLotsRequired = errors.New("lots are required to receive a lot-tracked product")
+
+// canIssueUnsellable reports whether a change may take stock from quarantined or expired lots;
+// only write-offs and corrections may.
+func canIssueUnsellable(reason string) bool {
+	return reason == StockReasonWaste || reason == StockReasonAdjustment
+}
+
+// applyLotChange spreads an on-hand change of a lot-tracked item over its lots. Receipts go into the
+// lots given; issues take the lots given or, without any, the sellable lots that expire first (FEFO).
+// It returns the allocations made and the change to the quarantined quantity. The caller must hold
+// the lock on the item's stock level, which also guards its lots.
+func applyLotChange(tx *gorm.DB, ctx context.Context, product *Product, item StockItem, change StockChange) ([]LotAllocation, float64, error) {
+
+	if change.OnHand > 0 {
+		return receiveLots(tx, ctx, item, change)
+	}
+	return issueLots(tx, ctx, product, item, change)
+}
+
+func receiveLots(tx *gorm.DB, ctx context.Context, item StockItem, change StockChange) ([]LotAllocation, float64, error) {
+
+	if len(change.Lots) == 0 {
+		return nil, 0, errLotsRequired
+	}
+	if err := checkLotTotal(change.Lots, change.OnHand); err != nil {
+		return nil, 0, err
+	}
+
+	quarantined := 0.0
+	allocations := make([]LotAllocation, 0, len(change.Lots))
+	for _, allocation := range change.Lots {
+		quantity := roundQuantity(allocation.Quantity)
+		if quantity <= 0 {
+			return nil, 0, errors.New("lot quantity must be positive")
+		}
+
+		lot, err := findOrCreateLot(tx, ctx, item, allocation)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if err := tx.WithContext(ctx).Model(lot).Update("Quantity", gorm.Expr("quantity + ?", quantity)).Error; err != nil {
+			return nil, 0, err
+		}
+		if lot.IsQuarantined {
+			quarantined += quantity
+		}
+		allocations = append(allocations, lot.allocation(quantity))
+	}
+	return allocations, quarantined, nil
+}
+
+// issueLots takes need from the lots. A product that may be sold out of stock overdraws the last
+// lot it issues from, or its newest sellable lot, so its lots still add up to the on-hand quantity.
+func issueLots(tx *gorm.DB, ctx context.Context, product *Product, item StockItem, change StockChange) ([]LotAllocation, float64, error) {
+
+	need := roundQuantity(-change.OnHand)
+	var lots []*StockLot
+	var quantities []float64
+
+	if len(change.Lots) > 0 {
+		if err := checkLotTotal(change.Lots, need); err != nil {
+			return nil, 0, err
+		}
+		seen := make(map[int]bool, len(change.Lots))
+		for _, allocation := range change.Lots {
+			if seen[allocation.LotId] {
+				return nil, 0, errors.New("duplicate lot")
+			}
+			seen[allocation.LotId] = true
+
+			lot, err := itemLot(tx, ctx, item, allocation.LotId)
+			if err != nil {
+				return nil, 0, err
+			}
+			quantity := roundQuantity(allocation.Quantity)
+			if quantity <= 0 || quantity > lot.Quantity {
+				return nil, 0, ErrInsufficientStock
+			}
+			if !canIssueUnsellable(change.Reason) && !lot.isSellable() {
+				return nil, 0, errors.New("lot " + lot.LotNumber + " is quarantined or expired")
+			}
+			lots = append(lots, lot)
+			quantities = append(quantities, quantity)
+		}
+	} else {
+		dbCtx := tx.WithContext(ctx).
+			Where("branch_id = ? AND product_id = ? AND product_variation_id = ?", item.BranchId, item.ProductId, item.ProductVariationId).
+			Where("quantity > 0")
+		if !canIssueUnsellable(change.Reason) {
+			dbCtx = dbCtx.Where("is_quarantined = ? AND (expiry_date IS NULL OR expiry_date >= ?)", false, today())
+		}
+		// First expiry first out; lots without an expiry date go last
+		err := dbCtx.Order("expiry_date IS NULL, expiry_date, id").Find(&lots).Error
+		if err != nil {
+			return nil, 0, err
+		}
+
+		remaining := need
+		for _, lot := range lots {
+			if remaining <= 0 {
+				break
+			}
+			quantity := lot.Quantity
+			if quantity > remaining {
+				quantity = remaining
+			}
+			quantities = append(quantities, quantity)
+			remaining = roundQuantity(remaining - quantity)
+		}
+		lots = lots[:len(quantities)]
+		if remaining > 0 {
+			if !product.IsContinueSellingOutOfStock {
+				return nil, 0, ErrInsufficientStock
+			}
+			if len(lots) == 0 {
+				lot, err := newestSellableLot(tx, ctx, item)
+				if err != nil {
+					return nil, 0, err
+				}
+				lots = append(lots, lot)
+				quantities = append(quantities, 0)
+			}
+			quantities[len(quantities)-1] = roundQuantity(quantities[len(quantities)-1] + remaining)
+		}
+	}
+
+	quarantined := 0.0
+	allocations := make([]LotAllocation, 0, len(lots))
+	for i, lot := range lots {
+		if err := tx.WithContext(ctx).Model(lot).Update("Quantity", gorm.Expr("quantity - ?", quantities[i])).Error; err != nil {
+			return nil, 0, err
+		}
+		if lot.IsQuarantined {
+			quarantined -= quantities[i]
+		}
+		allocations = append(allocations, lot.allocation(-quantities[i]))
+	}
+	return allocations, quarantined, nil
+}
+
+func checkLotTotal(lots []LotAllocation, total float64) error {
+	sum := 0.0
+	for _, lot := range lots {
+		sum += lot.Quantity
+	}
+	if roundQuantity(sum) != roundQuantity(total) {
+		return errors.New("lot quantities must add up to the quantity")
+	}
+	return nil
+}
+
+func findOrCreateLot(tx *gorm.DB, ctx context.Context, item StockItem, allocation LotAllocation) (*StockLot, error) {
+
+	if allocation.LotId > 0 {
+		return itemLot(tx, ctx, item, allocation.LotId)
+	}
+
+	number := strings.TrimSpace(allocation.LotNumber)
+	if number == "" {
+		return nil, errors.New("lot number is required")
+	}
+	if allocation.ProductionDate != nil && allocation.ExpiryDate != nil && allocation.ExpiryDate.Before(*allocation.ProductionDate) {
+		return nil, errors.New("expiry date cannot be before the production date")
+	}
+
+	var lot StockLot
+	err := tx.WithContext(ctx).
+		Where("branch_id = ? AND product_id = ? AND product_variation_id = ? AND lot_number = ?", item.BranchId, item.ProductId, item.ProductVariationId, number).
+		Take(&lot).Error
+	if err == nil {
+		if allocation.ExpiryDate != nil && (lot.ExpiryDate == nil || !sameDay(*lot.ExpiryDate, *allocation.ExpiryDate)) {
+			return nil, errors.New("lot " + number + " already exists with a different expiry date")
+		}
+		return &lot, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	lot = StockLot{
+		BranchId:           item.BranchId,
+		ProductId:          item.ProductId,
+		ProductVariationId: item.ProductVariationId,
+		LotNumber:          number,
+		ProductionDate:     allocation.ProductionDate,
+		ExpiryDate:         allocation.ExpiryDate,
+	}
+	if err := tx.WithContext(ctx).Create(&lot).Error; err != nil {
+		return nil, err
+	}
+	return &lot, nil
+}
+
+func itemLot(tx *gorm.DB, ctx context.Context, item StockItem, id int) (*StockLot, error) {
+
+	var lot StockLot
+
+	err := tx.WithContext(ctx).
+		Where("branch_id = ? AND product_id = ? AND product_variation_id = ?", item.BranchId, item.ProductId, item.ProductVariationId).
+		First(&lot, id).Error
+	if err != nil {
+		return nil, errors.New("lot does not belong to this item")
+	}
+	return &lot, nil
+}
+
+func (lot *StockLot) allocation(quantity float64) LotAllocation {
+	return LotAllocation{
+		LotId:          lot.ID,
+		LotNumber:      lot.LotNumber,
+		ProductionDate: lot.ProductionDate,
+		ExpiryDate:     lot.ExpiryDate,
+		Quantity:       quantity,
+	}
+}
+
+func (lot *StockLot) isSellable() bool {
+	return !lot.IsQuarantined && (lot.ExpiryDate == nil || !lot.ExpiryDate.Before(today()))
+}
+
+func (lot *StockLot) stockItem() StockItem {
+	return StockItem{
+		BranchId:           lot.BranchId,
+		ProductId:          lot.ProductId,
+		ProductVariationId: lot.ProductVariationId,
+	}
+}
+
+func today() time.Time {
+	now := time.Now()
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+}
+
+func sameDay(a time.Time, b time.Time) bool {
+	return a.Format("2006-01-02") == b.Format("2006-01-02")
+}
+
+// checkNoUnlottedStock refuses to turn on lot tracking while the product has stock that sits in no lot.
+func checkNoUnlottedStock(tx *gorm.DB, ctx context.Context, productId int) error {
+
+	var count int64
+
+	err := tx.WithContext(ctx).Model(&StockLevel{}).Where("product_id = ? AND on_hand <> 0", productId).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return errors.New("lot tracking can only be enabled while the product has no stock on hand")
+	}
+	return nil
+}
+
+// checkNoLottedStock refuses to turn lot tracking off while any lot still holds stock, which
+// would leave it, and any quarantine on it, stranded.
+func checkNoLottedStock(tx *gorm.DB, ctx context.Context, productId int) error {
+
+	var count int64
+
+	err := tx.WithContext(ctx).Model(&StockLot{}).Where("product_id = ? AND quantity > 0", productId).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return errors.New("lot tracking can only be disabled while no lot holds stock")
+	}
+	return nil
+}
+
+// newestSellableLot is the lot an item sold out of stock is overdrawn from when no lot has any left.
+func newestSellableLot(tx *gorm.DB, ctx context.Context, item StockItem) (*StockLot, error) {
+
+	var lot StockLot
+
+	err := tx.WithContext(ctx).
+		Where("branch_id = ? AND product_id = ? AND product_variation_id = ?", item.BranchId, item.ProductId, item.ProductVariationId).
+		Where("is_quarantined = ? AND (expiry_date IS NULL OR expiry_date >= ?)", false, today()).
+		Order("id desc").
+		Take(&lot).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInsufficientStock
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &lot, nil
+}
+
+// sellableLotQuantity is how much of an item its sellable lots hold: expired lots, like
+// quarantined ones, still count as on hand but cannot be issued.
+func sellableLotQuantity(tx *gorm.DB, ctx context.Context, item StockItem) (float64, error) {
+
+	var quantity float64
+
+	err := tx.WithContext(ctx).Model(&StockLot{}).
+		Select("COALESCE(SUM(quantity), 0)").
+		Where("branch_id = ? AND product_id = ? AND product_variation_id = ?", item.BranchId, item.ProductId, item.ProductVariationId).
+		Where("is_quarantined = ? AND (expiry_date IS NULL OR expiry_date >= ?)", false, today()).
+		Scan(&quantity).Error
+	if err != nil {
+		return 0, err
+	}
+	return roundQuantity(quantity), nil
+}
+
+// newestLot is the lot found stock is booked into when no lot is given, or 0 for a product that
+// is not lot tracked.
+func newestLot(tx *gorm.DB, ctx context.Context, item StockItem) (int, error) {
+
+	var product Product
+	var lot StockLot
+
+	if err := tx.WithContext(ctx).Select("id", "is_lot_tracked").First(&product, item.ProductId).Error; err != nil {
+		return 0, err
+	}
+	if !product.IsLotTracked {
+		return 0, nil
+	}
+
+	err := tx.WithContext(ctx).
+		Where("branch_id = ? AND product_id = ? AND product_variation_id = ?", item.BranchId, item.ProductId, item.ProductVariationId).
+		Order("id desc").
+		Take(&lot).Error
+	if err != nil {
+		return 0, errLotsRequired
+	}
+	return lot.ID, nil
+}
+
+// QuarantineLot takes a lot out of sellable stock; its quantity stays on hand but no longer counts
+// as available.
+func QuarantineLot(ctx context.Context, scope *utils.BranchClaim, id int, reason string, actorId int) (*StockLot, error) {
+	return setLotQuarantine(ctx, scope, id, true, reason, actorId)
+}
+
+func ReleaseLot(ctx context.Context, scope *utils.BranchClaim, id int, actorId int) (*StockLot, error) {
+	return setLotQuarantine(ctx, scope, id, false, "", actorId)
+}
+
+func setLotQuarantine(ctx context.Context, scope *utils.BranchClaim, id int, quarantine bool, reason string, actorId int) (*StockLot, error) {
+
+	db := config.GetDB()
+	var lot StockLot
+
+	if quarantine && strings.TrimSpace(reason) == "" {
+		return nil, errors.New("a quarantine reason is required")
+	}
+
+	err := db.WithContext(ctx).Scopes(ScopeBranches(scope, "branch_id")).First(&lot, id).Error
+	if err != nil {
+		return nil, utils.ErrorRecordNotFound
+	}
+
+	tx := db.Begin()
+
+	// Lock the stock level first, as every other lot change does, then read the lot again under it
+	if _, err := lockStockLevel(tx, ctx, lot.stockItem()); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.WithContext(ctx).First(&lot, id).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if lot.IsQuarantined == quarantine {
+		tx.Rollback()
+		if quarantine {
+			return nil, errors.New("lot is already quarantined")
+		}
+		return nil, errors.New("lot is not quarantined")
+	}
+
+	change := StockChange{Quarantined: lot.Quantity}
+	if !quarantine {
+		change.Quarantined = -lot.Quantity
+	}
+	if _, err := applyStockChange(tx, ctx, lot.stockItem(), change); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	updates := map[string]interface{}{
+		"IsQuarantined":    quarantine,
+		"QuarantineReason": truncate(strings.TrimSpace(reason), 255),
+		"QuarantinedBy":    actorId,
+		"QuarantinedAt":    time.Now(),
+	}
+	if !quarantine {
+		updates["QuarantinedBy"] = 0
+		updates["QuarantinedAt"] = nil
+	}
+	if err := tx.WithContext(ctx).Model(&lot).Updates(updates).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	if err := db.WithContext(ctx).First(&lot, id).Error; err != nil {
+		return nil, err
+	}
+	return &lot, nil
+}
+
+func GetStockLots(ctx context.Context, scope *utils.BranchClaim, branchId *int, productId *int, includeEmpty bool) ([]*StockLot, error) {
+
+	db := config.GetDB()
+	var results []*StockLot
+
+	dbCtx := db.WithContext(ctx).Scopes(ScopeBranches(scope, "branch_id"))
+	if branchId != nil {
+		dbCtx = dbCtx.Where("branch_id = ?", *branchId)
+	}
+	if productId != nil {
+		dbCtx = dbCtx.Where("product_id = ?", *productId)
+	}
+	if !includeEmpty {
+		dbCtx = dbCtx.Where("quantity > 0")
+	}
+
+	err := dbCtx.Order("expiry_date IS NULL, expiry_date, id").Limit(1000).Find(&results).Error
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// GetExpiringStock lists the lots in stock that expire within days, including those already expired.
+func GetExpiringStock(ctx context.Context, scope *utils.BranchClaim, days int, branchId *int) ([]*StockLot, error) {
+
+	db := config.GetDB()
+	var results []*StockLot
+
+	if days < 0 {
+		return nil, errors.New("days cannot be negative")
+	}
+
+	dbCtx := db.WithContext(ctx).Scopes(ScopeBranches(scope, "branch_id")).
+		Where("quantity > 0 AND expiry_date IS NOT NULL AND expiry_date <= ?", today().AddDate(0, 0, days))
+	if branchId != nil {
+		dbCtx = dbCtx.Where("branch_id = ?", *branchId)
+	}
+
+	err := dbCtx.Order("expiry_date, branch_id, id").Limit(1000).Find(&results).Error
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
@@ -0,0 +1,306 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/config"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/utils"
+	"gorm.io/gorm"
+)
+
+// StockMovement is one append-only line of the stock ledger. Summing Quantity for an item
+// gives its on-hand balance; Balance is that sum right after the movement. Value and
+// ValueBalance do the same for the cost of the stock.
+type StockMovement struct {
+	ID                 int       `gorm:"primary_key" json:"id"`
+	BranchId           int       `gorm:"index:idx_stock_movement_item;not null" json:"branch_id"`
+	ProductId          int       `gorm:"index:idx_stock_movement_item;not null" json:"product_id"`
+	ProductVariationId int       `gorm:"index:idx_stock_movement_item;not null;default:0" json:"product_variation_id"`
+	LotId              int       `gorm:"index;not null;default:0" json:"lot_id"`
+	Quantity           float64   `gorm:"type:decimal(14,3);not null" json:"quantity"`
+	Balance            float64   `gorm:"type:decimal(14,3);not null" json:"balance"`
+	UnitCost           float64   `gorm:"type:decimal(14,4);not null;default:0" json:"unit_cost"`
+	Value              float64   `gorm:"type:decimal(16,2);not null;default:0" json:"value"`
+	ValueBalance       float64   `gorm:"type:decimal(16,2);not null;default:0" json:"value_balance"`
+	ReasonType         string    `gorm:"index;size:20;not null" json:"reason_type"`
+	ReferenceType      string    `gorm:"index:idx_stock_movement_reference;size:50" json:"reference_type"`
+	ReferenceId        int       `gorm:"index:idx_stock_movement_reference;not null;default:0" json:"reference_id"`
+	ActorId            int       `gorm:"index;not null;default:0" json:"actor_id"`
+	Note               string    `gorm:"size:255" json:"note"`
+	CreatedAt          time.Time `gorm:"index;autoCreateTime" json:"created_at"`
+}
+
+type StockMovementEdge struct {
+	Cursor string        `json:"cursor"`
+	Node   StockMovement `json:"node,omitempty"`
+}
+
+type StockMovementPagination struct {
+	Edges    []*StockMovementEdge `json:"edges"`
+	PageInfo *PageInfo            `json:"pageInfo"`
+}
+
+type StockMovementFilter struct {
+	BranchId           *int       `json:"branchId"`
+	ProductId          *int       `json:"productId"`
+	ProductVariationId *int       `json:"productVariationId"`
+	LotId              *int       `json:"lotId"`
+	ReasonType         *string    `json:"reasonType"`
+	ReferenceType      *string    `json:"referenceType"`
+	ReferenceId        *int       `json:"referenceId"`
+	ActorId            *int       `json:"actorId"`
+	From               *time.Time `json:"from"`
+	To                 *time.Time `json:"to"`
+}
+
+// StockDrift is a stock level whose on-hand quantity disagrees with its ledger.
+type StockDrift struct {
+	StockItem
+	OnHand       float64
+	LedgerOnHand float64
+}
+
+const (
+	StockReasonReceipt    = "receipt"
+	StockReasonSale       = "sale"
+	StockReasonTransfer   = "transfer"
+	StockReasonAdjustment = "adjustment"
+	StockReasonWaste      = "waste"
+	StockReasonProduction = "production"
+)
+
+var stockReasons = map[string]bool{
+	StockReasonReceipt:    true,
+	StockReasonSale:       true,
+	StockReasonTransfer:   true,
+	StockReasonAdjustment: true,
+	StockReasonWaste:      true,
+	StockReasonProduction: true,
+}
+
+var errStockMovementImmutable = errors.New("stock movements cannot be changed")
+
+// BeforeUpdate keeps the ledger append-only; corrections are new movements.
+func (movement *StockMovement) BeforeUpdate(tx *gorm.DB) error {
+	return errStockMovementImmutable
+}
+
+func (movement *StockMovement) BeforeDelete(tx *gorm.DB) error {
+	return errStockMovementImmutable
+}
+
+// recordStockMovement writes movement, which carries its quantities and values, with the item and
+// reference of change.
+func recordStockMovement(tx *gorm.DB, ctx context.Context, item StockItem, change StockChange, movement *StockMovement) error {
+
+	if !stockReasons[change.Reason] {
+		return errors.New("invalid stock movement reason")
+	}
+
+	movement.BranchId = item.BranchId
+	movement.ProductId = item.ProductId
+	movement.ProductVariationId = item.ProductVariationId
+	movement.Quantity = roundQuantity(movement.Quantity)
+	movement.ReasonType = change.Reason
+	movement.ReferenceType = change.ReferenceType
+	movement.ReferenceId = change.ReferenceId
+	movement.ActorId = change.ActorId
+	movement.Note = truncate(change.Note, 255)
+	return tx.WithContext(ctx).Create(movement).Error
+}
+
+func GetStockMovements(ctx context.Context, scope *utils.BranchClaim, filter *StockMovementFilter, first *int, after *string) (*StockMovementPagination, error) {
+
+	db := config.GetDB()
+	var results []StockMovement
+
+	limit := 20
+	if first != nil && *first > 0 && *first <= 100 {
+		limit = *first
+	}
+
+	decodedCursor, err := DecodeCursor(after)
+	if err != nil {
+		return nil, err
+	}
+
+	dbCtx := db.WithContext(ctx).Scopes(ScopeBranches(scope, "branch_id"))
+	if filter != nil {
+		if filter.BranchId != nil {
+			dbCtx = dbCtx.Where("branch_id = ?", *filter.BranchId)
+		}
+		if filter.ProductId != nil {
+			dbCtx = dbCtx.Where("product_id = ?", *filter.ProductId)
+		}
+		if filter.ProductVariationId != nil {
+			dbCtx = dbCtx.Where("product_variation_id = ?", *filter.ProductVariationId)
+		}
+		if filter.LotId != nil {
+			dbCtx = dbCtx.Where("lot_id = ?", *filter.LotId)
+		}
+		if filter.ReasonType != nil && len(*filter.ReasonType) > 0 {
+			dbCtx = dbCtx.Where("reason_type = ?", *filter.ReasonType)
+		}
+		if filter.ReferenceType != nil && len(*filter.ReferenceType) > 0 {
+			dbCtx = dbCtx.Where("reference_type = ?", *filter.ReferenceType)
+		}
+		if filter.ReferenceId != nil {
+			dbCtx = dbCtx.Where("reference_id = ?", *filter.ReferenceId)
+		}
+		if filter.ActorId != nil {
+			dbCtx = dbCtx.Where("actor_id = ?", *filter.ActorId)
+		}
+		if filter.From != nil {
+			dbCtx = dbCtx.Where("created_at >= ?", *filter.From)
+		}
+		if filter.To != nil {
+			dbCtx = dbCtx.Where("created_at < ?", *filter.To)
+		}
+	}
+	if decodedCursor != "" {
+		cursorId, err := strconv.Atoi(decodedCursor)
+		if err != nil {
+			return nil, err
+		}
+		dbCtx = dbCtx.Where("id < ?", cursorId)
+	}
+
+	// Newest movements first
+	err = dbCtx.Order("id desc").Limit(limit + 1).Find(&results).Error
+	if err != nil {
+		return nil, err
+	}
+
+	hasNextPage := len(results) > limit
+	if hasNextPage {
+		results = results[:limit]
+	}
+
+	edges := make([]*StockMovementEdge, len(results))
+	for i, result := range results {
+		edges[i] = &StockMovementEdge{
+			Cursor: EncodeCursor(strconv.Itoa(result.ID)),
+			Node:   result,
+		}
+	}
+
+	pageInfo := PageInfo{HasNextPage: &hasNextPage}
+	if len(edges) > 0 {
+		pageInfo.StartCursor = edges[0].Cursor
+		pageInfo.EndCursor = edges[len(edges)-1].Cursor
+	}
+
+	return &StockMovementPagination{
+		Edges:    edges,
+		PageInfo: &pageInfo,
+	}, nil
+}
+
+// RebuildStockBalances compares every on-hand balance with the sum of its ledger and returns the
+// items that differ. With fix set the on-hand balances, and the stock values with them, are
+// reset to the ledger.
+func RebuildStockBalances(ctx context.Context, fix bool) ([]StockDrift, error) {
+
+	db := config.GetDB()
+	var sums []struct {
+		StockItem
+		Quantity float64
+	}
+	var levels []StockLevel
+
+	err := db.WithContext(ctx).Model(&StockMovement{}).
+		Select("branch_id, product_id, product_variation_id, SUM(quantity) AS quantity").
+		Group("branch_id, product_id, product_variation_id").
+		Scan(&sums).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.WithContext(ctx).Find(&levels).Error; err != nil {
+		return nil, err
+	}
+
+	ledger := make(map[StockItem]float64, len(sums))
+	for _, sum := range sums {
+		ledger[sum.StockItem] = roundQuantity(sum.Quantity)
+	}
+
+	var drifts []StockDrift
+	for _, level := range levels {
+		item := StockItem{
+			BranchId:           level.BranchId,
+			ProductId:          level.ProductId,
+			ProductVariationId: level.ProductVariationId,
+		}
+		if roundQuantity(level.OnHand) != ledger[item] {
+			drifts = append(drifts, StockDrift{StockItem: item, OnHand: level.OnHand, LedgerOnHand: ledger[item]})
+		}
+		delete(ledger, item)
+	}
+	// Movements whose stock level row is missing altogether
+	for item, quantity := range ledger {
+		if quantity != 0 {
+			drifts = append(drifts, StockDrift{StockItem: item, LedgerOnHand: quantity})
+		}
+	}
+
+	if !fix {
+		return drifts, nil
+	}
+
+	for _, drift := range drifts {
+		tx := db.Begin()
+
+		level, err := lockStockLevel(tx, ctx, drift.StockItem)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+
+		// Movements are only written under this lock, so summing again here cannot miss one
+		var ledgerOnHand float64
+		err = tx.WithContext(ctx).Model(&StockMovement{}).
+			Where("branch_id = ? AND product_id = ? AND product_variation_id = ?", level.BranchId, level.ProductId, level.ProductVariationId).
+			Select("COALESCE(SUM(quantity), 0)").
+			Scan(&ledgerOnHand).Error
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+
+		// The value is not a plain sum, since stock on hand before costing was seeded into it, so
+		// it is taken from the value balance of the last movement
+		var valueBalances []float64
+		err = tx.WithContext(ctx).Model(&StockMovement{}).
+			Where("branch_id = ? AND product_id = ? AND product_variation_id = ?", level.BranchId, level.ProductId, level.ProductVariationId).
+			Order("id desc").
+			Limit(1).
+			Pluck("value_balance", &valueBalances).Error
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		ledgerValue := 0.0
+		if len(valueBalances) > 0 {
+			ledgerValue = valueBalances[0]
+		}
+
+		err = tx.WithContext(ctx).Model(level).Updates(map[string]interface{}{
+			"OnHand":    roundQuantity(ledgerOnHand),
+			"Available": roundQuantity(ledgerOnHand - level.Reserved - level.Quarantined),
+			"Value":     roundValue(ledgerValue),
+		}).Error
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+
+		if err := tx.Commit().Error; err != nil {
+			return nil, err
+		}
+	}
+	return drifts, nil
+}
@@ -219,3 +219,96 @@ func DeleteSupplier(ctx context.Context, id int) (*Supplier, error) {
 	}
 	return &result, nil
 }
+
+type SupplierLoginInfo struct {
+	Token      string `json:"token"`
+	SupplierId int    `json:"supplierId"`
+	Name       string `json:"name"`
+}
+
+// supplierLoginKey keeps supplier logins apart from staff usernames in the attempt log and lockouts.
+func supplierLoginKey(username string) string {
+	return "supplier:" + strings.ToLower(username)
+}
+
+// SupplierLogin authenticates a supplier by email or phone against the stored password. It is
+// throttled and locked out like staff logins, per username and per client IP.
+func SupplierLogin(ctx context.Context, username string, password string, client *ClientInfo) (*SupplierLoginInfo, error) {
+
+	db := config.GetDB()
+	var result SupplierLoginInfo
+
+	clientIp := ""
+	if client != nil {
+		clientIp = client.ClientIp
+	}
+	key := supplierLoginKey(username)
+
+	if err := throttleLogin(ctx, key, clientIp); err != nil {
+		return &result, err
+	}
+
+	s := Supplier{}
+
+	err := db.WithContext(ctx).Model(Supplier{}).Where("email = ? OR phone = ?", strings.ToLower(username), username).Take(&s).Error
+	if err == nil && s.Password == "" {
+		err = errInvalidLogin
+	}
+	if err == nil {
+		err = utils.ComparePassword(s.Password, password)
+	}
+	if err == nil && (s.IsActive == nil || !*s.IsActive) {
+		err = errInvalidLogin
+	}
+
+	if err != nil {
+		if err := recordFailedLogin(ctx, key, clientIp); err != nil {
+			return &result, err
+		}
+		return &result, errInvalidLogin
+	}
+
+	if err = recordLoginAttempt(ctx, key, clientIp, true); err != nil {
+		return &result, err
+	}
+
+	token, err := utils.JwtGenerateSupplier(s.ID)
+	if err != nil {
+		return &result, err
+	}
+
+	result.Token = token
+	result.SupplierId = s.ID
+	result.Name = s.Name
+
+	return &result, nil
+}
+
+func IsSupplierActive(ctx context.Context, id int) (bool, error) {
+
+	db := config.GetDB()
+	var count int64
+
+	err := db.WithContext(ctx).Model(&Supplier{}).Where("id = ? AND is_active = ?", id, true).Count(&count).Error
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func GetSupplierProducts(ctx context.Context, supplierId int) ([]*Product, error) {
+
+	db := config.GetDB()
+	var results []*Product
+
+	if err := db.WithContext(ctx).
+				Preload("Images").
+				Preload("Tags").
+				Where("supplier_id = ?", supplierId).
+				Order("title").
+				Find(&results).Error; err != nil {
+		return results, err
+	}
+
+	return results, nil
+}
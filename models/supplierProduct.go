@@ -0,0 +1,315 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/config"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/utils"
+	"gorm.io/gorm"
+)
+
+// SupplierProduct is an item in a supplier's catalog, with the supplier's own terms for it. A link
+// with ProductVariationId 0 covers every variation of the product; one set on a variation wins.
+// UnitCost and MinOrderQuantity are per UomId, which is 0 for the base unit.
+type SupplierProduct struct {
+	ID                 int       `gorm:"primary_key" json:"id"`
+	SupplierId         int       `gorm:"uniqueIndex:idx_supplier_product;index:idx_supplier_sku;not null" json:"supplier_id"`
+	ProductId          int       `gorm:"uniqueIndex:idx_supplier_product;index;not null" json:"product_id"`
+	ProductVariationId int       `gorm:"uniqueIndex:idx_supplier_product;not null;default:0" json:"product_variation_id"`
+	SupplierSku        string    `gorm:"index:idx_supplier_sku;size:100" json:"supplier_sku"`
+	UomId              int       `gorm:"not null;default:0" json:"uom_id"`
+	UnitCost           float64   `gorm:"type:decimal(14,4);not null;default:0" json:"unit_cost"`
+	MinOrderQuantity   float64   `gorm:"type:decimal(14,3);not null;default:0" json:"min_order_quantity"`
+	LeadTimeDays       int       `gorm:"not null;default:0" json:"lead_time_days"`
+	IsPreferred        bool      `gorm:"not null;default:false" json:"is_preferred"`
+	CreatedAt          time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt          time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+type NewSupplierProduct struct {
+	SupplierId         int     `json:"supplier_id" binding:"required"`
+	ProductId          int     `json:"product_id" binding:"required"`
+	ProductVariationId int     `json:"product_variation_id"`
+	SupplierSku        string  `json:"supplier_sku"`
+	UomId              int     `json:"uom_id"`
+	UnitCost           float64 `json:"unit_cost"`
+	MinOrderQuantity   float64 `json:"min_order_quantity"`
+	LeadTimeDays       int     `json:"lead_time_days"`
+	IsPreferred        bool    `json:"is_preferred"`
+}
+
+// SupplierPriceListLine is one row of a price list. It names the item, or leaves ProductId 0 to
+// update the catalog entry with the same SupplierSku; fields left nil keep their current value.
+type SupplierPriceListLine struct {
+	ProductId          int      `json:"product_id"`
+	ProductVariationId int      `json:"product_variation_id"`
+	SupplierSku        *string  `json:"supplier_sku"`
+	UomId              *int     `json:"uom_id"`
+	UnitCost           float64  `json:"unit_cost"`
+	MinOrderQuantity   *float64 `json:"min_order_quantity"`
+	LeadTimeDays       *int     `json:"lead_time_days"`
+	IsPreferred        *bool    `json:"is_preferred"`
+}
+
+// SetSupplierProduct adds the item to the supplier's catalog, or updates its terms if it is there.
+func SetSupplierProduct(ctx context.Context, input *NewSupplierProduct) (*SupplierProduct, error) {
+
+	db := config.GetDB()
+
+	if !utils.IsRecordValidByID(input.SupplierId, &Supplier{}, db) {
+		return nil, errors.New("invalid supplier id")
+	}
+
+	tx := db.Begin()
+
+	link, err := saveSupplierProduct(tx, ctx, input)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+func DeleteSupplierProduct(ctx context.Context, id int) (*SupplierProduct, error) {
+
+	db := config.GetDB()
+	var link SupplierProduct
+
+	if err := db.WithContext(ctx).First(&link, id).Error; err != nil {
+		return nil, utils.ErrorRecordNotFound
+	}
+	if err := db.WithContext(ctx).Delete(&link).Error; err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+// ImportSupplierPriceList applies a supplier's price list to its catalog in one transaction, so a
+// bad row leaves the catalog as it was. With replace set, items missing from the list are dropped.
+func ImportSupplierPriceList(ctx context.Context, supplierId int, lines []*SupplierPriceListLine, replace bool) ([]*SupplierProduct, error) {
+
+	db := config.GetDB()
+
+	if !utils.IsRecordValidByID(supplierId, &Supplier{}, db) {
+		return nil, errors.New("invalid supplier id")
+	}
+
+	tx := db.Begin()
+
+	keep := make([]int, 0, len(lines))
+	for _, line := range lines {
+		input := NewSupplierProduct{
+			SupplierId:         supplierId,
+			ProductId:          line.ProductId,
+			ProductVariationId: line.ProductVariationId,
+			UnitCost:           line.UnitCost,
+		}
+		if line.SupplierSku != nil {
+			input.SupplierSku = strings.TrimSpace(*line.SupplierSku)
+		}
+
+		if input.ProductId == 0 && input.SupplierSku == "" {
+			tx.Rollback()
+			return nil, errors.New("a price list line needs a product id or supplier sku")
+		}
+
+		var current SupplierProduct
+		err := supplierProductFor(tx, ctx, &input).Take(&current).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			tx.Rollback()
+			return nil, err
+		}
+		if input.ProductId == 0 {
+			if current.ID == 0 {
+				tx.Rollback()
+				return nil, errors.New("no catalog item with supplier sku " + input.SupplierSku)
+			}
+			input.ProductId = current.ProductId
+			input.ProductVariationId = current.ProductVariationId
+		}
+
+		if line.SupplierSku == nil {
+			input.SupplierSku = current.SupplierSku
+		}
+		input.UomId = current.UomId
+		if line.UomId != nil {
+			input.UomId = *line.UomId
+		}
+		input.MinOrderQuantity = current.MinOrderQuantity
+		if line.MinOrderQuantity != nil {
+			input.MinOrderQuantity = *line.MinOrderQuantity
+		}
+		input.LeadTimeDays = current.LeadTimeDays
+		if line.LeadTimeDays != nil {
+			input.LeadTimeDays = *line.LeadTimeDays
+		}
+		input.IsPreferred = current.IsPreferred
+		if line.IsPreferred != nil {
+			input.IsPreferred = *line.IsPreferred
+		}
+
+		link, err := saveSupplierProduct(tx, ctx, &input)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		keep = append(keep, link.ID)
+	}
+
+	if replace {
+		dbCtx := tx.WithContext(ctx).Where("supplier_id = ?", supplierId)
+		if len(keep) > 0 {
+			dbCtx = dbCtx.Where("id NOT IN ?", keep)
+		}
+		if err := dbCtx.Delete(&SupplierProduct{}).Error; err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return GetSupplierCatalog(ctx, supplierId)
+}
+
+// supplierProductFor finds the catalog entry input refers to: by item, or by supplier sku when
+// it gives no product.
+func supplierProductFor(tx *gorm.DB, ctx context.Context, input *NewSupplierProduct) *gorm.DB {
+	dbCtx := tx.WithContext(ctx).Where("supplier_id = ?", input.SupplierId)
+	if input.ProductId == 0 {
+		return dbCtx.Where("supplier_sku = ? AND supplier_sku <> ''", input.SupplierSku)
+	}
+	return dbCtx.Where("product_id = ? AND product_variation_id = ?", input.ProductId, input.ProductVariationId)
+}
+
+// saveSupplierProduct validates input and creates or updates its catalog entry. Marking it
+// preferred takes the flag off the item's other suppliers.
+func saveSupplierProduct(tx *gorm.DB, ctx context.Context, input *NewSupplierProduct) (*SupplierProduct, error) {
+
+	var link SupplierProduct
+
+	if err := validateSupplierProduct(tx, ctx, input); err != nil {
+		return nil, err
+	}
+
+	err := supplierProductFor(tx, ctx, input).Take(&link).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	link.SupplierId = input.SupplierId
+	link.ProductId = input.ProductId
+	link.ProductVariationId = input.ProductVariationId
+	link.SupplierSku = truncate(strings.TrimSpace(input.SupplierSku), 100)
+	link.UomId = input.UomId
+	link.UnitCost = roundUnitCost(input.UnitCost)
+	link.MinOrderQuantity = roundQuantity(input.MinOrderQuantity)
+	link.LeadTimeDays = input.LeadTimeDays
+	link.IsPreferred = input.IsPreferred
+
+	// Save writes the zero values too, such as a flag being cleared
+	if err := tx.WithContext(ctx).Save(&link).Error; err != nil {
+		return nil, err
+	}
+
+	if link.IsPreferred {
+		err := tx.WithContext(ctx).Model(&SupplierProduct{}).
+			Where("product_id = ? AND product_variation_id = ? AND id <> ?", link.ProductId, link.ProductVariationId, link.ID).
+			Update("IsPreferred", false).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &link, nil
+}
+
+func validateSupplierProduct(tx *gorm.DB, ctx context.Context, input *NewSupplierProduct) error {
+
+	if !utils.IsRecordValidByID(input.ProductId, &Product{}, tx) {
+		return errors.New("invalid product id")
+	}
+	if input.ProductVariationId > 0 {
+		var count int64
+		err := tx.WithContext(ctx).Model(&ProductVariation{}).
+			Where("id = ? AND product_id = ?", input.ProductVariationId, input.ProductId).
+			Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return errors.New("variation does not belong to product")
+		}
+	}
+	if _, err := unitFactor(tx, ctx, input.ProductId, input.ProductVariationId, input.UomId); err != nil {
+		return err
+	}
+	if input.UnitCost < 0 {
+		return errors.New("unit cost cannot be negative")
+	}
+	if input.MinOrderQuantity < 0 {
+		return errors.New("minimum order quantity cannot be negative")
+	}
+	if input.LeadTimeDays < 0 {
+		return errors.New("lead time cannot be negative")
+	}
+
+	// Another item of the supplier may not carry the same sku
+	sku := strings.TrimSpace(input.SupplierSku)
+	if sku != "" {
+		var count int64
+		err := tx.WithContext(ctx).Model(&SupplierProduct{}).
+			Where("supplier_id = ? AND supplier_sku = ?", input.SupplierId, sku).
+			Not("product_id = ? AND product_variation_id = ?", input.ProductId, input.ProductVariationId).
+			Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return errors.New("duplicate supplier sku")
+		}
+	}
+	return nil
+}
+
+// supplierUnitCost is the supplier's price for one base unit of the item, and false when the item
+// is not in its catalog.
+func supplierUnitCost(tx *gorm.DB, ctx context.Context, supplierId int, productId int, productVariationId int) (float64, bool, error) {
+
+	var links []*SupplierProduct
+
+	err := tx.WithContext(ctx).
+		Where("supplier_id = ? AND product_id = ? AND product_variation_id IN ?", supplierId, productId, []int{0, productVariationId}).
+		Order("product_variation_id desc").
+		Limit(1).
+		Find(&links).Error
+	if err != nil || len(links) == 0 {
+		return 0, false, err
+	}
+
+	factor, err := unitFactor(tx, ctx, productId, productVariationId, links[0].UomId)
+	if err != nil {
+		return 0, false, err
+	}
+	return links[0].UnitCost / factor, true, nil
+}
+
+// GetSupplierCatalog lists the items a supplier sells; loaders batch the same for the API.
+func GetSupplierCatalog(ctx context.Context, supplierId int) ([]*SupplierProduct, error) {
+
+	db := config.GetDB()
+	var results []*SupplierProduct
+
+	err := db.WithContext(ctx).Where("supplier_id = ?", supplierId).Order("product_id, product_variation_id").Find(&results).Error
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
@@ -0,0 +1,258 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/config"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/utils"
+	"gorm.io/gorm"
+)
+
+type TotpRecoveryCode struct {
+	ID        int        `gorm:"primary_key" json:"id"`
+	UserId    int        `gorm:"index;not null" json:"user_id"`
+	CodeHash  string     `gorm:"size:64;not null" json:"-"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `gorm:"autoCreateTime" json:"created_at"`
+}
+
+type TotpEnrollment struct {
+	Secret          string `json:"secret"`
+	ProvisioningUri string `json:"provisioningUri"`
+}
+
+const totpRecoveryCodeCount = 10
+
+var errInvalidTotpCode = errors.New("invalid two-factor code")
+
+func totpIssuer() string {
+	issuer := os.Getenv("TOTP_ISSUER")
+	if issuer == "" {
+		return "MKitchen"
+	}
+	return issuer
+}
+
+// EnrollTotp stores a new pending secret; it only takes effect once ConfirmTotp verifies a code.
+func EnrollTotp(ctx context.Context, userId int) (*TotpEnrollment, error) {
+
+	db := config.GetDB()
+	var user User
+
+	err := db.WithContext(ctx).First(&user, userId).Error
+	if err != nil {
+		return nil, utils.ErrorRecordNotFound
+	}
+
+	if user.IsTotpEnabled {
+		return nil, errors.New("two-factor authentication is already enabled")
+	}
+
+	secret, err := utils.GenerateTotpSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.WithContext(ctx).Model(&user).Update("TotpSecret", secret).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &TotpEnrollment{
+		Secret:          secret,
+		ProvisioningUri: utils.TotpProvisioningUri(totpIssuer(), user.Username, secret),
+	}, nil
+}
+
+// ConfirmTotp enables two-factor authentication and returns freshly generated recovery codes.
+func ConfirmTotp(ctx context.Context, userId int, code string) ([]string, error) {
+
+	db := config.GetDB()
+	var user User
+
+	err := db.WithContext(ctx).First(&user, userId).Error
+	if err != nil {
+		return nil, utils.ErrorRecordNotFound
+	}
+
+	if user.IsTotpEnabled {
+		return nil, errors.New("two-factor authentication is already enabled")
+	}
+	if user.TotpSecret == "" {
+		return nil, errors.New("two-factor enrollment has not been started")
+	}
+
+	step, ok := utils.ValidateTotp(user.TotpSecret, code, time.Now())
+	if !ok {
+		return nil, errInvalidTotpCode
+	}
+
+	tx := db.Begin()
+
+	err = tx.WithContext(ctx).Model(&user).Updates(map[string]interface{}{
+		"IsTotpEnabled":    true,
+		"TotpLastUsedStep": step,
+	}).Error
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	codes, err := createRecoveryCodes(tx, ctx, user.ID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func DisableTotp(ctx context.Context, userId int, code string) (bool, error) {
+
+	db := config.GetDB()
+	var user User
+
+	err := db.WithContext(ctx).First(&user, userId).Error
+	if err != nil {
+		return false, utils.ErrorRecordNotFound
+	}
+
+	if !user.IsTotpEnabled {
+		return false, errors.New("two-factor authentication is not enabled")
+	}
+
+	tx := db.Begin()
+
+	if err := verifyTotpOrRecoveryCode(tx, ctx, &user, code); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	err = tx.WithContext(ctx).Model(&user).Updates(map[string]interface{}{
+		"IsTotpEnabled":    false,
+		"TotpSecret":       "",
+		"TotpLastUsedStep": 0,
+	}).Error
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	if err := tx.WithContext(ctx).Where("user_id = ?", user.ID).Delete(&TotpRecoveryCode{}).Error; err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// VerifyLoginTotp finishes a login that stopped at the two-factor step.
+func VerifyLoginTotp(ctx context.Context, challengeToken string, code string, client *ClientInfo) (*LoginInfo, error) {
+
+	db := config.GetDB()
+	var user User
+	var result LoginInfo
+
+	userId, err := utils.JwtValidateTotpChallenge(challengeToken)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.WithContext(ctx).First(&user, userId).Error
+	if err != nil || user.IsActive == nil || !*user.IsActive || !user.IsTotpEnabled {
+		return nil, errInvalidLogin
+	}
+
+	clientIp := ""
+	if client != nil {
+		clientIp = client.ClientIp
+	}
+
+	if err := throttleLogin(ctx, user.Username, clientIp); err != nil {
+		return nil, err
+	}
+
+	tx := db.Begin()
+
+	if err := verifyTotpOrRecoveryCode(tx, ctx, &user, code); err != nil {
+		tx.Rollback()
+		if err := recordFailedLogin(ctx, user.Username, clientIp); err != nil {
+			return nil, err
+		}
+		return nil, err
+	}
+
+	if err := createSession(tx, ctx, &user, client, &result); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// verifyTotpOrRecoveryCode accepts either a current TOTP code, which may not be replayed,
+// or an unused recovery code, which is consumed.
+func verifyTotpOrRecoveryCode(tx *gorm.DB, ctx context.Context, user *User, code string) error {
+
+	if step, ok := utils.ValidateTotp(user.TotpSecret, code, time.Now()); ok {
+		result := tx.WithContext(ctx).Model(&User{}).
+			Where("id = ? AND totp_last_used_step < ?", user.ID, step).
+			Update("TotpLastUsedStep", step)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errInvalidTotpCode
+		}
+		return nil
+	}
+
+	result := tx.WithContext(ctx).Model(&TotpRecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, utils.HashToken(strings.ToLower(strings.TrimSpace(code)))).
+		Update("UsedAt", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errInvalidTotpCode
+	}
+	return nil
+}
+
+func createRecoveryCodes(tx *gorm.DB, ctx context.Context, userId int) ([]string, error) {
+
+	if err := tx.WithContext(ctx).Where("user_id = ?", userId).Delete(&TotpRecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, totpRecoveryCodeCount)
+	for i := 0; i < totpRecoveryCodeCount; i++ {
+		code, err := utils.GenerateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+
+		recoveryCode := TotpRecoveryCode{
+			UserId:   userId,
+			CodeHash: utils.HashToken(code),
+		}
+		if err := tx.WithContext(ctx).Create(&recoveryCode).Error; err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
@@ -0,0 +1,637 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/config"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type Transfer struct {
+	ID                  int            `gorm:"primary_key" json:"id"`
+	SourceBranchId      int            `gorm:"index;not null" json:"source_branch_id"`
+	DestinationBranchId int            `gorm:"index;not null" json:"destination_branch_id"`
+	Status              string         `gorm:"index;size:20;not null" json:"status"`
+	Note                string         `gorm:"size:255" json:"note"`
+	CreatedBy           int            `gorm:"not null;default:0" json:"created_by"`
+	DispatchedBy        int            `gorm:"not null;default:0" json:"dispatched_by"`
+	DispatchedAt        *time.Time     `json:"dispatched_at"`
+	ReceivedBy          int            `gorm:"not null;default:0" json:"received_by"`
+	ReceivedAt          *time.Time     `json:"received_at"`
+	Lines               []TransferLine `gorm:"foreignKey:TransferId" json:"lines"`
+	CreatedAt           time.Time      `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt           time.Time      `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// TransferLine tracks one item through the transfer. Whatever was dispatched but neither received
+// nor written off as a discrepancy is still in transit.
+type TransferLine struct {
+	ID                  int               `gorm:"primary_key" json:"id"`
+	TransferId          int               `gorm:"index;not null" json:"transfer_id"`
+	ProductId           int               `gorm:"not null" json:"product_id"`
+	ProductVariationId  int               `gorm:"not null;default:0" json:"product_variation_id"`
+	Quantity            float64           `gorm:"type:decimal(14,3);not null" json:"quantity"`
+	UomId               int               `gorm:"not null;default:0" json:"uom_id"`
+	UomQuantity         float64           `gorm:"type:decimal(14,3);not null;default:0" json:"uom_quantity"`
+	DispatchedQuantity  float64           `gorm:"type:decimal(14,3);not null;default:0" json:"dispatched_quantity"`
+	UnitCost            float64           `gorm:"type:decimal(14,4);not null;default:0" json:"unit_cost"`
+	ReceivedQuantity    float64           `gorm:"type:decimal(14,3);not null;default:0" json:"received_quantity"`
+	DiscrepancyQuantity float64           `gorm:"type:decimal(14,3);not null;default:0" json:"discrepancy_quantity"`
+	DiscrepancyReason   string            `gorm:"size:255" json:"discrepancy_reason"`
+	Lots                []TransferLineLot `gorm:"foreignKey:TransferLineId" json:"lots"`
+}
+
+// TransferLineLot is a lot dispatched on a lot-tracked line; the destination receives into a lot
+// with the same number and dates.
+type TransferLineLot struct {
+	ID               int        `gorm:"primary_key" json:"id"`
+	TransferLineId   int        `gorm:"index;not null" json:"transfer_line_id"`
+	LotNumber        string     `gorm:"size:100;not null" json:"lot_number"`
+	ProductionDate   *time.Time `gorm:"type:date" json:"production_date"`
+	ExpiryDate       *time.Time `gorm:"type:date" json:"expiry_date"`
+	Quantity         float64    `gorm:"type:decimal(14,3);not null" json:"quantity"`
+	ReceivedQuantity float64    `gorm:"type:decimal(14,3);not null;default:0" json:"received_quantity"`
+}
+
+type NewTransfer struct {
+	SourceBranchId      int                `json:"source_branch_id" binding:"required"`
+	DestinationBranchId int                `json:"destination_branch_id" binding:"required"`
+	Note                string             `json:"note"`
+	Lines               []*NewTransferLine `json:"lines" binding:"required,dive,required"`
+}
+
+type NewTransferLine struct {
+	ProductId          int     `json:"product_id" binding:"required"`
+	ProductVariationId int     `json:"product_variation_id"`
+	UomId              int     `json:"uom_id"`
+	Quantity           float64 `json:"quantity" binding:"required"`
+}
+
+// TransferLineQuantity is the quantity dispatched or received on one line; DiscrepancyReason
+// explains a shortfall when the transfer is closed.
+type TransferLineQuantity struct {
+	LineId            int     `json:"line_id" binding:"required"`
+	Quantity          float64 `json:"quantity"`
+	DiscrepancyReason string  `json:"discrepancy_reason"`
+}
+
+const (
+	TransferStatusDraft             = "draft"
+	TransferStatusDispatched        = "dispatched"
+	TransferStatusPartiallyReceived = "partially_received"
+	TransferStatusReceived          = "received"
+	TransferStatusCancelled         = "cancelled"
+)
+
+const transferReference = "transfer"
+
+// InTransitQuantity is what is still on the way for the line.
+func (line *TransferLine) InTransitQuantity() float64 {
+	return roundQuantity(line.DispatchedQuantity - line.ReceivedQuantity - line.DiscrepancyQuantity)
+}
+
+func (line *TransferLine) stockItem(branchId int) StockItem {
+	return StockItem{
+		BranchId:           branchId,
+		ProductId:          line.ProductId,
+		ProductVariationId: line.ProductVariationId,
+	}
+}
+
+func validateTransferInput(ctx context.Context, scope *utils.BranchClaim, input *NewTransfer) error {
+
+	db := config.GetDB()
+
+	if input.SourceBranchId == input.DestinationBranchId {
+		return errors.New("source and destination branch must differ")
+	}
+	if !utils.IsRecordValidByID(input.SourceBranchId, &Branch{}, db) || !utils.IsRecordValidByID(input.DestinationBranchId, &Branch{}, db) {
+		return errors.New("invalid branch id")
+	}
+	if scope == nil || !scope.CanAccessBranch(input.SourceBranchId) {
+		return errors.New("source branch is not accessible")
+	}
+	if len(input.Lines) == 0 {
+		return errors.New("a transfer needs at least one line")
+	}
+
+	seen := make(map[StockItem]bool, len(input.Lines))
+	for _, line := range input.Lines {
+		if line.Quantity <= 0 {
+			return errors.New("line quantity must be positive")
+		}
+		item := StockItem{ProductId: line.ProductId, ProductVariationId: line.ProductVariationId, BranchId: input.SourceBranchId}
+		if seen[item] {
+			return errors.New("duplicate transfer line")
+		}
+		seen[item] = true
+		if _, err := validateStockItem(db, ctx, item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func createTransferLines(tx *gorm.DB, ctx context.Context, transferId int, lines []*NewTransferLine) error {
+	for _, input := range lines {
+		quantity, err := toBaseQuantity(tx, ctx, input.ProductId, input.ProductVariationId, input.UomId, input.Quantity)
+		if err != nil {
+			return err
+		}
+		line := TransferLine{
+			TransferId:         transferId,
+			ProductId:          input.ProductId,
+			ProductVariationId: input.ProductVariationId,
+			Quantity:           roundQuantity(quantity),
+			UomId:              input.UomId,
+			UomQuantity:        roundQuantity(input.Quantity),
+		}
+		if err := tx.WithContext(ctx).Create(&line).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func CreateTransfer(ctx context.Context, scope *utils.BranchClaim, input *NewTransfer, createdBy int) (*Transfer, error) {
+
+	db := config.GetDB()
+
+	if err := validateTransferInput(ctx, scope, input); err != nil {
+		return nil, err
+	}
+
+	tx := db.Begin()
+
+	transfer := Transfer{
+		SourceBranchId:      input.SourceBranchId,
+		DestinationBranchId: input.DestinationBranchId,
+		Status:              TransferStatusDraft,
+		Note:                truncate(input.Note, 255),
+		CreatedBy:           createdBy,
+	}
+	if err := tx.WithContext(ctx).Create(&transfer).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := createTransferLines(tx, ctx, transfer.ID, input.Lines); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return GetTransfer(ctx, scope, transfer.ID)
+}
+
+// UpdateTransfer replaces the branches, note and lines of a draft transfer.
+func UpdateTransfer(ctx context.Context, scope *utils.BranchClaim, id int, input *NewTransfer) (*Transfer, error) {
+
+	db := config.GetDB()
+
+	if err := validateTransferInput(ctx, scope, input); err != nil {
+		return nil, err
+	}
+
+	tx := db.Begin()
+
+	transfer, err := lockTransfer(tx, ctx, scope, id)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if transfer.Status != TransferStatusDraft {
+		tx.Rollback()
+		return nil, errors.New("only draft transfers can be edited")
+	}
+
+	err = tx.WithContext(ctx).Model(transfer).Updates(map[string]interface{}{
+		"SourceBranchId":      input.SourceBranchId,
+		"DestinationBranchId": input.DestinationBranchId,
+		"Note":                truncate(input.Note, 255),
+	}).Error
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.WithContext(ctx).Where("transfer_id = ?", id).Delete(&TransferLine{}).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := createTransferLines(tx, ctx, id, input.Lines); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return GetTransfer(ctx, scope, id)
+}
+
+func CancelTransfer(ctx context.Context, scope *utils.BranchClaim, id int) (*Transfer, error) {
+
+	db := config.GetDB()
+
+	tx := db.Begin()
+
+	transfer, err := lockTransfer(tx, ctx, scope, id)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if transfer.Status != TransferStatusDraft {
+		tx.Rollback()
+		return nil, errors.New("only draft transfers can be cancelled")
+	}
+	if !scope.CanAccessBranch(transfer.SourceBranchId) {
+		tx.Rollback()
+		return nil, errors.New("source branch is not accessible")
+	}
+
+	if err := tx.WithContext(ctx).Model(transfer).Update("Status", TransferStatusCancelled).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return GetTransfer(ctx, scope, id)
+}
+
+// DispatchTransfer takes the goods out of the source branch and puts them in transit to the destination.
+// Lines not listed in quantities are dispatched in full; a listed quantity of zero leaves the line behind.
+func DispatchTransfer(ctx context.Context, scope *utils.BranchClaim, id int, quantities []*TransferLineQuantity, actorId int) (*Transfer, error) {
+
+	db := config.GetDB()
+
+	tx := db.Begin()
+
+	transfer, err := lockTransfer(tx, ctx, scope, id)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if transfer.Status != TransferStatusDraft {
+		tx.Rollback()
+		return nil, errors.New("only draft transfers can be dispatched")
+	}
+	if !scope.CanAccessBranch(transfer.SourceBranchId) {
+		tx.Rollback()
+		return nil, errors.New("source branch is not accessible")
+	}
+
+	byLine, err := transferLineQuantities(transfer, quantities)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	// Both branches' levels are locked up front, as a transfer the other way locks the same rows
+	items := make([]StockItem, 0, 2*len(transfer.Lines))
+	for i := range transfer.Lines {
+		line := &transfer.Lines[i]
+		items = append(items, line.stockItem(transfer.SourceBranchId), line.stockItem(transfer.DestinationBranchId))
+	}
+	if err := lockStockLevels(tx, ctx, items); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	dispatched := 0
+	for i := range transfer.Lines {
+		line := &transfer.Lines[i]
+
+		quantity := line.Quantity
+		if q, ok := byLine[line.ID]; ok {
+			quantity = roundQuantity(q.Quantity)
+		}
+		if quantity < 0 || quantity > line.Quantity {
+			tx.Rollback()
+			return nil, errors.New("dispatch quantity must be between zero and the line quantity")
+		}
+		if quantity == 0 {
+			continue
+		}
+
+		posting, err := postStockChange(tx, ctx, line.stockItem(transfer.SourceBranchId), StockChange{
+			OnHand:        -quantity,
+			Reason:        StockReasonTransfer,
+			ReferenceType: transferReference,
+			ReferenceId:   transfer.ID,
+			ActorId:       actorId,
+		})
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+
+		for _, allocation := range posting.Lots {
+			lineLot := TransferLineLot{
+				TransferLineId: line.ID,
+				LotNumber:      allocation.LotNumber,
+				ProductionDate: allocation.ProductionDate,
+				ExpiryDate:     allocation.ExpiryDate,
+				Quantity:       -allocation.Quantity,
+			}
+			if err := tx.WithContext(ctx).Create(&lineLot).Error; err != nil {
+				tx.Rollback()
+				return nil, err
+			}
+		}
+
+		_, err = applyStockChange(tx, ctx, line.stockItem(transfer.DestinationBranchId), StockChange{InTransit: quantity})
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+
+		// The destination takes the goods in at what they cost the source
+		err = tx.WithContext(ctx).Model(line).Updates(map[string]interface{}{
+			"DispatchedQuantity": quantity,
+			"UnitCost":           posting.UnitCost,
+		}).Error
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		dispatched++
+	}
+
+	if dispatched == 0 {
+		tx.Rollback()
+		return nil, errors.New("nothing to dispatch")
+	}
+
+	err = tx.WithContext(ctx).Model(transfer).Updates(map[string]interface{}{
+		"Status":       TransferStatusDispatched,
+		"DispatchedBy": actorId,
+		"DispatchedAt": time.Now(),
+	}).Error
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return GetTransfer(ctx, scope, id)
+}
+
+// ReceiveTransfer books goods into the destination branch. Receipts may come in several parts;
+// when close is set, whatever is still in transit is written off as a discrepancy, which then
+// needs a reason on its line.
+func ReceiveTransfer(ctx context.Context, scope *utils.BranchClaim, id int, quantities []*TransferLineQuantity, close bool, actorId int) (*Transfer, error) {
+
+	db := config.GetDB()
+
+	tx := db.Begin()
+
+	transfer, err := lockTransfer(tx, ctx, scope, id)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if transfer.Status != TransferStatusDispatched && transfer.Status != TransferStatusPartiallyReceived {
+		tx.Rollback()
+		return nil, errors.New("only dispatched transfers can be received")
+	}
+	if !scope.CanAccessBranch(transfer.DestinationBranchId) {
+		tx.Rollback()
+		return nil, errors.New("destination branch is not accessible")
+	}
+
+	byLine, err := transferLineQuantities(transfer, quantities)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	outstanding := 0.0
+	changed := false
+	for i := range transfer.Lines {
+		line := &transfer.Lines[i]
+		item := line.stockItem(transfer.DestinationBranchId)
+
+		quantity := 0.0
+		reason := ""
+		if q, ok := byLine[line.ID]; ok {
+			quantity = roundQuantity(q.Quantity)
+			reason = q.DiscrepancyReason
+		}
+		if quantity < 0 || quantity > line.InTransitQuantity() {
+			tx.Rollback()
+			return nil, errors.New("received quantity cannot exceed the quantity in transit")
+		}
+
+		if quantity > 0 {
+			lots, err := receiveTransferLineLots(tx, ctx, line, quantity)
+			if err != nil {
+				tx.Rollback()
+				return nil, err
+			}
+			_, err = applyStockChange(tx, ctx, item, StockChange{
+				OnHand:        quantity,
+				InTransit:     -quantity,
+				Lots:          lots,
+				UnitCost:      &line.UnitCost,
+				Reason:        StockReasonTransfer,
+				ReferenceType: transferReference,
+				ReferenceId:   transfer.ID,
+				ActorId:       actorId,
+			})
+			if err != nil {
+				tx.Rollback()
+				return nil, err
+			}
+			line.ReceivedQuantity = roundQuantity(line.ReceivedQuantity + quantity)
+			changed = true
+		}
+
+		updates := map[string]interface{}{"ReceivedQuantity": line.ReceivedQuantity}
+
+		if short := line.InTransitQuantity(); short > 0 && close {
+			if reason == "" {
+				tx.Rollback()
+				return nil, errors.New("a discrepancy reason is required for every short line")
+			}
+			// The goods already left the source, so a shortfall only clears the in-transit bucket
+			if _, err := applyStockChange(tx, ctx, item, StockChange{InTransit: -short}); err != nil {
+				tx.Rollback()
+				return nil, err
+			}
+			line.DiscrepancyQuantity = roundQuantity(line.DiscrepancyQuantity + short)
+			updates["DiscrepancyQuantity"] = line.DiscrepancyQuantity
+			updates["DiscrepancyReason"] = truncate(reason, 255)
+			changed = true
+		}
+
+		if err := tx.WithContext(ctx).Model(line).Updates(updates).Error; err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		outstanding += line.InTransitQuantity()
+	}
+
+	if !changed {
+		tx.Rollback()
+		return nil, errors.New("nothing to receive")
+	}
+
+	updates := map[string]interface{}{"Status": TransferStatusPartiallyReceived}
+	if outstanding == 0 {
+		updates["Status"] = TransferStatusReceived
+		updates["ReceivedBy"] = actorId
+		updates["ReceivedAt"] = time.Now()
+	}
+	if err := tx.WithContext(ctx).Model(transfer).Updates(updates).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return GetTransfer(ctx, scope, id)
+}
+
+// receiveTransferLineLots takes quantity from the lots dispatched on line, earliest expiry first, and
+// returns the lots to receive it into. Lines of products that are not lot tracked have no lots.
+func receiveTransferLineLots(tx *gorm.DB, ctx context.Context, line *TransferLine, quantity float64) ([]LotAllocation, error) {
+
+	var lineLots []TransferLineLot
+
+	err := tx.WithContext(ctx).Where("transfer_line_id = ?", line.ID).Order("expiry_date IS NULL, expiry_date, id").Find(&lineLots).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var lots []LotAllocation
+	remaining := quantity
+	for i := range lineLots {
+		lineLot := &lineLots[i]
+		open := roundQuantity(lineLot.Quantity - lineLot.ReceivedQuantity)
+		if remaining <= 0 || open <= 0 {
+			continue
+		}
+		take := open
+		if take > remaining {
+			take = remaining
+		}
+		if err := tx.WithContext(ctx).Model(lineLot).Update("ReceivedQuantity", roundQuantity(lineLot.ReceivedQuantity+take)).Error; err != nil {
+			return nil, err
+		}
+		lots = append(lots, LotAllocation{
+			LotNumber:      lineLot.LotNumber,
+			ProductionDate: lineLot.ProductionDate,
+			ExpiryDate:     lineLot.ExpiryDate,
+			Quantity:       take,
+		})
+		remaining = roundQuantity(remaining - take)
+	}
+	return lots, nil
+}
+
+// lockTransfer loads a transfer visible to scope with its lines, holding a row lock on the transfer
+// so two status changes cannot run at once. Lines are sorted so stock rows are always locked in the same order.
+func lockTransfer(tx *gorm.DB, ctx context.Context, scope *utils.BranchClaim, id int) (*Transfer, error) {
+
+	var transfer Transfer
+
+	err := tx.WithContext(ctx).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Scopes(scopeTransfers(scope)).
+		First(&transfer, id).Error
+	if err != nil {
+		return nil, utils.ErrorRecordNotFound
+	}
+
+	err = tx.WithContext(ctx).Where("transfer_id = ?", id).Find(&transfer.Lines).Error
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(transfer.Lines, func(i, j int) bool {
+		a, b := transfer.Lines[i], transfer.Lines[j]
+		if a.ProductId != b.ProductId {
+			return a.ProductId < b.ProductId
+		}
+		return a.ProductVariationId < b.ProductVariationId
+	})
+	return &transfer, nil
+}
+
+func transferLineQuantities(transfer *Transfer, quantities []*TransferLineQuantity) (map[int]*TransferLineQuantity, error) {
+
+	lineIds := make(map[int]bool, len(transfer.Lines))
+	for _, line := range transfer.Lines {
+		lineIds[line.ID] = true
+	}
+
+	byLine := make(map[int]*TransferLineQuantity, len(quantities))
+	for _, q := range quantities {
+		if !lineIds[q.LineId] {
+			return nil, errors.New("line does not belong to transfer")
+		}
+		if _, ok := byLine[q.LineId]; ok {
+			return nil, errors.New("duplicate transfer line")
+		}
+		byLine[q.LineId] = q
+	}
+	return byLine, nil
+}
+
+// scopeTransfers shows a transfer to callers who can see either end of it.
+func scopeTransfers(scope *utils.BranchClaim) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if scope != nil && scope.AllBranches {
+			return db
+		}
+		if scope == nil || len(scope.BranchIds) == 0 {
+			return db.Where("1 = 0")
+		}
+		return db.Where("source_branch_id IN ? OR destination_branch_id IN ?", scope.BranchIds, scope.BranchIds)
+	}
+}
+
+func GetTransfer(ctx context.Context, scope *utils.BranchClaim, id int) (*Transfer, error) {
+
+	db := config.GetDB()
+	var transfer Transfer
+
+	err := db.WithContext(ctx).Scopes(scopeTransfers(scope)).Preload("Lines.Lots").First(&transfer, id).Error
+	if err != nil {
+		return nil, utils.ErrorRecordNotFound
+	}
+	return &transfer, nil
+}
+
+func GetTransfers(ctx context.Context, scope *utils.BranchClaim, status *string, branchId *int) ([]*Transfer, error) {
+
+	db := config.GetDB()
+	var results []*Transfer
+
+	dbCtx := db.WithContext(ctx).Scopes(scopeTransfers(scope))
+	if status != nil && len(*status) > 0 {
+		dbCtx = dbCtx.Where("status = ?", *status)
+	}
+	if branchId != nil {
+		dbCtx = dbCtx.Where("source_branch_id = ? OR destination_branch_id = ?", *branchId, *branchId)
+	}
+
+	err := dbCtx.Preload("Lines.Lots").Order("created_at desc").Limit(200).Find(&results).Error
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
@@ -0,0 +1,388 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/config"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/utils"
+	"gorm.io/gorm"
+)
+
+// UnitOfMeasure is a unit from the shared catalog, such as piece, kilogram or carton.
+type UnitOfMeasure struct {
+	ID        int       `gorm:"primary_key" json:"id"`
+	Code      string    `gorm:"size:20;not null;uniqueIndex" json:"code"`
+	Name      string    `gorm:"size:100;not null" json:"name"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+type NewUnitOfMeasure struct {
+	Code string `json:"code" binding:"required"`
+	Name string `json:"name" binding:"required"`
+}
+
+// ProductUnit is a packaging level of a product: Factor base units make one of it. A unit set on
+// a variation applies to that variation only, otherwise to the whole product. Barcode identifies
+// the package, so scanning it counts Factor base units.
+type ProductUnit struct {
+	ID                 int       `gorm:"primary_key" json:"id"`
+	ProductId          int       `gorm:"uniqueIndex:idx_product_unit;not null" json:"product_id"`
+	ProductVariationId int       `gorm:"uniqueIndex:idx_product_unit;not null;default:0" json:"product_variation_id"`
+	UomId              int       `gorm:"uniqueIndex:idx_product_unit;not null" json:"uom_id"`
+	Factor             float64   `gorm:"type:decimal(14,6);not null" json:"factor"`
+	Barcode            *string   `gorm:"size:100;uniqueIndex" json:"barcode"`
+	IsPurchaseDefault  bool      `gorm:"not null;default:false" json:"is_purchase_default"`
+	IsSalesDefault     bool      `gorm:"not null;default:false" json:"is_sales_default"`
+	CreatedAt          time.Time `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt          time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+type NewProductUnit struct {
+	ProductVariationId int     `json:"product_variation_id"`
+	UomId              int     `json:"uom_id" binding:"required"`
+	Factor             float64 `json:"factor" binding:"required"`
+	Barcode            string  `json:"barcode"`
+	IsPurchaseDefault  bool    `json:"is_purchase_default"`
+	IsSalesDefault     bool    `json:"is_sales_default"`
+}
+
+// ProductUnits is the base unit of a product, in which all stock is kept, and its other units.
+type ProductUnits struct {
+	BaseUomId int               `json:"base_uom_id" binding:"required"`
+	Units     []*NewProductUnit `json:"units"`
+}
+
+func CreateUnitOfMeasure(ctx context.Context, input *NewUnitOfMeasure) (*UnitOfMeasure, error) {
+
+	db := config.GetDB()
+	var count int64
+
+	code := strings.ToLower(strings.TrimSpace(input.Code))
+	if code == "" || strings.TrimSpace(input.Name) == "" {
+		return nil, errors.New("code and name are required")
+	}
+
+	err := db.WithContext(ctx).Model(&UnitOfMeasure{}).Where("code = ?", code).Count(&count).Error
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, errors.New("duplicate code")
+	}
+
+	uom := UnitOfMeasure{
+		Code: code,
+		Name: strings.TrimSpace(input.Name),
+	}
+	if err := db.WithContext(ctx).Create(&uom).Error; err != nil {
+		return nil, err
+	}
+	return &uom, nil
+}
+
+func UpdateUnitOfMeasure(ctx context.Context, id int, input *NewUnitOfMeasure) (*UnitOfMeasure, error) {
+
+	db := config.GetDB()
+	var uom UnitOfMeasure
+	var count int64
+
+	err := db.WithContext(ctx).First(&uom, id).Error
+	if err != nil {
+		return nil, utils.ErrorRecordNotFound
+	}
+
+	code := strings.ToLower(strings.TrimSpace(input.Code))
+	if code == "" || strings.TrimSpace(input.Name) == "" {
+		return nil, errors.New("code and name are required")
+	}
+
+	err = db.WithContext(ctx).Model(&UnitOfMeasure{}).
+		Where("code = ?", code).
+		Not("id = ?", id).
+		Count(&count).Error
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, errors.New("duplicate code")
+	}
+
+	err = db.WithContext(ctx).Model(&uom).Updates(map[string]interface{}{
+		"Code": code,
+		"Name": strings.TrimSpace(input.Name),
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+	return &uom, nil
+}
+
+func DeleteUnitOfMeasure(ctx context.Context, id int) (*UnitOfMeasure, error) {
+
+	db := config.GetDB()
+	var uom UnitOfMeasure
+	var count int64
+
+	err := db.WithContext(ctx).First(&uom, id).Error
+	if err != nil {
+		return nil, utils.ErrorRecordNotFound
+	}
+
+	err = db.WithContext(ctx).Model(&ProductUnit{}).Where("uom_id = ?", id).Count(&count).Error
+	if err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		err = db.WithContext(ctx).Model(&Product{}).Where("base_uom_id = ?", id).Count(&count).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+	if count > 0 {
+		return nil, errors.New("unit of measure is in use")
+	}
+
+	if err := db.WithContext(ctx).Delete(&uom).Error; err != nil {
+		return nil, err
+	}
+	return &uom, nil
+}
+
+func GetUnitOfMeasure(ctx context.Context, id int) (*UnitOfMeasure, error) {
+
+	db := config.GetDB()
+	var uom UnitOfMeasure
+
+	err := db.WithContext(ctx).First(&uom, id).Error
+	if err != nil {
+		return nil, utils.ErrorRecordNotFound
+	}
+	return &uom, nil
+}
+
+func GetUnitsOfMeasure(ctx context.Context) ([]*UnitOfMeasure, error) {
+
+	db := config.GetDB()
+	var results []*UnitOfMeasure
+
+	err := db.WithContext(ctx).Order("name").Find(&results).Error
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// SetProductUnits replaces the base unit and packaging units of a product. The base unit can only
+// change while the product has no stock, since stored quantities are in it.
+func SetProductUnits(ctx context.Context, productId int, input *ProductUnits) ([]*ProductUnit, error) {
+
+	db := config.GetDB()
+	var product Product
+
+	err := db.WithContext(ctx).First(&product, productId).Error
+	if err != nil {
+		return nil, utils.ErrorRecordNotFound
+	}
+
+	if !utils.IsRecordValidByID(input.BaseUomId, &UnitOfMeasure{}, db) {
+		return nil, errors.New("invalid base unit id")
+	}
+
+	type unitKey struct{ variationId, uomId int }
+	seen := make(map[unitKey]bool, len(input.Units))
+	purchaseDefaults := make(map[int]int)
+	salesDefaults := make(map[int]int)
+	for _, unit := range input.Units {
+		if unit.UomId == input.BaseUomId {
+			return nil, errors.New("the base unit cannot also be a packaging unit")
+		}
+		if !utils.IsRecordValidByID(unit.UomId, &UnitOfMeasure{}, db) {
+			return nil, errors.New("invalid unit id")
+		}
+		if unit.Factor <= 0 {
+			return nil, errors.New("conversion factor must be positive")
+		}
+		key := unitKey{unit.ProductVariationId, unit.UomId}
+		if seen[key] {
+			return nil, errors.New("duplicate unit")
+		}
+		seen[key] = true
+		if unit.ProductVariationId > 0 {
+			var count int64
+			err := db.WithContext(ctx).Model(&ProductVariation{}).
+				Where("id = ? AND product_id = ?", unit.ProductVariationId, productId).
+				Count(&count).Error
+			if err != nil {
+				return nil, err
+			}
+			if count == 0 {
+				return nil, errors.New("variation does not belong to product")
+			}
+		}
+		if unit.IsPurchaseDefault {
+			purchaseDefaults[unit.ProductVariationId]++
+		}
+		if unit.IsSalesDefault {
+			salesDefaults[unit.ProductVariationId]++
+		}
+	}
+	for key := range seen {
+		if purchaseDefaults[key.variationId] > 1 || salesDefaults[key.variationId] > 1 {
+			return nil, errors.New("only one default purchase and sales unit is allowed")
+		}
+	}
+
+	tx := db.Begin()
+
+	if product.BaseUomId != input.BaseUomId {
+		if product.BaseUomId > 0 {
+			hasStock, err := productHasStock(tx, ctx, productId)
+			if err != nil {
+				tx.Rollback()
+				return nil, err
+			}
+			if hasStock {
+				tx.Rollback()
+				return nil, errors.New("the base unit can only change while the product has no stock")
+			}
+		}
+		if err := tx.WithContext(ctx).Model(&product).Update("BaseUomId", input.BaseUomId).Error; err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	if err := tx.WithContext(ctx).Where("product_id = ?", productId).Delete(&ProductUnit{}).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	for _, input := range input.Units {
+		unit := ProductUnit{
+			ProductId:          productId,
+			ProductVariationId: input.ProductVariationId,
+			UomId:              input.UomId,
+			Factor:             input.Factor,
+			IsPurchaseDefault:  input.IsPurchaseDefault,
+			IsSalesDefault:     input.IsSalesDefault,
+		}
+		if barcode := strings.TrimSpace(input.Barcode); barcode != "" {
+			if err := checkBarcodeFree(tx, ctx, barcode); err != nil {
+				tx.Rollback()
+				return nil, err
+			}
+			unit.Barcode = &barcode
+		}
+		if err := tx.WithContext(ctx).Create(&unit).Error; err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return GetProductUnits(ctx, productId)
+}
+
+func GetProductUnits(ctx context.Context, productId int) ([]*ProductUnit, error) {
+
+	db := config.GetDB()
+	var results []*ProductUnit
+
+	err := db.WithContext(ctx).Where("product_id = ?", productId).Order("product_variation_id, factor").Find(&results).Error
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// checkBarcodeFree keeps a packaging barcode from shadowing a product or variation barcode,
+// which scans look up first.
+func checkBarcodeFree(tx *gorm.DB, ctx context.Context, barcode string) error {
+
+	var count int64
+
+	if err := tx.WithContext(ctx).Model(&ProductVariation{}).Where("barcode = ?", barcode).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		if err := tx.WithContext(ctx).Model(&Product{}).Where("barcode = ?", barcode).Count(&count).Error; err != nil {
+			return err
+		}
+	}
+	if count > 0 {
+		return errors.New("barcode " + barcode + " is already used by a product")
+	}
+	return nil
+}
+
+// toBaseQuantity converts quantity in uomId to the base unit of the item. A unit of 0 or the base
+// unit itself means the quantity is already in base units.
+func toBaseQuantity(tx *gorm.DB, ctx context.Context, productId int, productVariationId int, uomId int, quantity float64) (float64, error) {
+	factor, err := unitFactor(tx, ctx, productId, productVariationId, uomId)
+	if err != nil {
+		return 0, err
+	}
+	return roundQuantity(quantity * factor), nil
+}
+
+// unitFactor is the number of base units of the item in one uomId.
+func unitFactor(tx *gorm.DB, ctx context.Context, productId int, productVariationId int, uomId int) (float64, error) {
+
+	if uomId == 0 {
+		return 1, nil
+	}
+
+	var product Product
+	var unit ProductUnit
+
+	if err := tx.WithContext(ctx).Select("id", "base_uom_id").First(&product, productId).Error; err != nil {
+		return 0, errors.New("invalid product id")
+	}
+	if uomId == product.BaseUomId {
+		return 1, nil
+	}
+
+	// A unit set on the variation wins over one set on the product
+	err := tx.WithContext(ctx).
+		Where("product_id = ? AND uom_id = ? AND product_variation_id IN ?", productId, uomId, []int{0, productVariationId}).
+		Order("product_variation_id desc").
+		Take(&unit).Error
+	if err != nil {
+		return 0, errors.New("unit is not set up for this product")
+	}
+	return unit.Factor, nil
+}
+
+// purchaseUnit is the unit the item is bought in by default, or nil to buy in the base unit.
+func purchaseUnit(tx *gorm.DB, ctx context.Context, productId int, productVariationId int) (*ProductUnit, error) {
+
+	var units []*ProductUnit
+
+	err := tx.WithContext(ctx).
+		Where("product_id = ? AND is_purchase_default = ? AND product_variation_id IN ?", productId, true, []int{0, productVariationId}).
+		Order("product_variation_id desc").
+		Limit(1).
+		Find(&units).Error
+	if err != nil || len(units) == 0 {
+		return nil, err
+	}
+	return units[0], nil
+}
+
+// unitByBarcode finds the packaging unit a barcode belongs to.
+func unitByBarcode(tx *gorm.DB, ctx context.Context, barcode string) (*ProductUnit, error) {
+
+	var unit ProductUnit
+
+	err := tx.WithContext(ctx).Where("barcode = ?", barcode).Take(&unit).Error
+	if err != nil {
+		return nil, err
+	}
+	return &unit, nil
+}
@@ -9,7 +9,7 @@ import (
 
 	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/config"
 	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/utils"
-	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
 type User struct {
@@ -20,6 +20,9 @@ type User struct {
 	Password  string    `gorm:"size:100;not null" json:"password"`
 	IsActive  *bool     `gorm:"not null;default:false" json:"is_active"`
 	RoleId     int       `gorm:"not null;default:0" json:"role_id" binding:"required"`
+	TotpSecret       string `gorm:"size:64;default:null" json:"-"`
+	IsTotpEnabled    bool   `gorm:"not null;default:false" json:"is_totp_enabled"`
+	TotpLastUsedStep int64  `gorm:"not null;default:0" json:"-"`
 	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
 	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated_at"`
 }
@@ -31,49 +34,73 @@ type NewUser struct {
 	Password string `json:"password" binding:"required"`
 	IsActive *bool  `json:"is_active" binding:"required"`
 	RoleId    int   `json:"role_id" binding:"required"`
+	BranchIds []int `json:"branch_ids"`
 }
 
 type LoginInfo struct {
-	Token    string `json:"token"`
-	UserId   int    `json:"userId"`
-	Username string `json:"username"`
-	Name     string `json:"name"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refreshToken"`
+	UserId       int    `json:"userId"`
+	Username     string `json:"username"`
+	Name         string `json:"name"`
 	// Role     string `json:"role"`
+	BranchId       int    `json:"branchId"`
+	TotpRequired   bool   `json:"totpRequired"`
+	ChallengeToken string `json:"challengeToken"`
 }
 
 func (result *User) PrepareGive() {
 	result.Password = ""
+	result.TotpSecret = ""
 }
 
-func Login(ctx context.Context, username string, password string) (*LoginInfo, error) {
+func Login(ctx context.Context, username string, password string, client *ClientInfo) (*LoginInfo, error) {
 
 	db := config.GetDB()
 	var err error
 	var result LoginInfo
 
+	clientIp := ""
+	if client != nil {
+		clientIp = client.ClientIp
+	}
+
+	if err = throttleLogin(ctx, username, clientIp); err != nil {
+		return &result, err
+	}
+
 	u := User{}
 
 	err = db.WithContext(ctx).Model(User{}).Where("username = ?", username).Take(&u).Error
+	if err == nil {
+		err = utils.ComparePassword(u.Password, password)
+	}
+	if err == nil && (u.IsActive == nil || !*u.IsActive) {
+		err = errInvalidLogin
+	}
 
 	if err != nil {
-		return &result, errors.New("invalid username or password")
+		if err := recordFailedLogin(ctx, username, clientIp); err != nil {
+			return &result, err
+		}
+		return &result, errInvalidLogin
 	}
-	err = utils.ComparePassword(u.Password, password)
 
-	if err != nil && err == bcrypt.ErrMismatchedHashAndPassword {
-		return &result, errors.New("invalid username or password")
+	if err = recordLoginAttempt(ctx, username, clientIp, true); err != nil {
+		return &result, err
 	}
 
-	isActive := *u.IsActive
-	if !isActive {
-		return &result, errors.New("user is disabled")
+	// Users with two-factor enabled get a challenge token instead of a session
+	if u.IsTotpEnabled {
+		result.TotpRequired = true
+		result.ChallengeToken, err = utils.JwtGenerateTotpChallenge(u.ID)
+		result.UserId = u.ID
+		result.Username = u.Username
+		result.Name = u.Name
+		return &result, err
 	}
-	token, err := utils.JwtGenerate(u.ID)
-	result.Token = token
-	result.Name = u.Name
-	result.UserId = u.ID
-	result.Username = u.Username
 
+	err = createSession(db, ctx, &u, client, &result)
 	if err != nil {
 		return &result, err
 	}
@@ -91,33 +118,68 @@ func GetAllUsers(ctx context.Context) ([]*User, error) {
 	}
 
 	for i, u := range results {
-		u.Password = ""
+		u.PrepareGive()
 		results[i] = u
 	}
 
 	return results, nil
 }
 
-func CreateUser(ctx context.Context, input *NewUser) (*User, error) {
+// CreateUser adds an account with a role that is within the creator's own.
+func CreateUser(ctx context.Context, input *NewUser, actorId int, roleId int) (*User, error) {
 
 	db := config.GetDB()
+
+	if !utils.IsRecordValidByID(input.RoleId, &Role{}, db) {
+		return &User{}, errors.New("invalid role id")
+	}
+	ceiling, err := actorCeiling(db, ctx, actorId, roleId)
+	if err != nil {
+		return &User{}, err
+	}
+	if err := ceiling.checkRole(db, ctx, input.RoleId); err != nil {
+		return &User{}, err
+	}
+
+	tx := db.Begin()
+
+	user, err := insertUser(tx, ctx, input)
+	if err != nil {
+		tx.Rollback()
+		return &User{}, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return &User{}, err
+	}
+	user.Password = ""
+	return user, nil
+}
+
+// insertUser validates input and creates the user with its branches inside tx.
+func insertUser(tx *gorm.DB, ctx context.Context, input *NewUser) (*User, error) {
+
 	var count int64
 
 	if input.Email != "" && !utils.IsValidEmail(input.Email) {
-		return &User{}, errors.New("invalid email address")
+		return nil, errors.New("invalid email address")
 	}
 
-	err := db.WithContext(ctx).Model(&User{}).Where("username = ?", input.Username).Or("email = ?", input.Email).Count(&count).Error
+	err := tx.WithContext(ctx).Model(&User{}).Where("username = ?", input.Username).Or("email = ?", input.Email).Count(&count).Error
 	if err != nil {
-		return &User{}, err
+		return nil, err
 	}
 	if count > 0 {
-		return &User{}, errors.New("duplicate username or email")
+		return nil, errors.New("duplicate username or email")
+	}
+
+	if err := utils.ValidatePassword(input.Password); err != nil {
+		return nil, err
 	}
 
 	hashedPassword, err := utils.HashPassword(input.Password)
 	if err != nil {
-		return &User{}, err
+		return nil, err
 	}
 
 	user := User{
@@ -129,11 +191,18 @@ func CreateUser(ctx context.Context, input *NewUser) (*User, error) {
 		RoleId: input.RoleId,
 	}
 
-	err = db.WithContext(ctx).Create(&user).Error
+	if err := validateBranchIds(tx, ctx, input.BranchIds); err != nil {
+		return nil, err
+	}
+
+	err = tx.WithContext(ctx).Create(&user).Error
 	if err != nil {
-		return &User{}, err
+		return nil, err
+	}
+
+	if err := replaceUserBranches(tx, ctx, user.ID, input.BranchIds); err != nil {
+		return nil, err
 	}
-	user.Password = ""
 	return &user, nil
 }
 
@@ -153,70 +222,296 @@ func GetUser(ctx context.Context, id int) (*User, error) {
 	return &result, nil
 }
 
-func (input *User) UpdateUser(id int) (*User, error) {
+type UpdateUserInput struct {
+	Username string `json:"username" binding:"required"`
+	Name     string `json:"name" binding:"required"`
+	Email    string `json:"email"`
+	IsActive *bool  `json:"is_active" binding:"required"`
+	RoleId   int    `json:"role_id" binding:"required"`
+}
+
+// UpdateUser edits an account. The actor must hold every permission of both the user's current
+// role and the new one, and cannot change their own role.
+func UpdateUser(ctx context.Context, id int, input *UpdateUserInput, actorId int, roleId int) (*User, error) {
 
 	db := config.GetDB()
-	var count int64
+	var current User
 
-	err := db.Model(&User{}).Where("id = ?", id).Count(&count).Error
+	err := db.WithContext(ctx).Select("id", "role_id").First(&current, id).Error
 	if err != nil {
-		return &User{}, err
+		return nil, utils.ErrorRecordNotFound
+	}
+
+	username := html.EscapeString(strings.TrimSpace(input.Username))
+	email := strings.ToLower(strings.TrimSpace(input.Email))
+
+	if username == "" || strings.TrimSpace(input.Name) == "" {
+		return nil, errors.New("username and name are required")
+	}
+	if email != "" && !utils.IsValidEmail(email) {
+		return nil, errors.New("invalid email address")
+	}
+
+	if !utils.IsRecordValidByID(input.RoleId, &Role{}, db) {
+		return nil, errors.New("invalid role id")
+	}
+	if id == actorId && input.RoleId != current.RoleId {
+		return nil, errors.New("you cannot change your own role")
+	}
+
+	ceiling, err := actorCeiling(db, ctx, actorId, roleId)
+	if err != nil {
+		return nil, err
+	}
+	if err := ceiling.checkRole(db, ctx, current.RoleId); err != nil {
+		return nil, err
+	}
+	if err := ceiling.checkRole(db, ctx, input.RoleId); err != nil {
+		return nil, err
+	}
+
+	if err := checkDuplicateUser(ctx, id, username, email); err != nil {
+		return nil, err
+	}
+
+	tx := db.Begin()
+
+	err = tx.WithContext(ctx).Model(&User{ID: id}).Updates(map[string]interface{}{
+		"Name":     strings.TrimSpace(input.Name),
+		"Email":    nullableString(email),
+		"Username": username,
+		"IsActive": input.IsActive != nil && *input.IsActive,
+		"RoleId":   input.RoleId,
+	}).Error
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	// A disabled user loses every open session straight away
+	if input.IsActive == nil || !*input.IsActive {
+		if err := revokeUserSessions(tx, ctx, id); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
 	}
-	if count <= 0 {
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	return GetUser(ctx, id)
+}
+
+func DeleteUser(ctx context.Context, id int) (*User, error) {
+
+	db := config.GetDB()
+	var user User
+
+	err := db.WithContext(ctx).First(&user, id).Error
+	if err != nil {
 		return nil, utils.ErrorRecordNotFound
 	}
 
-	if err = db.Model(&User{}).
-		Where("username = ? OR email = ?", input.Username, input.Email).
-		Not("id = ?", id).
-		Count(&count).Error; err != nil {
+	tx := db.Begin()
+
+	if err := tx.WithContext(ctx).Where("user_id = ?", id).Delete(&UserBranches{}).Error; err != nil {
+		tx.Rollback()
 		return nil, err
 	}
-	if count > 0 {
-		return &User{}, errors.New("duplicate email or username")
+
+	if err := revokeUserSessions(tx, ctx, id); err != nil {
+		tx.Rollback()
+		return nil, err
 	}
 
-	err = db.Model(&input).Updates(User{Name: input.Name, Email: input.Email, Username: input.Username, IsActive: input.IsActive, RoleId: input.RoleId}).Error
+	err = tx.WithContext(ctx).Delete(&user).Error
 	if err != nil {
-		return &User{}, err
+		tx.Rollback()
+		return nil, err
 	}
-	return input, nil
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	user.PrepareGive()
+	return &user, nil
+}
+
+type UpdateProfileInput struct {
+	Name  string `json:"name" binding:"required"`
+	Email string `json:"email"`
 }
 
-func (input *User) DeleteUser(id int) (*User, error) {
+func UpdateMyProfile(ctx context.Context, userId int, input *UpdateProfileInput) (*User, error) {
 
 	db := config.GetDB()
+	var user User
 
-	err := db.Model(&User{}).Where("id = ?", id).First(&input).Error
+	err := db.WithContext(ctx).First(&user, userId).Error
 	if err != nil {
 		return nil, utils.ErrorRecordNotFound
 	}
 
-	err = db.Delete(&input).Error
+	name := strings.TrimSpace(input.Name)
+	if name == "" {
+		return nil, errors.New("name is required")
+	}
+
+	email := strings.ToLower(strings.TrimSpace(input.Email))
+	if email != "" && !utils.IsValidEmail(email) {
+		return nil, errors.New("invalid email address")
+	}
+
+	if err := checkDuplicateUser(ctx, userId, user.Username, email); err != nil {
+		return nil, err
+	}
+
+	err = db.WithContext(ctx).Model(&user).Updates(map[string]interface{}{
+		"Name":  name,
+		"Email": nullableString(email),
+	}).Error
 	if err != nil {
-		return &User{}, err
+		return nil, err
 	}
-	return input, nil
+
+	return GetUser(ctx, userId)
 }
 
-func (input *User) ChangeUserPassword() (*User, error) {
+func SetUserActive(ctx context.Context, id int, isActive bool) (*User, error) {
 
 	db := config.GetDB()
-	//turn password into hash
-	hashedPassword, err := utils.HashPassword(input.Password)
+	var user User
+
+	err := db.WithContext(ctx).First(&user, id).Error
 	if err != nil {
-		return &User{}, err
+		return nil, utils.ErrorRecordNotFound
+	}
+
+	tx := db.Begin()
+
+	err = tx.WithContext(ctx).Model(&user).Update("IsActive", isActive).Error
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	// A disabled user loses every open session straight away
+	if !isActive {
+		if err := revokeUserSessions(tx, ctx, id); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	user.PrepareGive()
+	return &user, nil
+}
+
+// checkDuplicateUser rejects a username or email already used by another user.
+func checkDuplicateUser(ctx context.Context, id int, username string, email string) error {
+
+	db := config.GetDB()
+	var count int64
+
+	dbCtx := db.WithContext(ctx).Model(&User{}).Not("id = ?", id)
+	if email != "" {
+		dbCtx = dbCtx.Where("username = ? OR email = ?", username, email)
+	} else {
+		dbCtx = dbCtx.Where("username = ?", username)
 	}
-	input.Password = string(hashedPassword)
 
-	err = db.Model(&User{}).Where("id = ?", input.ID).First(&input).Error
+	if err := dbCtx.Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return errors.New("duplicate email or username")
+	}
+	return nil
+}
+
+// nullableString stores an empty string as NULL so the unique index on optional columns holds.
+func nullableString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func (input *User) ChangeUserPassword() (*User, error) {
+
+	db := config.GetDB()
+	var user User
+
+	err := db.Model(&User{}).Where("id = ?", input.ID).First(&user).Error
 	if err != nil {
 		return nil, utils.ErrorRecordNotFound
 	}
 
-	err = db.Model(&input).Updates(User{Password: input.Password}).Error
+	if err := setUserPassword(db, context.Background(), &user, input.Password); err != nil {
+		return nil, err
+	}
+	user.PrepareGive()
+	return &user, nil
+}
+
+func ChangePassword(ctx context.Context, userId int, sessionId int, oldPassword string, newPassword string) (bool, error) {
+
+	db := config.GetDB()
+	var user User
+
+	err := db.WithContext(ctx).First(&user, userId).Error
+	if err != nil {
+		return false, utils.ErrorRecordNotFound
+	}
+
+	if err := utils.ComparePassword(user.Password, oldPassword); err != nil {
+		return false, errors.New("old password is incorrect")
+	}
+
+	tx := db.Begin()
+
+	if err := setUserPassword(tx, ctx, &user, newPassword); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	// Sign out every other device, keeping the session that made the change
+	err = tx.WithContext(ctx).Model(&Session{}).
+		Where("user_id = ? AND id <> ? AND revoked_at IS NULL", userId, sessionId).
+		Update("RevokedAt", time.Now()).Error
 	if err != nil {
-		return &User{}, err
+		tx.Rollback()
+		return false, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// setUserPassword checks newPassword against the password policy and stores its hash.
+func setUserPassword(tx *gorm.DB, ctx context.Context, user *User, newPassword string) error {
+
+	if err := utils.ValidatePassword(newPassword); err != nil {
+		return err
 	}
-	return input, nil
+
+	if utils.ComparePassword(user.Password, newPassword) == nil {
+		return errors.New("new password must be different from the current password")
+	}
+
+	hashedPassword, err := utils.HashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+	user.Password = string(hashedPassword)
+
+	return tx.WithContext(ctx).Model(&User{}).Where("id = ?", user.ID).Update("Password", user.Password).Error
 }
@@ -0,0 +1,139 @@
+package models
+
+import (
+	"context"
+	"errors"
+
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/config"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/utils"
+	"gorm.io/gorm"
+)
+
+type UserBranches struct {
+	UserID   int `gorm:"primaryKey"`
+	BranchID int `gorm:"primaryKey;index"`
+}
+
+// ScopeBranches limits a query to the branches visible under the claim; a nil claim sees nothing.
+func ScopeBranches(claim *utils.BranchClaim, column string) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if claim != nil && claim.AllBranches {
+			return db
+		}
+		if claim == nil || len(claim.BranchIds) == 0 {
+			return db.Where("1 = 0")
+		}
+		return db.Where(column+" IN ?", claim.BranchIds)
+	}
+}
+
+// userBranchClaim builds the branch claim of a user, keeping activeBranchId when the user may still use it.
+func userBranchClaim(tx *gorm.DB, ctx context.Context, userId int, activeBranchId int) (utils.BranchClaim, error) {
+
+	var claim utils.BranchClaim
+
+	err := tx.WithContext(ctx).Model(&UserBranches{}).
+		Where("user_id = ?", userId).
+		Order("branch_id").
+		Pluck("branch_id", &claim.BranchIds).Error
+	if err != nil {
+		return claim, err
+	}
+
+	claim.AllBranches, err = HasPermission(ctx, userId, "branch", "access_all")
+	if err != nil {
+		return claim, err
+	}
+
+	if activeBranchId > 0 && claim.CanAccessBranch(activeBranchId) {
+		claim.BranchId = activeBranchId
+	} else if len(claim.BranchIds) > 0 {
+		claim.BranchId = claim.BranchIds[0]
+	}
+	return claim, nil
+}
+
+func validateBranchIds(tx *gorm.DB, ctx context.Context, branchIds []int) error {
+
+	if len(branchIds) == 0 {
+		return nil
+	}
+
+	var count int64
+	err := tx.WithContext(ctx).Model(&Branch{}).Where("id IN ?", branchIds).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if int(count) != len(uniqueInts(branchIds)) {
+		return errors.New("invalid branch id")
+	}
+	return nil
+}
+
+func replaceUserBranches(tx *gorm.DB, ctx context.Context, userId int, branchIds []int) error {
+
+	if err := tx.WithContext(ctx).Where("user_id = ?", userId).Delete(&UserBranches{}).Error; err != nil {
+		return err
+	}
+
+	for _, branchId := range uniqueInts(branchIds) {
+		userBranch := UserBranches{
+			UserID:   userId,
+			BranchID: branchId,
+		}
+		if err := tx.WithContext(ctx).Create(&userBranch).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func SetUserBranches(ctx context.Context, userId int, branchIds []int) (*User, error) {
+
+	db := config.GetDB()
+	var user User
+
+	err := db.WithContext(ctx).First(&user, userId).Error
+	if err != nil {
+		return nil, utils.ErrorRecordNotFound
+	}
+
+	if err := validateBranchIds(db, ctx, branchIds); err != nil {
+		return nil, err
+	}
+
+	tx := db.Begin()
+
+	if err := replaceUserBranches(tx, ctx, userId, branchIds); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	user.PrepareGive()
+	return &user, nil
+}
+
+func uniqueInts(values []int) []int {
+	seen := make(map[int]bool, len(values))
+	var results []int
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			results = append(results, v)
+		}
+	}
+	return results
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -23,6 +23,22 @@ func seedUser(db *gorm.DB) {
 		return
 	}
 
+	// SuperAdmin gets every permission
+	var roleModules []models.RoleModule
+	for _, p := range models.AllPermissions() {
+		roleModules = append(roleModules, models.RoleModule{
+			RoleId: roles[0].ID,
+			Module: p.Module,
+			Action: p.Action,
+		})
+	}
+
+	err = db.Create(&roleModules).Error
+	if err != nil {
+		fmt.Println("Error seeding role modules: " + err.Error())
+		return
+	}
+
 	// Seed Users
 	hashedPassword, err := utils.HashPassword("admin123")
 	if err != nil {
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
@@ -15,6 +16,7 @@ import (
 	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/graph"
 	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/middlewares"
 	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/models"
+	"github.com/myanmarmarathon/mkitchen-distribution-backend-graphql/utils"
 	"github.com/ravilushqa/otelgqlgen"
 	"go.opentelemetry.io/otel"
 )
@@ -32,9 +34,14 @@ func graphqlHandler() gin.HandlerFunc {
 		Tracer: tracer,
 	}}
 	c.Directives.Auth = directives.Auth
+	c.Directives.HasPermission = directives.HasPermission
+	c.Directives.SupplierAuth = directives.SupplierAuth
+	c.Directives.UserAuth = directives.UserAuth
 
 	h := handler.NewDefaultServer(graph.NewExecutableSchema(c))
 	h.Use(otelgqlgen.Middleware())
+	h.Use(middlewares.AuditExtension{})
+	h.SetErrorPresenter(middlewares.ErrorPresenter)
 	return func(c *gin.Context) {
 		h.ServeHTTP(c.Writer, c.Request)
 	}
@@ -49,6 +56,12 @@ func playgroundHandler() gin.HandlerFunc {
 	}
 }
 
+// Publishes the public signing keys so other services can verify our tokens
+func jwksHandler(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, utils.Jwks())
+}
+
 func customNotFoundHandler(c *gin.Context) {
 	c.JSON(http.StatusNotFound, gin.H{"error": "route not found"})
 }
@@ -67,6 +80,20 @@ func main() {
 	sqlDB, _ := db.DB()
 	defer sqlDB.Close()
 	models.MigrateTable()
+	if err := models.RegisterAuditCallbacks(db); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := utils.CheckJwtSecret(); err != nil {
+		log.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := models.LoadKeyring(ctx); err != nil {
+		log.Fatal(err)
+	}
+	go utils.StartKeyringRefresh(ctx, utils.KeyringRefreshInterval(), models.LoadSigningKeys)
+	go utils.RunEvery(ctx, utils.LowStockCheckInterval(), "check low stock", models.CheckLowStock)
+	go utils.RunEvery(ctx, utils.ReservationSweepInterval(), "expire reservations", models.ExpireReservations)
 
 	
 
@@ -82,13 +109,15 @@ func main() {
 
 	config := cors.DefaultConfig()
 	config.AllowAllOrigins = true
-	config.AddAllowHeaders("Authorization")
+	config.AddAllowHeaders("Authorization", "X-Api-Key")
 
 	r.Use(cors.New(config))
+	r.Use(middlewares.ClientInfoMiddleware())
 	r.Use(middlewares.AuthMiddleware())
 	r.Use(middlewares.LoaderMiddleware())
 	r.POST("/query", graphqlHandler())
 	r.GET("/", playgroundHandler())
+	r.GET("/.well-known/jwks.json", jwksHandler)
 	r.NoRoute(customNotFoundHandler)
 	r.Run(":" + port)
 
@@ -0,0 +1,248 @@
+package utils
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+const rsaKeyBits = 2048
+
+// KeyMaterial is a stored signing key as handed to the keyring.
+type KeyMaterial struct {
+	Kid        string
+	Algorithm  string
+	PrivateKey string // PKCS #8 PEM
+	IsActive   bool
+}
+
+type signingKey struct {
+	kid     string
+	method  jwt.SigningMethod
+	private crypto.Signer
+}
+
+type keyring struct {
+	keys   map[string]*signingKey
+	active *signingKey
+}
+
+var (
+	keyringMu      sync.RWMutex
+	currentKeyring = &keyring{keys: map[string]*signingKey{}}
+)
+
+type Jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+type JwkSet struct {
+	Keys []Jwk `json:"keys"`
+}
+
+func signingMethod(algorithm string) (jwt.SigningMethod, error) {
+	switch algorithm {
+	case AlgorithmRS256:
+		return jwt.SigningMethodRS256, nil
+	case AlgorithmEdDSA:
+		return jwt.SigningMethodEdDSA, nil
+	}
+	return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+}
+
+// GenerateKeyMaterial creates a new key pair for algorithm with a random kid.
+func GenerateKeyMaterial(algorithm string) (*KeyMaterial, error) {
+
+	var private crypto.Signer
+	var err error
+
+	switch algorithm {
+	case AlgorithmRS256:
+		private, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	case AlgorithmEdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+
+	kid := make([]byte, 8)
+	if _, err := rand.Read(kid); err != nil {
+		return nil, err
+	}
+
+	return &KeyMaterial{
+		Kid:        hex.EncodeToString(kid),
+		Algorithm:  algorithm,
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+	}, nil
+}
+
+func parseKeyMaterial(material KeyMaterial) (*signingKey, error) {
+
+	method, err := signingMethod(material.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode([]byte(material.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("key %s is not PEM encoded", material.Kid)
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	var private crypto.Signer
+	switch key := parsed.(type) {
+	case *rsa.PrivateKey:
+		if material.Algorithm == AlgorithmRS256 {
+			private = key
+		}
+	case ed25519.PrivateKey:
+		if material.Algorithm == AlgorithmEdDSA {
+			private = key
+		}
+	}
+	if private == nil {
+		return nil, fmt.Errorf("key %s does not match algorithm %s", material.Kid, material.Algorithm)
+	}
+
+	return &signingKey{
+		kid:     material.Kid,
+		method:  method,
+		private: private,
+	}, nil
+}
+
+// SetKeyring replaces the keys used to sign and verify tokens. Without an active key tokens
+// are signed with the HMAC secret as before.
+func SetKeyring(keys []KeyMaterial) error {
+
+	next := &keyring{keys: make(map[string]*signingKey, len(keys))}
+
+	for _, material := range keys {
+		key, err := parseKeyMaterial(material)
+		if err != nil {
+			return err
+		}
+		next.keys[key.kid] = key
+
+		if material.IsActive {
+			if next.active != nil {
+				return errors.New("more than one active signing key")
+			}
+			next.active = key
+		}
+	}
+
+	keyringMu.Lock()
+	currentKeyring = next
+	keyringMu.Unlock()
+	return nil
+}
+
+// KeyringRefreshInterval is how often the keyring is reloaded, from JWT_KEY_RELOAD_MINUTES.
+func KeyringRefreshInterval() time.Duration {
+	minutes, err := getLifespan("JWT_KEY_RELOAD_MINUTES", 5)
+	if err != nil || minutes <= 0 {
+		minutes = 5
+	}
+	return time.Minute * time.Duration(minutes)
+}
+
+// StartKeyringRefresh reloads the keyring every interval so keys rotated by another process
+// are picked up, until ctx is cancelled.
+func StartKeyringRefresh(ctx context.Context, interval time.Duration, load func(ctx context.Context) ([]KeyMaterial, error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			keys, err := load(ctx)
+			if err == nil {
+				err = SetKeyring(keys)
+			}
+			if err != nil {
+				log.Printf("reload signing keys: %v", err)
+			}
+		}
+	}
+}
+
+func activeSigningKey() *signingKey {
+	keyringMu.RLock()
+	defer keyringMu.RUnlock()
+	return currentKeyring.active
+}
+
+func lookupSigningKey(kid string) *signingKey {
+	keyringMu.RLock()
+	defer keyringMu.RUnlock()
+	return currentKeyring.keys[kid]
+}
+
+// Jwks returns the public half of every key that may still verify a token.
+func Jwks() JwkSet {
+	keyringMu.RLock()
+	defer keyringMu.RUnlock()
+
+	set := JwkSet{Keys: make([]Jwk, 0, len(currentKeyring.keys))}
+	for _, key := range currentKeyring.keys {
+		jwk := Jwk{
+			Kid: key.kid,
+			Use: "sig",
+			Alg: key.method.Alg(),
+		}
+		switch public := key.private.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
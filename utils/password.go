@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const MinPasswordLength = 8
+
+// commonPasswords are rejected outright regardless of length.
+var commonPasswords = map[string]bool{
+	"12345678": true, "123456789": true, "1234567890": true, "11111111": true,
+	"00000000": true, "87654321": true, "12341234": true, "11223344": true,
+	"password": true, "password1": true, "password123": true, "passw0rd": true,
+	"p@ssw0rd": true, "qwerty123": true, "qwertyuiop": true, "1q2w3e4r": true,
+	"1qaz2wsx": true, "zaq12wsx": true, "asdfghjk": true, "abc12345": true,
+	"abcd1234": true, "iloveyou": true, "sunshine": true, "princess": true,
+	"football": true, "baseball": true, "welcome1": true, "welcome123": true,
+	"letmein1": true, "trustno1": true, "superman": true, "starwars": true,
+	"dragon123": true, "monkey123": true, "admin123": true, "admin1234": true,
+	"administrator": true, "changeme": true, "default1": true, "mkitchen": true,
+	"mkitchen123": true, "myanmar123": true, "yangon123": true, "secret123": true,
+}
+
+// ValidatePassword applies the password policy shared by every flow that sets a password.
+func ValidatePassword(password string) error {
+	if len(password) < MinPasswordLength {
+		return fmt.Errorf("password must be at least %d characters long", MinPasswordLength)
+	}
+	if strings.TrimSpace(password) == "" {
+		return errors.New("password must not be blank")
+	}
+	if commonPasswords[strings.ToLower(password)] {
+		return errors.New("password is too common")
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// LowStockCheckInterval is how often reorder points are checked, from LOW_STOCK_CHECK_MINUTES.
+func LowStockCheckInterval() time.Duration {
+	minutes, err := getLifespan("LOW_STOCK_CHECK_MINUTES", 15)
+	if err != nil || minutes <= 0 {
+		minutes = 15
+	}
+	return time.Minute * time.Duration(minutes)
+}
+
+// RunEvery runs task every interval until ctx is cancelled. Failures are logged and the task
+// runs again on the next tick.
+func RunEvery(ctx context.Context, interval time.Duration, name string, task func(ctx context.Context) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := task(ctx); err != nil {
+				log.Printf("%s: %v", name, err)
+			}
+		}
+	}
+}
+
+// ReservationSweepInterval is how often expired reservations are released, from RESERVATION_SWEEP_MINUTES.
+func ReservationSweepInterval() time.Duration {
+	minutes, err := getLifespan("RESERVATION_SWEEP_MINUTES", 5)
+	if err != nil || minutes <= 0 {
+		minutes = 5
+	}
+	return time.Minute * time.Duration(minutes)
+}
+
+// ReservationLifespan is how long a reservation holds stock by default, from RESERVATION_MINUTE_LIFESPAN.
+// Zero holds stock until the reservation is released.
+func ReservationLifespan() time.Duration {
+	minutes, err := getLifespan("RESERVATION_MINUTE_LIFESPAN", 1440)
+	if err != nil || minutes < 0 {
+		minutes = 1440
+	}
+	return time.Minute * time.Duration(minutes)
+}
@@ -1,44 +1,233 @@
 package utils
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"os"
 	"strconv"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
+	"github.com/golang-jwt/jwt/v4"
 )
 
 type JwtCustomClaim struct {
-	ID   int    `json:"id"`
-	Role string `json:"role"`
+	ID          int    `json:"id"`
+	Role        string `json:"role"`
+	SessionId   int    `json:"sid"`
+	SubjectType string `json:"typ"`
+	ApiKeyId    int    `json:"akid,omitempty"`
+	RoleId      int    `json:"rid,omitempty"`
+	BranchClaim
 	jwt.StandardClaims
 }
 
+// BranchClaim carries the caller's active branch and the branches whose data it may see.
+type BranchClaim struct {
+	BranchId    int   `json:"bid,omitempty"`
+	BranchIds   []int `json:"bids,omitempty"`
+	AllBranches bool  `json:"abr,omitempty"`
+}
+
+// CanAccessBranch reports whether branchId is visible under the claim.
+func (b *BranchClaim) CanAccessBranch(branchId int) bool {
+	if b.AllBranches {
+		return true
+	}
+	for _, id := range b.BranchIds {
+		if id == branchId {
+			return true
+		}
+	}
+	return false
+}
+
+// Subject types keep staff and supplier tokens from being used in place of each other.
+const (
+	SubjectUser          = "user"
+	SubjectSupplier      = "supplier"
+	SubjectTotpChallenge = "totp_challenge"
+	SubjectApiKey        = "api_key"
+	SubjectInvite        = "invite"
+)
+
+const totpChallengeLifespan = 5 * time.Minute
+
+const defaultJwtSecret = "MKitchen-Secret"
+
 var jwtSecret = []byte(getJwtSecret())
 
 func getJwtSecret() string {
 	secret := os.Getenv("API_SECRET")
 	if secret == "" {
-		return "MKitchen-Secret"
+		return defaultJwtSecret
 	}
 	return secret
 }
 
-func JwtGenerate(userID int) (string, error) {
-	token_lifespan, err := strconv.Atoi(os.Getenv("TOKEN_HOUR_LIFESPAN"))
+// IsProduction reports whether APP_ENV is set to production.
+func IsProduction() bool {
+	return os.Getenv("APP_ENV") == "production"
+}
+
+// CheckJwtSecret refuses the built-in secret in production, where it would let anyone mint tokens.
+func CheckJwtSecret() error {
+	if IsProduction() && string(jwtSecret) == defaultJwtSecret {
+		return errors.New("API_SECRET must be set in production")
+	}
+	return nil
+}
+
+func getLifespan(key string, fallback int) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// AccessTokenLifespan is how long an access token stays valid, from ACCESS_TOKEN_MINUTE_LIFESPAN.
+func AccessTokenLifespan() (time.Duration, error) {
+	minutes, err := getLifespan("ACCESS_TOKEN_MINUTE_LIFESPAN", 15)
+	if err != nil {
+		return 0, err
+	}
+	return time.Minute * time.Duration(minutes), nil
+}
+
+// RefreshTokenLifespan is how long a login session can be refreshed, from REFRESH_TOKEN_HOUR_LIFESPAN.
+func RefreshTokenLifespan() (time.Duration, error) {
+	hours, err := getLifespan("REFRESH_TOKEN_HOUR_LIFESPAN", 720)
+	if err != nil {
+		return 0, err
+	}
+	return time.Hour * time.Duration(hours), nil
+}
+
+// MaxTokenLifespan is the longest any signed token stays valid, so a retired key must be kept at least this long.
+func MaxTokenLifespan() (time.Duration, error) {
+	longest := totpChallengeLifespan
+	for _, lifespan := range []func() (time.Duration, error){AccessTokenLifespan, SupplierTokenLifespan, InviteTokenLifespan} {
+		d, err := lifespan()
+		if err != nil {
+			return 0, err
+		}
+		if d > longest {
+			longest = d
+		}
+	}
+	return longest, nil
+}
+
+// SupplierTokenLifespan is how long a supplier portal token stays valid, from SUPPLIER_TOKEN_HOUR_LIFESPAN.
+func SupplierTokenLifespan() (time.Duration, error) {
+	hours, err := getLifespan("SUPPLIER_TOKEN_HOUR_LIFESPAN", 12)
+	if err != nil {
+		return 0, err
+	}
+	return time.Hour * time.Duration(hours), nil
+}
+
+// InviteTokenLifespan is how long an invitation can be accepted, from INVITE_TOKEN_HOUR_LIFESPAN.
+func InviteTokenLifespan() (time.Duration, error) {
+	hours, err := getLifespan("INVITE_TOKEN_HOUR_LIFESPAN", 72)
+	if err != nil {
+		return 0, err
+	}
+	return time.Hour * time.Duration(hours), nil
+}
+
+func JwtGenerate(userID int, sessionID int, branch BranchClaim) (string, error) {
+	lifespan, err := AccessTokenLifespan()
+	if err != nil {
+		return "", err
+	}
+
+	return signClaim(&JwtCustomClaim{
+		ID:          userID,
+		SessionId:   sessionID,
+		SubjectType: SubjectUser,
+		BranchClaim: branch,
+	}, lifespan)
+}
 
+func JwtGenerateSupplier(supplierID int) (string, error) {
+	lifespan, err := SupplierTokenLifespan()
 	if err != nil {
 		return "", err
 	}
 
-	t := jwt.NewWithClaims(jwt.SigningMethodHS256, &JwtCustomClaim{
-		ID:   userID,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(time.Hour * time.Duration(token_lifespan)).Unix(),
-			IssuedAt:  time.Now().Unix(),
-		},
-	})
+	return signClaim(&JwtCustomClaim{
+		ID:          supplierID,
+		SubjectType: SubjectSupplier,
+	}, lifespan)
+}
+
+// JwtGenerateTotpChallenge issues the short-lived token a user holds between the password and TOTP steps.
+func JwtGenerateTotpChallenge(userID int) (string, error) {
+	return signClaim(&JwtCustomClaim{
+		ID:          userID,
+		SubjectType: SubjectTotpChallenge,
+	}, totpChallengeLifespan)
+}
+
+// JwtValidateTotpChallenge returns the user id carried by a valid challenge token.
+func JwtValidateTotpChallenge(token string) (int, error) {
+	validate, err := JwtValidate(token)
+	if err != nil || !validate.Valid {
+		return 0, errors.New("invalid or expired challenge token")
+	}
+
+	claim, ok := validate.Claims.(*JwtCustomClaim)
+	if !ok || claim.SubjectType != SubjectTotpChallenge {
+		return 0, errors.New("invalid or expired challenge token")
+	}
+	return claim.ID, nil
+}
+
+// JwtGenerateInvite issues the token sent to an invited user.
+func JwtGenerateInvite(inviteID int) (string, error) {
+	lifespan, err := InviteTokenLifespan()
+	if err != nil {
+		return "", err
+	}
+
+	return signClaim(&JwtCustomClaim{
+		ID:          inviteID,
+		SubjectType: SubjectInvite,
+	}, lifespan)
+}
+
+// JwtValidateInvite returns the invite id carried by a valid invite token.
+func JwtValidateInvite(token string) (int, error) {
+	validate, err := JwtValidate(token)
+	if err != nil || !validate.Valid {
+		return 0, errors.New("invalid or expired invite token")
+	}
+
+	claim, ok := validate.Claims.(*JwtCustomClaim)
+	if !ok || claim.SubjectType != SubjectInvite {
+		return 0, errors.New("invalid or expired invite token")
+	}
+	return claim.ID, nil
+}
+
+func signClaim(claim *JwtCustomClaim, lifespan time.Duration) (string, error) {
+	claim.StandardClaims = jwt.StandardClaims{
+		ExpiresAt: time.Now().Add(lifespan).Unix(),
+		IssuedAt:  time.Now().Unix(),
+	}
+
+	// Sign with the active keyring key when there is one, otherwise with the HMAC secret
+	if key := activeSigningKey(); key != nil {
+		t := jwt.NewWithClaims(key.method, claim)
+		t.Header["kid"] = key.kid
+		return t.SignedString(key.private)
+	}
+
+	t := jwt.NewWithClaims(jwt.SigningMethodHS256, claim)
 
 	token, err := t.SignedString(jwtSecret)
 	if err != nil {
@@ -50,9 +239,36 @@ func JwtGenerate(userID int) (string, error) {
 
 func JwtValidate(token string) (*jwt.Token, error) {
 	return jwt.ParseWithClaims(token, &JwtCustomClaim{}, func(t *jwt.Token) (interface{}, error) {
+		if kid, ok := t.Header["kid"].(string); ok {
+			key := lookupSigningKey(kid)
+			if key == nil {
+				return nil, fmt.Errorf("unknown signing key")
+			}
+			// The algorithm must be the key's own, never one picked by the token
+			if t.Method.Alg() != key.method.Alg() {
+				return nil, fmt.Errorf("there's a problem with the signing method")
+			}
+			return key.private.Public(), nil
+		}
+
 		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("there's a problem with the signing method")
 		}
 		return jwtSecret, nil
 	})
 }
+
+// GenerateRandomToken returns a hex encoded random string suitable for opaque tokens.
+func GenerateRandomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// HashToken hashes an opaque token so only its digest needs to be stored.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,24 @@
+package utils
+
+import (
+	"os"
+	"strconv"
+)
+
+// ReceiptTolerances are how far a delivery may fall short of a purchase order line and still
+// complete it, and how far it may run over, in percent. They come from
+// RECEIPT_UNDER_TOLERANCE_PERCENT and RECEIPT_OVER_TOLERANCE_PERCENT and default to zero.
+func ReceiptTolerances() (float64, float64) {
+	return getPercent("RECEIPT_UNDER_TOLERANCE_PERCENT"), getPercent("RECEIPT_OVER_TOLERANCE_PERCENT")
+}
+
+func getPercent(key string) float64 {
+	percent, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil || percent < 0 {
+		return 0
+	}
+	if percent > 100 {
+		return 100
+	}
+	return percent
+}
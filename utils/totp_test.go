@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA1 seed from RFC 6238 appendix B, "12345678901234567890", in base32.
+var rfc6238Secret = totpEncoding.EncodeToString([]byte("12345678901234567890"))
+
+// The RFC vectors are eight digits; six-digit codes are their last six.
+var rfc6238Vectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestTotpCodeMatchesRfc6238(t *testing.T) {
+	for _, v := range rfc6238Vectors {
+		got := totpCode([]byte("12345678901234567890"), TotpStep(time.Unix(v.unix, 0)))
+		if got != v.code {
+			t.Errorf("at %d: got %s, want %s", v.unix, got, v.code)
+		}
+	}
+}
+
+func TestValidateTotp(t *testing.T) {
+	for _, v := range rfc6238Vectors {
+		at := time.Unix(v.unix, 0)
+		step, ok := ValidateTotp(rfc6238Secret, v.code, at)
+		if !ok || step != TotpStep(at) {
+			t.Errorf("at %d: got step %d, %v; want step %d", v.unix, step, ok, TotpStep(at))
+		}
+	}
+}
+
+func TestValidateTotpSkew(t *testing.T) {
+	at := time.Unix(1234567890, 0)
+	code := "005924"
+
+	for _, offset := range []int64{-totpPeriod, totpPeriod} {
+		if _, ok := ValidateTotp(rfc6238Secret, code, at.Add(time.Duration(offset)*time.Second)); !ok {
+			t.Errorf("code one step away (%+ds) was refused", offset)
+		}
+	}
+	for _, offset := range []int64{-2 * totpPeriod, 2 * totpPeriod} {
+		if _, ok := ValidateTotp(rfc6238Secret, code, at.Add(time.Duration(offset)*time.Second)); ok {
+			t.Errorf("code two steps away (%+ds) was accepted", offset)
+		}
+	}
+}
+
+func TestValidateTotpRejects(t *testing.T) {
+	at := time.Unix(1234567890, 0)
+
+	if _, ok := ValidateTotp(rfc6238Secret, "005925", at); ok {
+		t.Error("wrong code was accepted")
+	}
+	if _, ok := ValidateTotp("not base32!", "005924", at); ok {
+		t.Error("code was accepted for an invalid secret")
+	}
+	if _, ok := ValidateTotp(rfc6238Secret, "005 924", at); !ok {
+		t.Error("code with a space was refused")
+	}
+}